@@ -0,0 +1,624 @@
+// anthropic package provides middleware for partial compatibility with the Anthropic Messages API
+package anthropic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
+
+// Content is the content of a message. It's either a string or a list of
+// content blocks; a string is decoded as a single text block.
+type Content []ContentBlock
+
+func (c *Content) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = Content{{Type: "text", Text: s}}
+		return nil
+	}
+
+	var blocks []ContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return errors.New("content must be a string or a list of content blocks")
+	}
+
+	*c = blocks
+	return nil
+}
+
+// text returns the text of all text blocks
+func (c Content) text() string {
+	var sb strings.Builder
+	for _, b := range c {
+		if b.Type == "text" {
+			sb.WriteString(b.Text)
+		}
+	}
+
+	return sb.String()
+}
+
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image
+	Source *ImageSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Input any    `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string  `json:"tool_use_id,omitempty"`
+	Content   Content `json:"content,omitempty"`
+	IsError   bool    `json:"is_error,omitempty"`
+}
+
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type Message struct {
+	Role    string  `json:"role"`
+	Content Content `json:"content"`
+}
+
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type MessagesRequest struct {
+	Model         string    `json:"model"`
+	Messages      []Message `json:"messages"`
+	System        Content   `json:"system"`
+	MaxTokens     int       `json:"max_tokens"`
+	StopSequences []string  `json:"stop_sequences"`
+	Stream        bool      `json:"stream"`
+	Temperature   *float64  `json:"temperature"`
+	TopP          *float64  `json:"top_p"`
+	TopK          *int      `json:"top_k"`
+	Tools         []Tool    `json:"tools"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type MessagesResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []ContentBlock `json:"content"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        Usage          `json:"usage"`
+}
+
+type MessageStartEvent struct {
+	Type    string           `json:"type"`
+	Message MessagesResponse `json:"message"`
+}
+
+type ContentBlockStartEvent struct {
+	Type         string       `json:"type"`
+	Index        int          `json:"index"`
+	ContentBlock ContentBlock `json:"content_block"`
+}
+
+type Delta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	PartialJSON string `json:"partial_json,omitempty"`
+}
+
+type ContentBlockDeltaEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta Delta  `json:"delta"`
+}
+
+type ContentBlockStopEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+}
+
+type MessageDelta struct {
+	StopReason   *string `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+type MessageDeltaEvent struct {
+	Type  string       `json:"type"`
+	Delta MessageDelta `json:"delta"`
+	Usage Usage        `json:"usage"`
+}
+
+type MessageStopEvent struct {
+	Type string `json:"type"`
+}
+
+func NewError(code int, message string) ErrorResponse {
+	var etype string
+	switch code {
+	case http.StatusBadRequest:
+		etype = "invalid_request_error"
+	case http.StatusNotFound:
+		etype = "not_found_error"
+	case http.StatusServiceUnavailable:
+		etype = "overloaded_error"
+	default:
+		etype = "api_error"
+	}
+
+	return ErrorResponse{Type: "error", Error: Error{Type: etype, Message: message}}
+}
+
+func randomID(prefix string) string {
+	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 24)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return prefix + string(b)
+}
+
+func toToolUse(tc api.ToolCall) ContentBlock {
+	input := map[string]any(tc.Function.Arguments)
+	if input == nil {
+		input = map[string]any{}
+	}
+
+	return ContentBlock{
+		Type:  "tool_use",
+		ID:    randomID("toolu_"),
+		Name:  tc.Function.Name,
+		Input: input,
+	}
+}
+
+// toStopReason returns the stop reason of a response, and the stop sequence
+// if generation stopped on one
+func toStopReason(r api.ChatResponse, toolUse bool) (*string, *string) {
+	var reason string
+	switch {
+	case toolUse:
+		reason = "tool_use"
+	case r.DoneReason == "length":
+		reason = "max_tokens"
+	case r.StopSequence != "":
+		reason = "stop_sequence"
+		return &reason, &r.StopSequence
+	case r.DoneReason != "":
+		reason = "end_turn"
+	default:
+		return nil, nil
+	}
+
+	return &reason, nil
+}
+
+func toMessagesResponse(id string, r api.ChatResponse) MessagesResponse {
+	content := []ContentBlock{}
+	if r.Message.Content != "" {
+		content = append(content, ContentBlock{Type: "text", Text: r.Message.Content})
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		content = append(content, toToolUse(tc))
+	}
+
+	stopReason, stopSequence := toStopReason(r, len(r.Message.ToolCalls) > 0)
+	return MessagesResponse{
+		ID:           id,
+		Type:         "message",
+		Role:         "assistant",
+		Model:        r.Model,
+		Content:      content,
+		StopReason:   stopReason,
+		StopSequence: stopSequence,
+		Usage: Usage{
+			InputTokens:  r.PromptEvalCount,
+			OutputTokens: r.EvalCount,
+		},
+	}
+}
+
+func fromTool(t Tool) (api.Tool, error) {
+	tool := api.Tool{Type: "function"}
+	tool.Function.Name = t.Name
+	tool.Function.Description = t.Description
+
+	if len(t.InputSchema) > 0 {
+		if err := json.Unmarshal(t.InputSchema, &tool.Function.Parameters); err != nil {
+			return api.Tool{}, fmt.Errorf("invalid input_schema for tool %q: %w", t.Name, err)
+		}
+	}
+
+	return tool, nil
+}
+
+func fromImage(source *ImageSource) (api.ImageData, error) {
+	if source == nil || source.Type != "base64" {
+		return nil, errors.New("image source must be base64 encoded")
+	}
+
+	switch source.MediaType {
+	case "image/jpeg", "image/png":
+	default:
+		return nil, fmt.Errorf("unsupported image media type %q", source.MediaType)
+	}
+
+	img, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return nil, errors.New("invalid image data")
+	}
+
+	return img, nil
+}
+
+func fromMessagesRequest(r MessagesRequest) (*api.ChatRequest, error) {
+	var messages []api.Message
+	if system := r.System.text(); system != "" {
+		messages = append(messages, api.Message{Role: "system", Content: system})
+	}
+
+	for _, msg := range r.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return nil, fmt.Errorf("invalid message role %q", msg.Role)
+		}
+
+		// text, images and tool calls of a message are combined into a single
+		// message, tool results are sent as separate tool messages
+		m := api.Message{Role: msg.Role}
+		for _, block := range msg.Content {
+			switch block.Type {
+			case "text":
+				m.Content += block.Text
+			case "image":
+				img, err := fromImage(block.Source)
+				if err != nil {
+					return nil, err
+				}
+
+				m.Images = append(m.Images, img)
+			case "tool_use":
+				var args api.ToolCallFunctionArguments
+				if block.Input != nil {
+					bts, err := json.Marshal(block.Input)
+					if err != nil {
+						return nil, err
+					}
+
+					if err := json.Unmarshal(bts, &args); err != nil {
+						return nil, errors.New("invalid tool_use input")
+					}
+				}
+
+				m.ToolCalls = append(m.ToolCalls, api.ToolCall{Function: api.ToolCallFunction{Name: block.Name, Arguments: args}})
+			case "tool_result":
+				// the model only sees the content of tool messages, so failed
+				// tool calls are marked in it
+				content := block.Content.text()
+				if block.IsError {
+					content = "Error: " + content
+				}
+
+				messages = append(messages, api.Message{Role: "tool", Content: content})
+			default:
+				return nil, fmt.Errorf("unsupported content block type %q", block.Type)
+			}
+		}
+
+		if m.Content != "" || len(m.Images) > 0 || len(m.ToolCalls) > 0 {
+			messages = append(messages, m)
+		}
+	}
+
+	options := make(map[string]any)
+	options["num_predict"] = r.MaxTokens
+
+	if len(r.StopSequences) > 0 {
+		options["stop"] = r.StopSequences
+	}
+
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	}
+
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	}
+
+	if r.TopK != nil {
+		options["top_k"] = *r.TopK
+	}
+
+	var tools api.Tools
+	for _, t := range r.Tools {
+		tool, err := fromTool(t)
+		if err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, tool)
+	}
+
+	return &api.ChatRequest{
+		Model:    r.Model,
+		Messages: messages,
+		Options:  options,
+		Stream:   &r.Stream,
+		Tools:    tools,
+	}, nil
+}
+
+type MessagesWriter struct {
+	gin.ResponseWriter
+	stream bool
+	id     string
+
+	// streaming state
+	started  bool
+	index    int
+	textOpen bool
+	toolUse  bool
+}
+
+func (w *MessagesWriter) writeError(code int, data []byte) (int, error) {
+	var serr api.StatusError
+	err := json.Unmarshal(data, &serr)
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(NewError(code, serr.Error()))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *MessagesWriter) writeEvent(event string, data any) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+	_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, d)))
+	return err
+}
+
+// closeText ends the open text block, if any
+func (w *MessagesWriter) closeText() error {
+	if !w.textOpen {
+		return nil
+	}
+
+	w.textOpen = false
+	w.index++
+	return w.writeEvent("content_block_stop", ContentBlockStopEvent{Type: "content_block_stop", Index: w.index - 1})
+}
+
+func (w *MessagesWriter) writeStream(r api.ChatResponse) error {
+	if !w.started {
+		w.started = true
+		if err := w.writeEvent("message_start", MessageStartEvent{
+			Type: "message_start",
+			Message: MessagesResponse{
+				ID:      w.id,
+				Type:    "message",
+				Role:    "assistant",
+				Model:   r.Model,
+				Content: []ContentBlock{},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if r.Message.Content != "" {
+		if !w.textOpen {
+			w.textOpen = true
+			if err := w.writeEvent("content_block_start", ContentBlockStartEvent{
+				Type:         "content_block_start",
+				Index:        w.index,
+				ContentBlock: ContentBlock{Type: "text", Text: ""},
+			}); err != nil {
+				return err
+			}
+		}
+
+		if err := w.writeEvent("content_block_delta", ContentBlockDeltaEvent{
+			Type:  "content_block_delta",
+			Index: w.index,
+			Delta: Delta{Type: "text_delta", Text: r.Message.Content},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// tool calls are complete when they're received so each is sent as a
+	// block with a single delta
+	for _, tc := range r.Message.ToolCalls {
+		if err := w.closeText(); err != nil {
+			return err
+		}
+
+		block := toToolUse(tc)
+		input, err := json.Marshal(block.Input)
+		if err != nil {
+			return err
+		}
+
+		block.Input = map[string]any{}
+		if err := w.writeEvent("content_block_start", ContentBlockStartEvent{Type: "content_block_start", Index: w.index, ContentBlock: block}); err != nil {
+			return err
+		}
+
+		if err := w.writeEvent("content_block_delta", ContentBlockDeltaEvent{
+			Type:  "content_block_delta",
+			Index: w.index,
+			Delta: Delta{Type: "input_json_delta", PartialJSON: string(input)},
+		}); err != nil {
+			return err
+		}
+
+		if err := w.writeEvent("content_block_stop", ContentBlockStopEvent{Type: "content_block_stop", Index: w.index}); err != nil {
+			return err
+		}
+
+		w.index++
+		w.toolUse = true
+	}
+
+	if r.Done {
+		if err := w.closeText(); err != nil {
+			return err
+		}
+
+		stopReason, stopSequence := toStopReason(r, w.toolUse)
+		if err := w.writeEvent("message_delta", MessageDeltaEvent{
+			Type:  "message_delta",
+			Delta: MessageDelta{StopReason: stopReason, StopSequence: stopSequence},
+			Usage: Usage{InputTokens: r.PromptEvalCount, OutputTokens: r.EvalCount},
+		}); err != nil {
+			return err
+		}
+
+		return w.writeEvent("message_stop", MessageStopEvent{Type: "message_stop"})
+	}
+
+	return nil
+}
+
+func (w *MessagesWriter) writeResponse(data []byte) (int, error) {
+	var chatResponse struct {
+		api.ChatResponse
+		Error string `json:"error,omitempty"`
+	}
+	err := json.Unmarshal(data, &chatResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	if w.stream {
+		// errors after the stream has started are sent as error events
+		if chatResponse.Error != "" {
+			if err := w.writeEvent("error", NewError(http.StatusInternalServerError, chatResponse.Error)); err != nil {
+				return 0, err
+			}
+
+			return len(data), nil
+		}
+
+		if err := w.writeStream(chatResponse.ChatResponse); err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toMessagesResponse(w.id, chatResponse.ChatResponse))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *MessagesWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	return w.writeResponse(data)
+}
+
+const priorityHeader = "X-Ollama-Priority"
+
+func MessagesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MessagesRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		if len(req.Messages) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "messages: at least one message is required"))
+			return
+		}
+
+		if req.MaxTokens <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "max_tokens: must be greater than 0"))
+			return
+		}
+
+		chatReq, err := fromMessagesRequest(req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		chatReq.Priority = c.GetHeader(priorityHeader)
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &MessagesWriter{
+			ResponseWriter: c.Writer,
+			stream:         req.Stream,
+			id:             randomID("msg_"),
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
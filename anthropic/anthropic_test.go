@@ -0,0 +1,400 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+const image = `iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNk+A8AAQUBAScY42YAAAAASUVORK5CYII=`
+
+func prepareRequest(req *http.Request, body string) {
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+}
+
+func captureRequestMiddleware(capturedRequest any) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bodyBytes, _ := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		err := json.Unmarshal(bodyBytes, capturedRequest)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to unmarshal request")
+		}
+		c.Next()
+	}
+}
+
+func TestMessagesMiddleware(t *testing.T) {
+	img, _ := base64.StdEncoding.DecodeString(image)
+	stream := false
+
+	cases := []struct {
+		name string
+		body string
+		want *api.ChatRequest
+		err  string
+	}{
+		{
+			name: "text",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"system": "You are a helpful assistant.",
+				"messages": [{"role": "user", "content": "Hello"}],
+				"stop_sequences": ["\n\n"],
+				"temperature": 0.5
+			}`,
+			want: &api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{Role: "system", Content: "You are a helpful assistant."},
+					{Role: "user", Content: "Hello"},
+				},
+				Options: map[string]any{"num_predict": float64(100), "stop": []any{"\n\n"}, "temperature": 0.5},
+				Stream:  &stream,
+			},
+		},
+		{
+			name: "content blocks",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"system": [{"type": "text", "text": "Describe images."}],
+				"messages": [{"role": "user", "content": [
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "` + image + `"}},
+					{"type": "text", "text": "What is this?"}
+				]}]
+			}`,
+			want: &api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{Role: "system", Content: "Describe images."},
+					{Role: "user", Content: "What is this?", Images: []api.ImageData{img}},
+				},
+				Options: map[string]any{"num_predict": float64(100)},
+				Stream:  &stream,
+			},
+		},
+		{
+			name: "tools",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"stream": true,
+				"tools": [{
+					"name": "get_weather",
+					"description": "Get the current weather",
+					"input_schema": {"type": "object", "properties": {"location": {"type": "string"}}, "required": ["location"]}
+				}],
+				"messages": [
+					{"role": "user", "content": "What's the weather in Paris?"},
+					{"role": "assistant", "content": [
+						{"type": "text", "text": "Let me check."},
+						{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"location": "Paris"}}
+					]},
+					{"role": "user", "content": [
+						{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "15 degrees"}]},
+						{"type": "tool_result", "tool_use_id": "toolu_2", "content": "location not found", "is_error": true},
+						{"type": "text", "text": "Thanks!"}
+					]}
+				]
+			}`,
+			want: func() *api.ChatRequest {
+				stream := true
+				tool := api.Tool{Type: "function"}
+				tool.Function.Name = "get_weather"
+				tool.Function.Description = "Get the current weather"
+				tool.Function.Parameters.Type = "object"
+				tool.Function.Parameters.Required = []string{"location"}
+				tool.Function.Parameters.Properties = map[string]struct {
+					Type        string   `json:"type"`
+					Description string   `json:"description"`
+					Enum        []string `json:"enum,omitempty"`
+				}{"location": {Type: "string"}}
+
+				return &api.ChatRequest{
+					Model: "test-model",
+					Messages: []api.Message{
+						{Role: "user", Content: "What's the weather in Paris?"},
+						{Role: "assistant", Content: "Let me check.", ToolCalls: []api.ToolCall{
+							{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"location": "Paris"}}},
+						}},
+						{Role: "tool", Content: "15 degrees"},
+						{Role: "tool", Content: "Error: location not found"},
+						{Role: "user", Content: "Thanks!"},
+					},
+					Options: map[string]any{"num_predict": float64(100)},
+					Stream:  &stream,
+					Tools:   api.Tools{tool},
+				}
+			}(),
+		},
+		{
+			name: "missing max tokens",
+			body: `{"model": "test-model", "messages": [{"role": "user", "content": "Hello"}]}`,
+			err:  "max_tokens",
+		},
+		{
+			name: "missing messages",
+			body: `{"model": "test-model", "max_tokens": 100, "messages": []}`,
+			err:  "at least one message",
+		},
+		{
+			name: "invalid role",
+			body: `{"model": "test-model", "max_tokens": 100, "messages": [{"role": "system", "content": "Hello"}]}`,
+			err:  `invalid message role "system"`,
+		},
+		{
+			name: "unsupported block",
+			body: `{"model": "test-model", "max_tokens": 100, "messages": [{"role": "user", "content": [{"type": "document"}]}]}`,
+			err:  `unsupported content block type "document"`,
+		},
+		{
+			name: "unsupported image",
+			body: `{"model": "test-model", "max_tokens": 100, "messages": [{"role": "user", "content": [
+				{"type": "image", "source": {"type": "base64", "media_type": "image/webp", "data": "` + image + `"}}
+			]}]}`,
+			err: `unsupported image media type "image/webp"`,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var captured *api.ChatRequest
+			router := gin.New()
+			router.Use(MessagesMiddleware(), captureRequestMiddleware(&captured))
+			router.Handle(http.MethodPost, "/v1/messages", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest(http.MethodPost, "/v1/messages", nil)
+			prepareRequest(req, tt.body)
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			if tt.err != "" {
+				if resp.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", resp.Code)
+				}
+
+				var errResp ErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+					t.Fatal(err)
+				}
+
+				if errResp.Type != "error" || errResp.Error.Type != "invalid_request_error" || !strings.Contains(errResp.Error.Message, tt.err) {
+					t.Fatalf("unexpected error %+v", errResp)
+				}
+				return
+			}
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+			}
+
+			if diff := cmp.Diff(captured, tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+type event struct {
+	Event string
+	Data  map[string]any
+}
+
+func readEvents(t *testing.T, r io.Reader) []event {
+	t.Helper()
+
+	var events []event
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range strings.Split(strings.TrimSpace(string(bts)), "\n\n") {
+		name, data, ok := strings.Cut(chunk, "\n")
+		if !ok {
+			t.Fatalf("invalid event %q", chunk)
+		}
+
+		var e event
+		e.Event = strings.TrimPrefix(name, "event: ")
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &e.Data); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestMessagesResponses(t *testing.T) {
+	responses := []api.ChatResponse{
+		{Model: "test-model", Message: api.Message{Role: "assistant", Content: "Let me "}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", Content: "check."}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"location": "Paris"}}},
+		}}},
+		{Model: "test-model", Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 10, EvalCount: 5}},
+	}
+
+	endpoint := func(c *gin.Context) {
+		for _, r := range responses {
+			bts, _ := json.Marshal(r)
+			c.Writer.Write(bts)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(http.MethodPost, "/v1/messages", MessagesMiddleware(), endpoint)
+	router.Handle(http.MethodPost, "/v1/messages/missing", MessagesMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": `model "missing" not found, try pulling it first`})
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", nil)
+		prepareRequest(req, `{"model": "test-model", "max_tokens": 100, "stream": true, "messages": [{"role": "user", "content": "Hello"}]}`)
+
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.Code)
+		}
+
+		if ct := resp.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("expected text/event-stream, got %s", ct)
+		}
+
+		events := readEvents(t, resp.Body)
+
+		var names []string
+		for _, e := range events {
+			names = append(names, e.Event)
+			if e.Data["type"] != e.Event {
+				t.Errorf("expected type %s, got %v", e.Event, e.Data["type"])
+			}
+		}
+
+		if diff := cmp.Diff(names, []string{
+			"message_start",
+			"content_block_start",
+			"content_block_delta",
+			"content_block_delta",
+			"content_block_stop",
+			"content_block_start",
+			"content_block_delta",
+			"content_block_stop",
+			"message_delta",
+			"message_stop",
+		}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(events[3].Data["delta"], map[string]any{"type": "text_delta", "text": "check."}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		block := events[5].Data["content_block"].(map[string]any)
+		if block["type"] != "tool_use" || block["name"] != "get_weather" || events[5].Data["index"] != float64(1) {
+			t.Errorf("unexpected tool_use block %v", events[5].Data)
+		}
+
+		if diff := cmp.Diff(events[6].Data["delta"], map[string]any{"type": "input_json_delta", "partial_json": `{"location":"Paris"}`}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(events[8].Data, map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": "tool_use", "stop_sequence": nil},
+			"usage": map[string]any{"input_tokens": float64(10), "output_tokens": float64(5)},
+		}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages/missing", nil)
+		prepareRequest(req, `{"model": "missing", "max_tokens": 100, "messages": [{"role": "user", "content": "Hello"}]}`)
+
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", resp.Code)
+		}
+
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			t.Fatal(err)
+		}
+
+		if errResp.Error.Type != "not_found_error" {
+			t.Errorf("expected not_found_error, got %s", errResp.Error.Type)
+		}
+	})
+}
+
+func TestToMessagesResponse(t *testing.T) {
+	got := toMessagesResponse("msg_1", api.ChatResponse{
+		Model:      "test-model",
+		Message:    api.Message{Role: "assistant", Content: "Hello!"},
+		Done:       true,
+		DoneReason: "length",
+		Metrics:    api.Metrics{PromptEvalCount: 10, EvalCount: 5},
+	})
+
+	reason := "max_tokens"
+	if diff := cmp.Diff(got, MessagesResponse{
+		ID:         "msg_1",
+		Type:       "message",
+		Role:       "assistant",
+		Model:      "test-model",
+		Content:    []ContentBlock{{Type: "text", Text: "Hello!"}},
+		StopReason: &reason,
+		Usage:      Usage{InputTokens: 10, OutputTokens: 5},
+	}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestToMessagesResponseStopSequence(t *testing.T) {
+	got := toMessagesResponse("msg_1", api.ChatResponse{
+		Model:        "test-model",
+		Message:      api.Message{Role: "assistant", Content: "Hello!"},
+		Done:         true,
+		DoneReason:   "stop",
+		StopSequence: "\n\n",
+	})
+
+	reason, sequence := "stop_sequence", "\n\n"
+	if diff := cmp.Diff(got, MessagesResponse{
+		ID:           "msg_1",
+		Type:         "message",
+		Role:         "assistant",
+		Model:        "test-model",
+		Content:      []ContentBlock{{Type: "text", Text: "Hello!"}},
+		StopReason:   &reason,
+		StopSequence: &sequence,
+	}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
@@ -14,6 +14,7 @@
 package api
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
 	"context"
@@ -23,7 +24,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path"
 	"runtime"
+	"strings"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
@@ -132,18 +135,22 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 const maxBufferSize = 512 * format.KiloByte
 
 func (c *Client) stream(ctx context.Context, method, path string, data any, fn func([]byte) error) error {
-	var buf *bytes.Buffer
-	if data != nil {
+	var body io.Reader
+	switch data := data.(type) {
+	case io.Reader:
+		body = data
+	case nil:
+	default:
 		bts, err := json.Marshal(data)
 		if err != nil {
 			return err
 		}
 
-		buf = bytes.NewBuffer(bts)
+		body = bytes.NewBuffer(bts)
 	}
 
 	requestURL := c.base.JoinPath(path)
-	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), buf)
+	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), body)
 	if err != nil {
 		return err
 	}
@@ -269,6 +276,122 @@ func (c *Client) Push(ctx context.Context, req *PushRequest, fn PushProgressFunc
 	})
 }
 
+// ExportProgressFunc is a function that [Client.Export] invokes as each blob
+// of the model is written.
+// It's similar to other progress function types like [PullProgressFunc].
+type ExportProgressFunc func(ProgressResponse) error
+
+// Export writes a model, its manifest and blobs to w as a tar archive in the
+// OCI image layout, which [Client.Import] installs. fn is called to report
+// progress.
+func (c *Client) Export(ctx context.Context, req *ExportRequest, w io.Writer, fn ExportProgressFunc) error {
+	bts, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	requestURL := c.base.JoinPath("/api/export")
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL.String(), bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/x-tar")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return checkError(response, body)
+	}
+
+	return copyArchive(w, response.Body, "exporting", fn)
+}
+
+// ImportProgressFunc is a function that [Client.Import] invokes as each blob
+// of the archive is uploaded, and then with the server's progress.
+// It's similar to other progress function types like [PullProgressFunc].
+type ImportProgressFunc func(ProgressResponse) error
+
+// Import installs the models in r, a tar archive written by [Client.Export].
+// The server verifies the digest of every blob. fn is called to report
+// progress.
+func (c *Client) Import(ctx context.Context, r io.Reader, fn ImportProgressFunc) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(copyArchive(pw, r, "importing", fn))
+	}()
+
+	err := c.stream(ctx, http.MethodPost, "/api/import", pr, func(bts []byte) error {
+		var resp ProgressResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+
+	// stop the upload if the request ended early
+	pr.CloseWithError(err)
+	<-done
+	return err
+}
+
+// copyArchive copies the tar archive r to w, calling fn with the progress of
+// each blob in it
+func copyArchive(w io.Writer, r io.Reader, status string, fn func(ProgressResponse) error) error {
+	tee := io.TeeReader(r, w)
+	tr := tar.NewReader(tee)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		digest, ok := strings.CutPrefix(path.Clean(hdr.Name), "blobs/sha256/")
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		resp := ProgressResponse{Status: status, Digest: "sha256:" + digest, Total: hdr.Size}
+		if err := fn(resp); err != nil {
+			return err
+		}
+
+		for resp.Completed < resp.Total {
+			n, err := io.CopyN(io.Discard, tr, min(resp.Total-resp.Completed, 1<<20))
+			if err != nil {
+				return err
+			}
+
+			resp.Completed += n
+			if err := fn(resp); err != nil {
+				return err
+			}
+		}
+	}
+
+	// copy the end of the archive
+	_, err := io.Copy(io.Discard, tee)
+	return err
+}
+
 // CreateProgressFunc is a function that [Client.Create] invokes when progress
 // is made.
 // It's similar to other progress function types like [PullProgressFunc].
@@ -307,6 +430,131 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// CreateConversation creates a conversation stored by the server.
+func (c *Client) CreateConversation(ctx context.Context, req *CreateConversationRequest) (*Conversation, error) {
+	var resp Conversation
+	if err := c.do(ctx, http.MethodPost, "/api/conversations", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListConversations lists the stored conversations, most recently updated
+// first. Messages are not included.
+func (c *Client) ListConversations(ctx context.Context) (*ListConversationsResponse, error) {
+	var resp ListConversationsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/conversations", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetConversation obtains a stored conversation, including its messages.
+func (c *Client) GetConversation(ctx context.Context, id string) (*Conversation, error) {
+	var resp Conversation
+	if err := c.do(ctx, http.MethodGet, "/api/conversations/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteConversation deletes a stored conversation.
+func (c *Client) DeleteConversation(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/conversations/"+url.PathEscape(id), nil, nil)
+}
+
+// ForkConversation copies a stored conversation, or the start of it, to a
+// new conversation.
+func (c *Client) ForkConversation(ctx context.Context, id string, req *ForkConversationRequest) (*Conversation, error) {
+	var resp Conversation
+	if err := c.do(ctx, http.MethodPost, "/api/conversations/"+url.PathEscape(id)+"/fork", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateCollection creates a collection of documents embedded with a model.
+func (c *Client) CreateCollection(ctx context.Context, req *CreateCollectionRequest) (*Collection, error) {
+	var resp Collection
+	if err := c.do(ctx, http.MethodPost, "/api/collections", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListCollections lists the collections stored by the server.
+func (c *Client) ListCollections(ctx context.Context) (*ListCollectionsResponse, error) {
+	var resp ListCollectionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/collections", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetCollection obtains a collection's details.
+func (c *Client) GetCollection(ctx context.Context, name string) (*Collection, error) {
+	var resp Collection
+	if err := c.do(ctx, http.MethodGet, "/api/collections/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteCollection deletes a collection and its documents.
+func (c *Client) DeleteCollection(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/collections/"+url.PathEscape(name), nil, nil)
+}
+
+// AddDocuments embeds documents and adds them to a collection.
+func (c *Client) AddDocuments(ctx context.Context, name string, req *AddDocumentsRequest) (*AddDocumentsResponse, error) {
+	var resp AddDocumentsResponse
+	if err := c.do(ctx, http.MethodPost, "/api/collections/"+url.PathEscape(name)+"/documents", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteDocument deletes a document from a collection.
+func (c *Client) DeleteDocument(ctx context.Context, name, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/collections/"+url.PathEscape(name)+"/documents/"+url.PathEscape(id), nil, nil)
+}
+
+// QueryCollection returns the documents of a collection most similar to a
+// query.
+func (c *Client) QueryCollection(ctx context.Context, name string, req *QueryCollectionRequest) (*QueryCollectionResponse, error) {
+	var resp QueryCollectionResponse
+	if err := c.do(ctx, http.MethodPost, "/api/collections/"+url.PathEscape(name)+"/query", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Load loads a model into memory without generating a response. It returns
+// once the model is ready.
+func (c *Client) Load(ctx context.Context, req *LoadRequest) (*LoadResponse, error) {
+	var resp LoadResponse
+	if err := c.do(ctx, http.MethodPost, "/api/load", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Unload unloads a model from memory. It returns once the model's
+// in-flight requests have finished and it has been unloaded.
+func (c *Client) Unload(ctx context.Context, req *UnloadRequest) (*UnloadResponse, error) {
+	var resp UnloadResponse
+	if err := c.do(ctx, http.MethodPost, "/api/unload", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelRequest cancels the in-flight generate, chat or embed request with
+// the given ID.
+func (c *Client) CancelRequest(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/requests/"+url.PathEscape(id), nil, nil)
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -324,6 +572,11 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+// DeleteSession removes the saved prompt cache of a model's session.
+func (c *Client) DeleteSession(ctx context.Context, req *DeleteSessionRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/sessions", req, nil)
+}
+
 // Show obtains model information, including details, modelfile, license etc.
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
@@ -360,6 +613,33 @@ func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*Embedd
 	return &resp, nil
 }
 
+// Rerank ranks documents by their relevance to a query.
+func (c *Client) Rerank(ctx context.Context, req *RerankRequest) (*RerankResponse, error) {
+	var resp RerankResponse
+	if err := c.do(ctx, http.MethodPost, "/api/rerank", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Tokenize converts text into tokens using the model's tokenizer.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detokenize converts tokens back into text using the model's tokenizer.
+func (c *Client) Detokenize(ctx context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	var resp DetokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/detokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // CreateBlob creates a blob from a file on the server. digest is the
 // expected SHA256 digest of the file, and r represents the file.
 func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) error {
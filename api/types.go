@@ -47,6 +47,10 @@ type GenerateRequest struct {
 	// Prompt is the textual prompt to send to the model.
 	Prompt string `json:"prompt"`
 
+	// Tokens is a prompt of token IDs to send to the model instead of
+	// Prompt. Like a raw prompt, no formatting is applied to it.
+	Tokens []int `json:"tokens,omitempty"`
+
 	// Suffix is the text that comes after the inserted text.
 	Suffix string `json:"suffix"`
 
@@ -66,13 +70,31 @@ type GenerateRequest struct {
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
-	// Format specifies the format to return a response in.
-	Format string `json:"format"`
+	// Format specifies the format to return a response in. It is either the
+	// string "json" or a JSON schema object the response must conform to.
+	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded in memory following
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// Priority is the priority class of the request: "high", "normal" or
+	// "low". Higher priority requests are scheduled first; it is "normal"
+	// by default.
+	Priority string `json:"priority,omitempty"`
+
+	// Session is an optional key to save the prompt cache under. Requests
+	// with the same session reuse the cache even after the model has been
+	// unloaded and loaded again.
+	Session string `json:"session,omitempty"`
+
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternatives to return with
+	// each token's log probability. It implies Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
 	// Images is an optional list of base64-encoded images accompanying this
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
@@ -93,8 +115,8 @@ type ChatRequest struct {
 	// Stream enable streaming of returned response; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
-	// Format is the format to return the response in (e.g. "json").
-	Format string `json:"format"`
+	// Format is the format to return the response in, as in [GenerateRequest].
+	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded into memory
 	// followin the request.
@@ -103,6 +125,31 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// ToolChoice controls how the model uses Tools: "auto" (the default)
+	// lets the model decide, "none" disables tools and "required" makes the
+	// model call at least one tool. Any other value is the name of the tool
+	// the model must call.
+	ToolChoice string `json:"tool_choice,omitempty"`
+
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternatives to return with
+	// each token's log probability. It implies Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// Priority is the priority class of the request, as in [GenerateRequest].
+	Priority string `json:"priority,omitempty"`
+
+	// ConversationID is the ID of a stored conversation. If set, Messages
+	// are appended to the conversation's history, and the history is used
+	// to build the prompt. The reply is also saved to the conversation.
+	ConversationID string `json:"conversation_id,omitempty"`
+
+	// Session is the key to save the prompt cache under, as in
+	// [GenerateRequest].
+	Session string `json:"session,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -189,11 +236,38 @@ type ChatResponse struct {
 	Message    Message   `json:"message"`
 	DoneReason string    `json:"done_reason,omitempty"`
 
+	// StopSequence is the stop sequence generation stopped on, as in
+	// [GenerateResponse].
+	StopSequence string `json:"stop_sequence,omitempty"`
+
 	Done bool `json:"done"`
 
+	// RequestID identifies the request, as in [GenerateResponse].
+	RequestID string `json:"request_id,omitempty"`
+
+	// Logprobs are the log probabilities of the tokens in Message, if
+	// requested.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
+// Logprob is the log probability of a generated token.
+type Logprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+
+	// TopLogprobs are the most likely tokens at this position, most likely
+	// first.
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
+}
+
+// TokenLogprob is the log probability of a token.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
 type Metrics struct {
 	TotalDuration      time.Duration `json:"total_duration,omitempty"`
 	LoadDuration       time.Duration `json:"load_duration,omitempty"`
@@ -258,15 +332,72 @@ type EmbedRequest struct {
 
 	Truncate *bool `json:"truncate,omitempty"`
 
+	// Dimensions truncates each embedding to its first Dimensions values,
+	// which are then normalized again. This is only meaningful for models
+	// trained with Matryoshka representation learning.
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// EncodingFormat is the format of the returned embeddings, either "float"
+	// (the default) or "base64". Base64 embeddings are returned in
+	// [EmbedResponse.EmbeddingsBase64] as little-endian float32 values.
+	EncodingFormat string `json:"encoding_format,omitempty"`
+
+	// Chunking splits long inputs into chunks that are each embedded,
+	// instead of truncating them. Truncate is ignored if it is set.
+	Chunking *EmbedChunking `json:"chunking,omitempty"`
+
+	// Priority is the priority class of the request, as in [GenerateRequest].
+	Priority string `json:"priority,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
 
+// EmbedChunking controls how [EmbedRequest] inputs are split into chunks.
+type EmbedChunking struct {
+	// Size is the maximum number of tokens in a chunk. It defaults to, and
+	// is limited by, the model's context length less room for the special
+	// tokens the model adds.
+	Size int `json:"size,omitempty"`
+
+	// Overlap is the number of tokens at the end of a chunk that are
+	// repeated at the start of the next one.
+	Overlap int `json:"overlap,omitempty"`
+
+	// Pooling is how the embeddings of an input's chunks are combined:
+	// "mean" (the default) or "max" return a single embedding per input in
+	// [EmbedResponse.Embeddings], while "none" returns every chunk's
+	// embedding in [EmbedResponse.Chunks].
+	Pooling string `json:"pooling,omitempty"`
+}
+
+// EmbedChunk is the embedding of a chunk of an input.
+type EmbedChunk struct {
+	// Start and End are the byte offsets of the start and the end of the
+	// chunk in the input.
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	Embedding []float32 `json:"embedding,omitempty"`
+
+	// EmbeddingBase64 holds the embedding when the request's
+	// EncodingFormat is "base64".
+	EmbeddingBase64 string `json:"embedding_base64,omitempty"`
+}
+
 // EmbedResponse is the response from [Client.Embed].
 type EmbedResponse struct {
 	Model      string      `json:"model"`
 	Embeddings [][]float32 `json:"embeddings"`
 
+	// EmbeddingsBase64 holds the embeddings when the request's EncodingFormat
+	// is "base64".
+	EmbeddingsBase64 []string `json:"embeddings_base64,omitempty"`
+
+	// Chunks holds the embeddings of each input's chunks, in order, when
+	// the request's chunking pooling is "none".
+	Chunks [][]EmbedChunk `json:"chunks,omitempty"`
+
 	TotalDuration   time.Duration `json:"total_duration,omitempty"`
 	LoadDuration    time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
@@ -293,6 +424,102 @@ type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
 }
 
+// RerankRequest is the request passed to [Client.Rerank].
+type RerankRequest struct {
+	// Model is the model name. Cross-encoder reranker models score each
+	// document together with the query; embedding models score documents
+	// by the cosine similarity of their embeddings to the query's.
+	Model string `json:"model"`
+
+	// Query is the query documents are ranked against.
+	Query string `json:"query"`
+
+	// Documents are the documents to rank.
+	Documents []string `json:"documents"`
+
+	// TopN limits the results to the TopN most relevant documents. Zero
+	// returns all documents.
+	TopN int `json:"top_n,omitempty"`
+
+	// ReturnDocuments includes each document's text in its result.
+	ReturnDocuments bool `json:"return_documents,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// RerankResult is the relevance of a document to the query.
+type RerankResult struct {
+	// Index is the position of the document in [RerankRequest.Documents].
+	Index int `json:"index"`
+
+	// Document is the document's text, if requested.
+	Document string `json:"document,omitempty"`
+
+	// RelevanceScore is between 0 and 1 for reranker models, and the cosine
+	// similarity between -1 and 1 for embedding models.
+	RelevanceScore float32 `json:"relevance_score"`
+}
+
+// RerankResponse is the response from [Client.Rerank].
+type RerankResponse struct {
+	Model string `json:"model"`
+
+	// Results are sorted most relevant first.
+	Results []RerankResult `json:"results"`
+
+	TotalDuration time.Duration `json:"total_duration,omitempty"`
+	LoadDuration  time.Duration `json:"load_duration,omitempty"`
+}
+
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Text is the text to tokenize.
+	Text string `json:"text"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model  string `json:"model"`
+	Tokens []int  `json:"tokens"`
+}
+
+// DetokenizeRequest is the request passed to [Client.Detokenize].
+type DetokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Tokens is the list of tokens to convert back into text.
+	Tokens []int `json:"tokens"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// DetokenizeResponse is the response from [Client.Detokenize].
+type DetokenizeResponse struct {
+	Model string `json:"model"`
+	Text  string `json:"text"`
+}
+
 // CreateRequest is the request passed to [Client.Create].
 type CreateRequest struct {
 	Model     string `json:"model"`
@@ -316,6 +543,12 @@ type DeleteRequest struct {
 	Name string `json:"name"`
 }
 
+// DeleteSessionRequest is the request passed to [Client.DeleteSession].
+type DeleteSessionRequest struct {
+	Model   string `json:"model"`
+	Session string `json:"session"`
+}
+
 // ShowRequest is the request passed to [Client.Show].
 type ShowRequest struct {
 	Model  string `json:"model"`
@@ -370,6 +603,16 @@ type ProgressResponse struct {
 	Digest    string `json:"digest,omitempty"`
 	Total     int64  `json:"total,omitempty"`
 	Completed int64  `json:"completed,omitempty"`
+
+	// Mirror is the host of the registry mirror a blob is pulled from, if
+	// it isn't pulled from the registry itself.
+	Mirror string `json:"mirror,omitempty"`
+}
+
+// ExportRequest is the request passed to [Client.Export].
+type ExportRequest struct {
+	// Model is the name of the model to export.
+	Model string `json:"model"`
 }
 
 // PushRequest is the request passed to [Client.Push].
@@ -380,6 +623,10 @@ type PushRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// Sign pushes a signature of the manifest, made with the server's key,
+	// which pulls verify against their trusted keys.
+	Sign bool `json:"sign,omitempty"`
+
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
@@ -413,6 +660,186 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+	Pinned    bool         `json:"pinned,omitempty"`
+}
+
+// Conversation is a chat history stored by the server.
+type Conversation struct {
+	ID string `json:"id"`
+
+	// Model is the model the conversation was created with, if any.
+	Model string `json:"model,omitempty"`
+
+	// Messages is the conversation history. It is omitted when listing
+	// conversations.
+	Messages []Message `json:"messages,omitempty"`
+
+	// ParentID is the ID of the conversation this one was forked from.
+	ParentID string `json:"parent_id,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateConversationRequest is the request passed to
+// [Client.CreateConversation].
+type CreateConversationRequest struct {
+	Model    string    `json:"model,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// ForkConversationRequest is the request passed to
+// [Client.ForkConversation].
+type ForkConversationRequest struct {
+	// MessageCount is the number of messages to copy to the new
+	// conversation. All messages are copied if it is zero.
+	MessageCount int `json:"message_count,omitempty"`
+}
+
+// ListConversationsResponse is the response from [Client.ListConversations].
+type ListConversationsResponse struct {
+	Conversations []Conversation `json:"conversations"`
+}
+
+// Collection is a named set of documents stored by the server with their
+// embeddings, for similarity search.
+type Collection struct {
+	Name string `json:"name"`
+
+	// Model is the embedding model used for the collection's documents and
+	// queries.
+	Model string `json:"model"`
+
+	// Index is the index used to query the collection, "flat" for an exact
+	// search of all documents or "hnsw" for an approximate search.
+	Index string `json:"index"`
+
+	// Dimensions is the number of dimensions embeddings are truncated to, as
+	// in [EmbedRequest]. Zero means embeddings are not truncated.
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// EmbeddingLength is the length of the collection's embeddings. It's
+	// set when the first documents are added, and documents or queries
+	// whose embeddings have another length are rejected.
+	EmbeddingLength int `json:"embedding_length,omitempty"`
+
+	// Documents is the number of documents in the collection.
+	Documents int `json:"documents"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateCollectionRequest is the request passed to [Client.CreateCollection].
+type CreateCollectionRequest struct {
+	Name       string `json:"name"`
+	Model      string `json:"model"`
+	Index      string `json:"index,omitempty"`
+	Dimensions int    `json:"dimensions,omitempty"`
+}
+
+// ListCollectionsResponse is the response from [Client.ListCollections].
+type ListCollectionsResponse struct {
+	Collections []Collection `json:"collections"`
+}
+
+// Document is a document in a [Collection].
+type Document struct {
+	// ID identifies the document in its collection. Adding a document with
+	// the ID of an existing document replaces it.
+	ID string `json:"id"`
+
+	Content  string         `json:"content"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// AddDocumentsRequest is the request passed to [Client.AddDocuments].
+type AddDocumentsRequest struct {
+	// Documents are the documents to add. IDs are generated for documents
+	// without one.
+	Documents []Document `json:"documents"`
+
+	// KeepAlive controls how long the embedding model will stay loaded in
+	// memory following this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// AddDocumentsResponse is the response from [Client.AddDocuments].
+type AddDocumentsResponse struct {
+	// IDs are the IDs of the added documents, in the order of the request.
+	IDs []string `json:"ids"`
+}
+
+// QueryCollectionRequest is the request passed to [Client.QueryCollection].
+type QueryCollectionRequest struct {
+	// Query is the text to find similar documents for.
+	Query string `json:"query"`
+
+	// TopK is the maximum number of documents to return. It defaults to 10.
+	TopK int `json:"top_k,omitempty"`
+
+	// Filter limits results to documents whose metadata has all of the
+	// filter's keys with equal values.
+	Filter map[string]any `json:"filter,omitempty"`
+
+	// KeepAlive controls how long the embedding model will stay loaded in
+	// memory following this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// QueryResult is a document returned by [Client.QueryCollection].
+type QueryResult struct {
+	Document
+
+	// Score is the cosine similarity of the document to the query.
+	Score float32 `json:"score"`
+}
+
+// QueryCollectionResponse is the response from [Client.QueryCollection].
+type QueryCollectionResponse struct {
+	// Results are the documents most similar to the query, most similar
+	// first.
+	Results []QueryResult `json:"results"`
+}
+
+// LoadRequest is the request passed to [Client.Load].
+type LoadRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// KeepAlive controls how long the model will stay loaded in memory
+	// after it is loaded.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options, such as num_ctx.
+	Options map[string]interface{} `json:"options"`
+
+	// Pin keeps the model loaded until it is explicitly unloaded. A pinned
+	// model is never unloaded to make room for other models.
+	Pin bool `json:"pin,omitempty"`
+}
+
+// LoadResponse is the response from [Client.Load]. It describes the model
+// once it is loaded.
+type LoadResponse struct {
+	ProcessModelResponse
+
+	LoadDuration time.Duration `json:"load_duration,omitempty"`
+}
+
+// UnloadRequest is the request passed to [Client.Unload].
+type UnloadRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+}
+
+// UnloadResponse is the response from [Client.Unload]. It describes the
+// model that was unloaded.
+type UnloadResponse struct {
+	Name     string `json:"name"`
+	Model    string `json:"model"`
+	Size     int64  `json:"size"`
+	SizeVRAM int64  `json:"size_vram"`
 }
 
 type RetrieveModelResponse struct {
@@ -443,10 +870,22 @@ type GenerateResponse struct {
 	// DoneReason is the reason the model stopped generating text.
 	DoneReason string `json:"done_reason,omitempty"`
 
+	// StopSequence is the stop sequence generation stopped on, if it
+	// stopped on one of the stop options.
+	StopSequence string `json:"stop_sequence,omitempty"`
+
 	// Context is an encoding of the conversation used in this response; this
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// RequestID identifies the request so it can be canceled with
+	// [Client.CancelRequest]. It is only set on the first streamed response.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Logprobs are the log probabilities of the tokens in Response, if
+	// requested.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
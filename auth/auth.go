@@ -90,3 +90,33 @@ func Sign(ctx context.Context, bts []byte) (string, error) {
 	// signature is <pubkey>:<signature>
 	return fmt.Sprintf("%s:%s", bytes.TrimSpace(parts[1]), base64.StdEncoding.EncodeToString(signedData.Blob)), nil
 }
+
+// Verify verifies a signature of bts made by [Sign]. It returns the public key
+// of the signer, in the same format as [GetPublicKey].
+func Verify(bts []byte, signature string) (string, error) {
+	encodedKey, encodedSignature, ok := strings.Cut(signature, ":")
+	if !ok {
+		return "", errors.New("malformed signature")
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := ssh.ParsePublicKey(keyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", err
+	}
+
+	if err := publicKey.Verify(bts, &ssh.Signature{Format: publicKey.Type(), Blob: signatureBytes}); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))), nil
+}
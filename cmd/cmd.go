@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -128,7 +130,12 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 
 			bar, ok := bars[resp.Digest]
 			if !ok {
-				bar = progress.NewBar(fmt.Sprintf("pulling %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+				message := fmt.Sprintf("pulling %s...", resp.Digest[7:19])
+				if resp.Mirror != "" {
+					message = fmt.Sprintf("pulling %s from %s...", resp.Digest[7:19], resp.Mirror)
+				}
+
+				bar = progress.NewBar(message, resp.Total, resp.Completed)
 				bars[resp.Digest] = bar
 				p.Add(resp.Digest, bar)
 			}
@@ -437,6 +444,11 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	sign, err := cmd.Flags().GetBool("sign")
+	if err != nil {
+		return err
+	}
+
 	p := progress.NewProgress(os.Stderr)
 	defer p.Stop()
 
@@ -471,7 +483,7 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
+	request := api.PushRequest{Name: args[0], Insecure: insecure, Sign: sign}
 	if err := client.Push(cmd.Context(), &request, fn); err != nil {
 		if spinner != nil {
 			spinner.Stop()
@@ -555,7 +567,11 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 				cpuPercent := math.Round(float64(sizeCPU) / float64(m.Size) * 100)
 				procStr = fmt.Sprintf("%d%%/%d%% CPU/GPU", int(cpuPercent), int(100-cpuPercent))
 			}
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, format.HumanTime(m.ExpiresAt, "Never")})
+			until := format.HumanTime(m.ExpiresAt, "Never")
+			if m.Pinned {
+				until = "Pinned"
+			}
+			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, until})
 		}
 	}
 
@@ -573,6 +589,48 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func StopHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	if all == (len(args) > 0) {
+		return errors.New("specify a model to stop or --all")
+	}
+
+	if all {
+		models, err := client.ListRunning(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		for _, m := range models.Models {
+			args = append(args, m.Name)
+		}
+	}
+
+	for _, name := range args {
+		if _, err := client.Unload(cmd.Context(), &api.UnloadRequest{Model: name}); err != nil {
+			var statusErr api.StatusError
+			if all && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+				// unloaded since it was listed
+				continue
+			}
+
+			return err
+		}
+		fmt.Printf("stopped '%s'\n", name)
+	}
+
+	return nil
+}
+
 func DeleteHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -589,6 +647,68 @@ func DeleteHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func RerankHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	topN, err := cmd.Flags().GetInt("top")
+	if err != nil {
+		return err
+	}
+
+	documents := args[2:]
+	if len(documents) == 0 {
+		// read one document per line from stdin
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				documents = append(documents, line)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(documents) == 0 {
+		return errors.New("no documents to rerank")
+	}
+
+	resp, err := client.Rerank(cmd.Context(), &api.RerankRequest{
+		Model:           args[0],
+		Query:           args[1],
+		Documents:       documents,
+		TopN:            topN,
+		ReturnDocuments: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, r := range resp.Results {
+		data = append(data, []string{fmt.Sprintf("%.4f", r.RelevanceScore), fmt.Sprint(r.Index), r.Document})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"SCORE", "INDEX", "DOCUMENT"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("\t")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
 func ShowHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -821,7 +941,12 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 
 			bar, ok := bars[resp.Digest]
 			if !ok {
-				bar = progress.NewBar(fmt.Sprintf("pulling %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+				message := fmt.Sprintf("pulling %s...", resp.Digest[7:19])
+				if resp.Mirror != "" {
+					message = fmt.Sprintf("pulling %s from %s...", resp.Digest[7:19], resp.Mirror)
+				}
+
+				bar = progress.NewBar(message, resp.Total, resp.Completed)
 				bars[resp.Digest] = bar
 				p.Add(resp.Digest, bar)
 			}
@@ -848,6 +973,119 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func SaveHandler(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	if output == "" && term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("specify an output file with --output or redirect stdout")
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if output != "" {
+		f, err = os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+	fn := func(resp api.ProgressResponse) error {
+		bar, ok := bars[resp.Digest]
+		if !ok {
+			bar = progress.NewBar(fmt.Sprintf("saving %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+			bars[resp.Digest] = bar
+			p.Add(resp.Digest, bar)
+		}
+
+		bar.Set(resp.Completed)
+		return nil
+	}
+
+	if err := client.Export(cmd.Context(), &api.ExportRequest{Model: args[0]}, w, fn); err != nil {
+		if f != nil {
+			// don't leave an incomplete archive behind
+			f.Close()
+			os.Remove(output)
+		}
+
+		return err
+	}
+
+	if f != nil {
+		return f.Close()
+	}
+
+	return nil
+}
+
+func LoadHandler(cmd *cobra.Command, args []string) error {
+	var r io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	} else if term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("specify an archive to load or redirect stdin")
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+
+	var status string
+	var spinner *progress.Spinner
+
+	fn := func(resp api.ProgressResponse) error {
+		if resp.Digest != "" {
+			bar, ok := bars[resp.Digest]
+			if !ok {
+				bar = progress.NewBar(fmt.Sprintf("loading %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+				bars[resp.Digest] = bar
+				p.Add(resp.Digest, bar)
+			}
+
+			bar.Set(resp.Completed)
+		} else if status != resp.Status {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	return client.Import(cmd.Context(), r, fn)
+}
+
 type generateContextKey string
 
 type runOptions struct {
@@ -864,6 +1102,21 @@ type runOptions struct {
 	KeepAlive   *api.Duration
 }
 
+// formatJSON encodes a response format for a request. The format is either
+// a named format such as "json" or a JSON schema, which is sent as is.
+func formatJSON(format string) json.RawMessage {
+	if format == "" {
+		return nil
+	}
+
+	if json.Valid([]byte(format)) {
+		return json.RawMessage(format)
+	}
+
+	bts, _ := json.Marshal(format)
+	return bts
+}
+
 type displayResponseState struct {
 	lineLength int
 	wordBuffer string
@@ -962,7 +1215,7 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 	req := &api.ChatRequest{
 		Model:    opts.Model,
 		Messages: opts.Messages,
-		Format:   opts.Format,
+		Format:   formatJSON(opts.Format),
 		Options:  opts.Options,
 	}
 
@@ -1049,7 +1302,7 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 		Prompt:    opts.Prompt,
 		Context:   generateContext,
 		Images:    opts.Images,
-		Format:    opts.Format,
+		Format:    formatJSON(opts.Format),
 		System:    opts.System,
 		Options:   opts.Options,
 		KeepAlive: opts.KeepAlive,
@@ -1266,7 +1519,7 @@ func NewCLI() *cobra.Command {
 	runCmd.Flags().Bool("verbose", false, "Show timings for response")
 	runCmd.Flags().Bool("insecure", false, "Use an insecure registry")
 	runCmd.Flags().Bool("nowordwrap", false, "Don't wrap words to the next line automatically")
-	runCmd.Flags().String("format", "", "Response format (e.g. json)")
+	runCmd.Flags().String("format", "", "Response format (e.g. json or a JSON schema)")
 	serveCmd := &cobra.Command{
 		Use:     "serve",
 		Aliases: []string{"start"},
@@ -1294,6 +1547,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().Bool("sign", false, "Sign the model with your Ollama key")
 
 	listCmd := &cobra.Command{
 		Use:     "list",
@@ -1310,6 +1564,15 @@ func NewCLI() *cobra.Command {
 		RunE:    ListRunningHandler,
 	}
 
+	stopCmd := &cobra.Command{
+		Use:     "stop MODEL [MODEL...]",
+		Short:   "Stop a running model",
+		PreRunE: checkServerHeartbeat,
+		RunE:    StopHandler,
+	}
+
+	stopCmd.Flags().Bool("all", false, "Stop all running models")
+
 	copyCmd := &cobra.Command{
 		Use:     "cp SOURCE DESTINATION",
 		Short:   "Copy a model",
@@ -1326,6 +1589,37 @@ func NewCLI() *cobra.Command {
 		RunE:    DeleteHandler,
 	}
 
+	saveCmd := &cobra.Command{
+		Use:     "save MODEL",
+		Short:   "Save a model to an archive",
+		Long:    "Save a model, its manifest and blobs to a tar archive in the OCI image layout, which can be loaded with 'ollama load'.",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    SaveHandler,
+	}
+
+	saveCmd.Flags().StringP("output", "o", "", "Write to a file instead of stdout")
+
+	loadCmd := &cobra.Command{
+		Use:     "load [ARCHIVE]",
+		Short:   "Load models from an archive",
+		Long:    "Load the models in an archive created by 'ollama save'. The archive is read from stdin if none is given.",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    LoadHandler,
+	}
+
+	rerankCmd := &cobra.Command{
+		Use:     "rerank MODEL QUERY [DOCUMENT...]",
+		Short:   "Rank documents by relevance to a query",
+		Long:    "Rank documents by relevance to a query. Documents are read one per line from stdin if none are given.",
+		Args:    cobra.MinimumNArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    RerankHandler,
+	}
+
+	rerankCmd.Flags().Int("top", 0, "Only show the most relevant documents")
+
 	envVars := envconfig.AsMap()
 
 	envs := []envconfig.EnvVar{envVars["OLLAMA_HOST"]}
@@ -1338,8 +1632,12 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		stopCmd,
 		copyCmd,
 		deleteCmd,
+		saveCmd,
+		loadCmd,
+		rerankCmd,
 		serveCmd,
 	} {
 		switch cmd {
@@ -1347,17 +1645,31 @@ func NewCLI() *cobra.Command {
 			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_NOHISTORY"]})
 		case serveCmd:
 			appendEnvDocs(cmd, []envconfig.EnvVar{
+				envVars["OLLAMA_BATCHES"],
+				envVars["OLLAMA_COLLECTIONS"],
+				envVars["OLLAMA_CONVERSATIONS"],
 				envVars["OLLAMA_DEBUG"],
 				envVars["OLLAMA_HOST"],
 				envVars["OLLAMA_KEEP_ALIVE"],
 				envVars["OLLAMA_MAX_LOADED_MODELS"],
 				envVars["OLLAMA_MAX_QUEUE"],
+				envVars["OLLAMA_MAX_SESSIONS_SIZE"],
+				envVars["OLLAMA_MIRRORS"],
 				envVars["OLLAMA_MODELS"],
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_PRELOAD"],
+				envVars["OLLAMA_REGISTRY"],
+				envVars["OLLAMA_REGISTRY_PUSH"],
+				envVars["OLLAMA_REGISTRY_PUSH_KEYS"],
+				envVars["OLLAMA_REGISTRY_URL"],
+				envVars["OLLAMA_REGISTRY_OVERWRITE"],
 				envVars["OLLAMA_SCHED_SPREAD"],
+				envVars["OLLAMA_SESSIONS"],
 				envVars["OLLAMA_TMPDIR"],
+				envVars["OLLAMA_TRUSTED_KEYS"],
+				envVars["OLLAMA_VERIFY_SIGNATURES"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
 				envVars["OLLAMA_LLM_LIBRARY"],
 			})
@@ -1375,8 +1687,12 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		stopCmd,
 		copyCmd,
 		deleteCmd,
+		saveCmd,
+		loadCmd,
+		rerankCmd,
 	)
 
 	return rootCmd
@@ -96,6 +96,46 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// Batches returns the path to the directory of uploaded files and batches. Batches directory can be configured via the OLLAMA_BATCHES environment variable.
+// Default is the batches directory in the models directory
+func Batches() string {
+	if s := Var("OLLAMA_BATCHES"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "batches")
+}
+
+// Collections returns the path to the directory of vector collections. Collections directory can be configured via the OLLAMA_COLLECTIONS environment variable.
+// Default is the collections directory in the models directory
+func Collections() string {
+	if s := Var("OLLAMA_COLLECTIONS"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "collections")
+}
+
+// Conversations returns the path to the directory of stored conversations. Conversations directory can be configured via the OLLAMA_CONVERSATIONS environment variable.
+// Default is the conversations directory in the models directory
+func Conversations() string {
+	if s := Var("OLLAMA_CONVERSATIONS"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "conversations")
+}
+
+// Sessions returns the path to the directory of saved session caches. Sessions directory can be configured via the OLLAMA_SESSIONS environment variable.
+// Default is the sessions directory in the models directory
+func Sessions() string {
+	if s := Var("OLLAMA_SESSIONS"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "sessions")
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -144,6 +184,12 @@ var (
 	SchedSpread = Bool("OLLAMA_SCHED_SPREAD")
 	// IntelGPU enables experimental Intel GPU detection.
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
+	// Registry serves the local models as an OCI distribution registry at /v2/.
+	Registry = Bool("OLLAMA_REGISTRY")
+	// RegistryPush accepts models pushed to the registry served with Registry.
+	RegistryPush = Bool("OLLAMA_REGISTRY_PUSH")
+	// RegistryOverwrite allows models pushed to the registry to replace existing models.
+	RegistryOverwrite = Bool("OLLAMA_REGISTRY_OVERWRITE")
 )
 
 func String(s string) func() string {
@@ -155,6 +201,21 @@ func String(s string) func() string {
 var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
 	TmpDir     = String("OLLAMA_TMPDIR")
+	// Preload is the path to a JSON file listing models to load when the server starts.
+	Preload = String("OLLAMA_PRELOAD")
+	// Mirrors is the path to a JSON file of registry mirrors that pulls try before the registry itself.
+	Mirrors = String("OLLAMA_MIRRORS")
+	// RegistryPushKeys is the path to a JSON file of the public keys allowed to push to each namespace of the registry
+	// served with Registry.
+	RegistryPushKeys = String("OLLAMA_REGISTRY_PUSH_KEYS")
+	// RegistryURL is the URL clients reach the registry served with Registry at. Push tokens are only issued for
+	// requests to it. It defaults to the URL of Host.
+	RegistryURL = String("OLLAMA_REGISTRY_URL")
+	// TrustedKeys is the path to a JSON file of the public keys trusted to sign the models of each namespace.
+	TrustedKeys = String("OLLAMA_TRUSTED_KEYS")
+	// VerifySignatures is whether pulls verify manifest signatures: "enforce" rejects unsigned or untrusted
+	// manifests and "warn" only warns about them.
+	VerifySignatures = String("OLLAMA_VERIFY_SIGNATURES")
 
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
@@ -233,6 +294,10 @@ var (
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// MaxSessionsSize sets the maximum size in bytes of the saved session caches, the least recently used are removed
+	// beyond it. Zero disables saving sessions. MaxSessionsSize can be configured via the OLLAMA_MAX_SESSIONS_SIZE
+	// environment variable.
+	MaxSessionsSize = Uint("OLLAMA_MAX_SESSIONS_SIZE", 10<<30)
 )
 
 type EnvVar struct {
@@ -243,21 +308,35 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
-		"OLLAMA_RUNNERS_DIR":       {"OLLAMA_RUNNERS_DIR", RunnersDir(), "Location for runners"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_TMPDIR":            {"OLLAMA_TMPDIR", TmpDir(), "Location for temporary files"},
+		"OLLAMA_BATCHES":            {"OLLAMA_BATCHES", Batches(), "The path to the uploaded files and batches directory"},
+		"OLLAMA_COLLECTIONS":        {"OLLAMA_COLLECTIONS", Collections(), "The path to the vector collections directory"},
+		"OLLAMA_CONVERSATIONS":      {"OLLAMA_CONVERSATIONS", Conversations(), "The path to the stored conversations directory"},
+		"OLLAMA_DEBUG":              {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_FLASH_ATTENTION":    {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_HOST":               {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":         {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":        {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_MAX_LOADED_MODELS":  {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":          {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MAX_SESSIONS_SIZE":  {"OLLAMA_MAX_SESSIONS_SIZE", MaxSessionsSize(), "Maximum size in bytes of the saved session caches (default 10 GiB)"},
+		"OLLAMA_MIRRORS":            {"OLLAMA_MIRRORS", Mirrors(), "Path to a JSON file of registry mirrors to pull from"},
+		"OLLAMA_MODELS":             {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_NOHISTORY":          {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":            {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":       {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":            {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
+		"OLLAMA_PRELOAD":            {"OLLAMA_PRELOAD", Preload(), "Path to a JSON file listing models to load on startup"},
+		"OLLAMA_REGISTRY":           {"OLLAMA_REGISTRY", Registry(), "Serve local models as a registry other Ollama servers can pull from"},
+		"OLLAMA_REGISTRY_OVERWRITE": {"OLLAMA_REGISTRY_OVERWRITE", RegistryOverwrite(), "Allow models pushed to the registry to replace existing models"},
+		"OLLAMA_REGISTRY_PUSH":      {"OLLAMA_REGISTRY_PUSH", RegistryPush(), "Accept models pushed to the registry"},
+		"OLLAMA_REGISTRY_PUSH_KEYS": {"OLLAMA_REGISTRY_PUSH_KEYS", RegistryPushKeys(), "Path to a JSON file of public keys allowed to push to the registry"},
+		"OLLAMA_REGISTRY_URL":       {"OLLAMA_REGISTRY_URL", RegistryURL(), "URL clients reach the registry at (default OLLAMA_HOST)"},
+		"OLLAMA_RUNNERS_DIR":        {"OLLAMA_RUNNERS_DIR", RunnersDir(), "Location for runners"},
+		"OLLAMA_SCHED_SPREAD":       {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_SESSIONS":           {"OLLAMA_SESSIONS", Sessions(), "The path to the saved session caches directory"},
+		"OLLAMA_TMPDIR":             {"OLLAMA_TMPDIR", TmpDir(), "Location for temporary files"},
+		"OLLAMA_TRUSTED_KEYS":       {"OLLAMA_TRUSTED_KEYS", TrustedKeys(), "Path to a JSON file of public keys trusted to sign models"},
+		"OLLAMA_VERIFY_SIGNATURES":  {"OLLAMA_VERIFY_SIGNATURES", VerifySignatures(), "Verify model signatures on pull (enforce, warn)"},
 	}
 	if runtime.GOOS != "darwin" {
 		ret["CUDA_VISIBLE_DEVICES"] = EnvVar{"CUDA_VISIBLE_DEVICES", CudaVisibleDevices(), "Set which NVIDIA devices are visible"}
@@ -64,6 +64,7 @@ struct server_params {
     bool slots_endpoint = true;
     bool metrics_endpoint = false;
     int n_threads_http = -1;
+    std::string slot_save_path;
 };
 
 bool server_verbose = false;
@@ -176,6 +177,11 @@ struct server_slot {
     // multitasks
     int multitask_id = -1;
 
+    // session whose KV cache is held by this slot, and whether the cache
+    // changed since it was last saved
+    std::string session;
+    bool session_dirty = false;
+
     void reset() {
         n_prompt_tokens        = 0;
         generated_text         = "";
@@ -346,6 +352,7 @@ struct llama_server_context
     bool clean_kv_cache     = true;
     bool all_slots_are_idle = false;
     bool add_bos_token      = true;
+    bool rank_pooling       = false; // cross-encoder rerankers output one score per sequence
 
     int32_t n_ctx;  // total context for all clients / slots
 
@@ -355,6 +362,9 @@ struct llama_server_context
     std::string              system_prompt;
     std::vector<llama_token> system_tokens;
 
+    // directory where session KV caches are saved, sessions are disabled if empty
+    std::string slot_save_path;
+
     std::string name_user;      // this should be the antiprompt
     std::string name_assistant;
 
@@ -427,9 +437,38 @@ struct llama_server_context
 
         add_bos_token = llama_should_add_bos_token(model);
 
+        // LLAMA_POOLING_TYPE_RANK, compared by value as older llama.cpp
+        // versions don't define it
+        char arch[64] = {0};
+        char pooling[16] = {0};
+        if (llama_model_meta_val_str(model, "general.architecture", arch, sizeof(arch)) > 0 &&
+            llama_model_meta_val_str(model, (std::string(arch) + ".pooling_type").c_str(), pooling, sizeof(pooling)) > 0)
+        {
+            rank_pooling = std::string(pooling) == "4";
+        }
+
         return true;
     }
 
+    // format_rerank returns the tokens of a query and document pair for a
+    // cross-encoder: [BOS]query[EOS][SEP]document[EOS]
+    std::vector<llama_token> format_rerank(const std::string & query, const std::string & document) const
+    {
+        std::vector<llama_token> tokens;
+        tokens.push_back(llama_token_bos(model));
+
+        const std::vector<llama_token> q = ::llama_tokenize(ctx, query, false, true);
+        tokens.insert(tokens.end(), q.begin(), q.end());
+        tokens.push_back(llama_token_eos(model));
+        tokens.push_back(llama_token_sep(model));
+
+        const std::vector<llama_token> d = ::llama_tokenize(ctx, document, false, true);
+        tokens.insert(tokens.end(), d.begin(), d.end());
+        tokens.push_back(llama_token_eos(model));
+
+        return tokens;
+    }
+
     void initialize() {
         // create slots
         all_slots_are_idle = true;
@@ -1166,7 +1205,11 @@ struct llama_server_context
         if (slot.sparams.n_probs > 0)
         {
             std::vector<completion_token_output> probs = {};
-            if (!slot.params.stream && slot.stopped_word)
+            if (slot.params.stream)
+            {
+                // streamed tokens were sent with their probabilities already
+            }
+            else if (slot.stopped_word)
             {
                 const std::vector<llama_token> stop_word_toks = llama_tokenize(ctx, slot.stopping_word, false);
                 probs = std::vector<completion_token_output>(slot.generated_token_probs.begin(), slot.generated_token_probs.end() - stop_word_toks.size());
@@ -1191,7 +1234,7 @@ struct llama_server_context
         res.error = false;
         res.stop = true;
 
-        const int n_embd = llama_n_embd(model);
+        const int n_embd = rank_pooling ? 1 : llama_n_embd(model);
 
         if (!params.embedding)
         {
@@ -1424,12 +1467,123 @@ struct llama_server_context
         return slot;
     }
 
+    server_slot *session_slot(const std::string &session) {
+        for (server_slot &slot : slots) {
+            if (slot.available() && slot.session == session) {
+                return &slot;
+            }
+        }
+
+        return nullptr;
+    }
+
+    // session names are used as file names so only allow a safe subset of characters
+    static bool validate_session(const std::string &session) {
+        if (session.empty() || session.size() > 255 || session[0] == '.') {
+            return false;
+        }
+
+        for (char c : session) {
+            if (!isalnum((unsigned char) c) && c != '-' && c != '_' && c != '.') {
+                return false;
+            }
+        }
+
+        return true;
+    }
+
+    // session_save writes the KV cache of the slot's session to disk
+    bool session_save(server_slot &slot) {
+        if (slot_save_path.empty() || slot.session.empty() || slot.cache_tokens.empty()) {
+            return false;
+        }
+
+        const std::string filepath = slot_save_path + "/" + slot.session;
+        const int64_t t_start = ggml_time_us();
+        const size_t nwrite = llama_state_seq_save_file(ctx, filepath.c_str(), slot.id, slot.cache_tokens.data(), slot.cache_tokens.size());
+        if (nwrite == 0) {
+            LOG_ERROR("failed to save session", {
+                {"slot_id", slot.id},
+                {"session", slot.session},
+            });
+            return false;
+        }
+
+        slot.session_dirty = false;
+
+        LOG_INFO("session saved", {
+            {"slot_id",  slot.id},
+            {"session",  slot.session},
+            {"n_tokens", slot.cache_tokens.size()},
+            {"n_bytes",  nwrite},
+            {"t_ms",     (ggml_time_us() - t_start) / 1e3},
+        });
+        return true;
+    }
+
+    // session_restore assigns the slot to a session and loads the session's
+    // KV cache if it was saved before
+    void session_restore(server_slot &slot, const std::string &session) {
+        slot.session = session;
+        slot.session_dirty = false;
+
+        if (slot_save_path.empty() || session.empty()) {
+            return;
+        }
+
+        const std::string filepath = slot_save_path + "/" + session;
+        FILE *f = fopen(filepath.c_str(), "rb");
+        if (f == nullptr) {
+            LOG_DEBUG("no saved session", {{"slot_id", slot.id}, {"session", session}});
+            return;
+        }
+        fclose(f);
+
+        const int64_t t_start = ggml_time_us();
+        llama_kv_cache_seq_rm(ctx, slot.id, -1, -1);
+
+        size_t n_tokens = 0;
+        slot.cache_tokens.resize(slot.n_ctx);
+        const size_t nread = llama_state_seq_load_file(ctx, filepath.c_str(), slot.id, slot.cache_tokens.data(), slot.cache_tokens.size(), &n_tokens);
+        if (nread == 0) {
+            // the prompt will be evaluated from scratch
+            slot.cache_tokens.clear();
+            LOG_WARNING("failed to restore session", {
+                {"slot_id", slot.id},
+                {"session", session},
+            });
+            return;
+        }
+
+        slot.cache_tokens.resize(n_tokens);
+
+        LOG_INFO("session restored", {
+            {"slot_id",  slot.id},
+            {"session",  session},
+            {"n_tokens", n_tokens},
+            {"n_bytes",  nread},
+            {"t_ms",     (ggml_time_us() - t_start) / 1e3},
+        });
+    }
+
     void process_single_task(task_server& task)
     {
         switch (task.type)
         {
             case TASK_TYPE_COMPLETION: {
-                server_slot *slot = prefix_slot(task.data["prompt"]);
+                const std::string session = json_value(task.data, "session", std::string());
+                if (!session.empty() && !validate_session(session))
+                {
+                    send_error(task, "invalid session name");
+                    break;
+                }
+
+                server_slot *slot = session.empty() ? nullptr : session_slot(session);
+                if (slot == nullptr)
+                {
+                    slot = prefix_slot(task.data["prompt"]);
+                }
+
                 if (slot == nullptr)
                 {
                     // if no slot is available, we defer this task for processing later
@@ -1438,7 +1592,19 @@ struct llama_server_context
                     break;
                 }
 
+                if (slot->session != session)
+                {
+                    // the slot is taken over from another session, save that
+                    // session's cache first so it can be picked up again later
+                    if (slot->session_dirty)
+                    {
+                        session_save(*slot);
+                    }
+                    session_restore(*slot, session);
+                }
+
                 slot->reset();
+                slot->session_dirty = !session.empty();
 
                 slot->embedding    = task.embedding_mode;
                 slot->task_id      = task.id;
@@ -1528,6 +1694,26 @@ struct llama_server_context
                 metrics.reset_bucket();
                 queue_results.send(res);
             } break;
+            case TASK_TYPE_SESSION_SAVE: {
+                int n_saved = 0;
+                for (server_slot &slot : slots)
+                {
+                    // slots that are still generating are saved when they're taken over
+                    const bool idle = slot.available() || slot.command == RELEASE;
+                    if (idle && slot.session_dirty && session_save(slot))
+                    {
+                        n_saved++;
+                    }
+                }
+
+                task_result res;
+                res.id = task.id;
+                res.multitask_id = task.multitask_id;
+                res.stop = true;
+                res.error = false;
+                res.result_json = {{"n_saved", n_saved}};
+                queue_results.send(res);
+            } break;
         }
     }
 
@@ -1539,6 +1725,11 @@ struct llama_server_context
         result.stop = true;
         result.error = false;
 
+        // subtasks finish in any order, their ids are in the order of the prompts
+        std::sort(multitask.results.begin(), multitask.results.end(), [](const task_result &a, const task_result &b) {
+            return a.id < b.id;
+        });
+
         // collect json results into one json result
         std::vector<json> result_jsons;
         for (auto& subres : multitask.results)
@@ -1972,6 +2163,19 @@ struct llama_server_context
                     result.probs.push_back({cur_p.data[i].id, cur_p.data[i].p});
                 }
 
+                // the sampled token may not be among the most likely ones
+                if (n_probs > 0)
+                {
+                    for (size_t i = 0; i < cur_p.size; ++i)
+                    {
+                        if (cur_p.data[i].id == id)
+                        {
+                            result.prob = cur_p.data[i].p;
+                            break;
+                        }
+                    }
+                }
+
                 if (!process_token(result, slot))
                 {
                     slot.release();
@@ -2077,6 +2281,7 @@ static void server_print_usage(const char *argv0, const gpt_params &params,
     printf("  --log-disable             disables logging to a file.\n");
     printf("  --slots-endpoint-disable  disables slots monitoring endpoint.\n");
     printf("  --metrics                 enable prometheus compatible metrics endpoint (default: %s).\n", sparams.metrics_endpoint ? "enabled" : "disabled");
+    printf("  --slot-save-path PATH     directory to save and restore session KV caches (default: disabled)\n");
     printf("\n");
     printf("  -n, --n-predict           maximum tokens to predict (default: %d)\n", params.n_predict);
     printf("  --override-kv KEY=TYPE:VALUE\n");
@@ -2551,6 +2756,15 @@ static void server_params_parse(int argc, char **argv, server_params &sparams, g
         {
             sparams.metrics_endpoint = true;
         }
+        else if (arg == "--slot-save-path")
+        {
+            if (++i >= argc)
+            {
+                invalid_param = true;
+                break;
+            }
+            sparams.slot_save_path = argv[i];
+        }
         else if (arg == "--chat-template")
         {
             if (++i >= argc)
@@ -2756,6 +2970,7 @@ int main(int argc, char **argv) {
     llama_server_context llama;
 
     server_params_parse(argc, argv, sparams, params);
+    llama.slot_save_path = sparams.slot_save_path;
 
     if (params.model_alias == "unknown")
     {
@@ -3178,6 +3393,23 @@ int main(int argc, char **argv) {
                 return res.set_content(data.dump(), "application/json; charset=utf-8");
             });
 
+    svr.Post("/sessions/save", [&llama](const httplib::Request &req, httplib::Response &res)
+            {
+                res.set_header("Access-Control-Allow-Origin", req.get_header_value("Origin"));
+                task_server task;
+                task.id = llama.queue_tasks.get_new_id();
+                task.type = TASK_TYPE_SESSION_SAVE;
+                task.target_id = -1;
+
+                llama.queue_results.add_waiting_task_id(task.id);
+                llama.queue_tasks.post(task);
+
+                task_result result = llama.queue_results.recv(task.id);
+                llama.queue_results.remove_waiting_task_id(task.id);
+
+                return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
+            });
+
     svr.Post("/embedding", [&llama](const httplib::Request &req, httplib::Response &res)
             {
                 res.set_header("Access-Control-Allow-Origin", req.get_header_value("Origin"));
@@ -3205,6 +3437,53 @@ int main(int argc, char **argv) {
                 return res.set_content(result.result_json.dump(), "application/json; charset=utf-8");
             });
 
+    svr.Post("/rerank", [&llama](const httplib::Request &req, httplib::Response &res)
+            {
+                res.set_header("Access-Control-Allow-Origin", req.get_header_value("Origin"));
+                if (!llama.rank_pooling)
+                {
+                    res.status = 400;
+                    return res.set_content(json{{"error", "model does not support reranking"}}.dump(), "application/json; charset=utf-8");
+                }
+
+                const json body = json::parse(req.body);
+                const std::string query = body.value("query", "");
+                const std::vector<std::string> documents = body.value("documents", std::vector<std::string>());
+                if (documents.empty())
+                {
+                    return res.set_content(json{{"scores", json::array()}}.dump(), "application/json; charset=utf-8");
+                }
+
+                // each query and document pair is scored in its own slot
+                json prompt = json::array();
+                for (const auto & document : documents)
+                {
+                    prompt.push_back(llama.format_rerank(query, document));
+                }
+
+                if (prompt.size() == 1)
+                {
+                    prompt = prompt[0];
+                }
+
+                const int task_id = llama.queue_tasks.get_new_id();
+                llama.queue_results.add_waiting_task_id(task_id);
+                llama.request_completion(task_id, {{"prompt", prompt}}, true, -1);
+
+                task_result result = llama.queue_results.recv(task_id);
+                llama.queue_results.remove_waiting_task_id(task_id);
+
+                json results = result.result_json.contains("results") ? result.result_json["results"] : json::array({result.result_json});
+                std::vector<float> scores;
+                for (const auto & r : results)
+                {
+                    const std::vector<float> embedding = r.value("embedding", std::vector<float>());
+                    scores.push_back(embedding.empty() ? 0.0f : embedding[0]);
+                }
+
+                return res.set_content(json{{"scores", scores}}.dump(), "application/json; charset=utf-8");
+            });
+
     // GG: if I put the main loop inside a thread, it crashes on the first request when build in Debug!?
     //     "Bus error: 10" - this is on macOS, it does not crash on Linux
     //std::thread t2([&]()
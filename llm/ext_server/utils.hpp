@@ -70,7 +70,8 @@ enum task_type {
     TASK_TYPE_COMPLETION,
     TASK_TYPE_CANCEL,
     TASK_TYPE_NEXT_RESPONSE,
-    TASK_TYPE_METRICS
+    TASK_TYPE_METRICS,
+    TASK_TYPE_SESSION_SAVE
 };
 
 struct task_server {
@@ -107,6 +108,7 @@ struct completion_token_output {
 
     std::vector<token_prob> probs;
     llama_token tok;
+    float prob = 0.0f;
     std::string text_to_send;
 };
 
@@ -651,6 +653,7 @@ static json probs_vector_to_json(const llama_context *ctx, const std::vector<com
         std::string tok_str = tokens_to_output_formatted_string(ctx, prob.tok);
         out.push_back(json{
             {"content", tok_str},
+            {"prob",    prob.prob},
             {"probs",   probs_for_token},
         });
     }
@@ -0,0 +1,774 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// primitiveRules are the GBNF rules shared by all schemas. Each rule lists the
+// other primitive rules it references.
+var primitiveRules = map[string]struct {
+	body string
+	deps []string
+}{
+	"value":   {`object | array | string | number | ("true" | "false" | "null") ws`, []string{"object", "array", "string", "number", "ws"}},
+	"object":  {`"{" ws ( string ":" ws value ("," ws string ":" ws value)* )? "}" ws`, []string{"string", "value", "ws"}},
+	"array":   {`"[" ws ( value ("," ws value)* )? "]" ws`, []string{"value", "ws"}},
+	"string":  {`"\"" char* "\"" ws`, []string{"char", "ws"}},
+	"char":    {`[^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])`, nil},
+	"number":  {`("-"? ([0-9] | [1-9] [0-9]*)) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws`, []string{"ws"}},
+	"integer": {`("-"? ([0-9] | [1-9] [0-9]*)) ws`, []string{"ws"}},
+	"boolean": {`("true" | "false") ws`, []string{"ws"}},
+	"null":    {`"null" ws`, []string{"ws"}},
+	"ws":      {`([ \t\n] ws)?`, nil},
+}
+
+// FormatGrammar returns the grammar used to constrain output to format. The
+// format is either the JSON string "json" or a JSON schema object. The grammar
+// is empty if format is empty.
+func FormatGrammar(format json.RawMessage) (string, error) {
+	format = bytes.TrimSpace(format)
+	if len(format) == 0 || bytes.Equal(format, []byte("null")) {
+		return "", nil
+	}
+
+	var s string
+	if err := json.Unmarshal(format, &s); err == nil {
+		switch s {
+		case "":
+			return "", nil
+		case "json":
+			return jsonGrammar, nil
+		default:
+			return "", fmt.Errorf("format must be empty, \"json\", or a JSON schema object")
+		}
+	}
+
+	if format[0] != '{' {
+		return "", fmt.Errorf("format must be empty, \"json\", or a JSON schema object")
+	}
+
+	grammar, err := SchemaGrammar(format)
+	if err != nil {
+		return "", fmt.Errorf("invalid JSON schema: %w", err)
+	}
+
+	return grammar, nil
+}
+
+// SchemaGrammar compiles a JSON schema into a GBNF grammar. Objects, required
+// properties, enums, consts, arrays with minItems and maxItems, anyOf and oneOf,
+// local $refs, and string lengths and patterns are supported. Objects only allow
+// the properties listed in the schema and patterns must match the whole string.
+func SchemaGrammar(schema json.RawMessage) (string, error) {
+	var root jsonSchema
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return "", err
+	}
+
+	b := grammarBuilder{
+		rules: make(map[string]string),
+		refs:  make(map[string]string),
+		defs:  make(map[string]json.RawMessage),
+	}
+
+	for name, def := range root.Defs {
+		b.defs["#/$defs/"+name] = def
+	}
+
+	for name, def := range root.Definitions {
+		b.defs["#/definitions/"+name] = def
+	}
+
+	b.reserve("root")
+	expr, err := b.visit(schema, "root")
+	if err != nil {
+		return "", err
+	}
+
+	b.rules["root"] = expr
+
+	var sb strings.Builder
+	for _, name := range b.names {
+		fmt.Fprintf(&sb, "%s ::= %s\n", name, b.rules[name])
+	}
+
+	return sb.String(), nil
+}
+
+type jsonSchema struct {
+	Ref         string                     `json:"$ref"`
+	Defs        map[string]json.RawMessage `json:"$defs"`
+	Definitions map[string]json.RawMessage `json:"definitions"`
+
+	Type  json.RawMessage   `json:"type"`
+	Enum  []json.RawMessage `json:"enum"`
+	Const json.RawMessage   `json:"const"`
+	AnyOf []json.RawMessage `json:"anyOf"`
+	OneOf []json.RawMessage `json:"oneOf"`
+
+	Properties json.RawMessage `json:"properties"`
+	Required   []string        `json:"required"`
+
+	Items    json.RawMessage `json:"items"`
+	MinItems *int            `json:"minItems"`
+	MaxItems *int            `json:"maxItems"`
+
+	Pattern   string `json:"pattern"`
+	MinLength *int   `json:"minLength"`
+	MaxLength *int   `json:"maxLength"`
+}
+
+// types returns the schema's types, inferring one from the other keywords
+// if the type is not set
+func (s *jsonSchema) types() ([]string, error) {
+	if len(s.Type) > 0 {
+		var t string
+		if err := json.Unmarshal(s.Type, &t); err == nil {
+			return []string{t}, nil
+		}
+
+		var ts []string
+		if err := json.Unmarshal(s.Type, &ts); err != nil {
+			return nil, fmt.Errorf("type must be a string or an array of strings")
+		}
+
+		return ts, nil
+	}
+
+	switch {
+	case len(s.Properties) > 0:
+		return []string{"object"}, nil
+	case len(s.Items) > 0 || s.MinItems != nil || s.MaxItems != nil:
+		return []string{"array"}, nil
+	case s.Pattern != "" || s.MinLength != nil || s.MaxLength != nil:
+		return []string{"string"}, nil
+	}
+
+	return nil, nil
+}
+
+type grammarBuilder struct {
+	// names holds rule names in the order they are written
+	names []string
+	rules map[string]string
+
+	// refs maps each visited $ref to its rule name
+	refs map[string]string
+	defs map[string]json.RawMessage
+}
+
+// reserve returns a unique rule name derived from hint and reserves it so
+// the rule can be referenced before its body is known
+func (b *grammarBuilder) reserve(hint string) string {
+	hint = strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '-'
+		}
+		return r
+	}, hint)
+
+	name := hint
+	for i := 1; ; i++ {
+		if _, ok := b.rules[name]; !ok {
+			if _, ok := primitiveRules[name]; !ok {
+				break
+			}
+		}
+
+		name = fmt.Sprintf("%s-%d", hint, i)
+	}
+
+	b.names = append(b.names, name)
+	b.rules[name] = ""
+	return name
+}
+
+// define adds a rule named after hint and returns its name
+func (b *grammarBuilder) define(hint, body string) string {
+	name := b.reserve(hint)
+	b.rules[name] = body
+	return name
+}
+
+// primitive adds the named primitive rule and its dependencies
+func (b *grammarBuilder) primitive(name string) string {
+	if _, ok := b.rules[name]; !ok {
+		b.names = append(b.names, name)
+		b.rules[name] = primitiveRules[name].body
+		for _, dep := range primitiveRules[name].deps {
+			b.primitive(dep)
+		}
+	}
+
+	return name
+}
+
+// visit returns an expression matching the JSON value described by raw.
+// Rules it defines are named after hint.
+func (b *grammarBuilder) visit(raw json.RawMessage, hint string) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch string(raw) {
+	case "", "true", "{}":
+		return b.primitive("value"), nil
+	case "false":
+		return "", errors.New("schema false matches nothing")
+	}
+
+	var s jsonSchema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", err
+	}
+
+	switch {
+	case s.Ref != "":
+		return b.ref(s.Ref)
+	case len(s.AnyOf) > 0 || len(s.OneOf) > 0:
+		var alts []string
+		for i, sub := range append(s.AnyOf, s.OneOf...) {
+			expr, err := b.visit(sub, fmt.Sprintf("%s-%d", hint, i))
+			if err != nil {
+				return "", err
+			}
+
+			alts = append(alts, expr)
+		}
+
+		return b.define(hint, strings.Join(alts, " | ")), nil
+	case len(s.Const) > 0:
+		lit, err := valueLiteral(s.Const)
+		if err != nil {
+			return "", err
+		}
+
+		return b.define(hint, lit+" "+b.primitive("ws")), nil
+	case len(s.Enum) > 0:
+		var alts []string
+		for _, v := range s.Enum {
+			lit, err := valueLiteral(v)
+			if err != nil {
+				return "", err
+			}
+
+			alts = append(alts, lit)
+		}
+
+		return b.define(hint, "("+strings.Join(alts, " | ")+") "+b.primitive("ws")), nil
+	}
+
+	types, err := s.types()
+	if err != nil {
+		return "", err
+	}
+
+	switch len(types) {
+	case 0:
+		return b.primitive("value"), nil
+	case 1:
+		return b.visitType(&s, types[0], hint)
+	}
+
+	var alts []string
+	for _, t := range types {
+		expr, err := b.visitType(&s, t, hint+"-"+t)
+		if err != nil {
+			return "", err
+		}
+
+		alts = append(alts, expr)
+	}
+
+	return b.define(hint, strings.Join(alts, " | ")), nil
+}
+
+func (b *grammarBuilder) visitType(s *jsonSchema, t, hint string) (string, error) {
+	switch t {
+	case "object":
+		return b.visitObject(s, hint)
+	case "array":
+		return b.visitArray(s, hint)
+	case "string":
+		if s.Pattern != "" {
+			expr, err := b.pattern(s.Pattern)
+			if err != nil {
+				return "", err
+			}
+
+			return b.define(hint, `"\"" `+expr+` "\"" `+b.primitive("ws")), nil
+		}
+
+		if s.MinLength != nil || s.MaxLength != nil {
+			minLength, maxLength := valueOr(s.MinLength, 0), valueOr(s.MaxLength, -1)
+			if err := checkRepeat("minLength", minLength); err != nil {
+				return "", err
+			} else if err := checkRepeat("maxLength", maxLength); err != nil {
+				return "", err
+			}
+
+			chars := repeat(b.primitive("char"), minLength, maxLength)
+			return b.define(hint, strings.Join(slices.DeleteFunc([]string{`"\""`, chars, `"\""`, b.primitive("ws")}, isEmpty), " ")), nil
+		}
+
+		return b.primitive("string"), nil
+	case "number", "integer", "boolean", "null":
+		return b.primitive(t), nil
+	default:
+		return "", fmt.Errorf("unsupported type %q", t)
+	}
+}
+
+func (b *grammarBuilder) visitObject(s *jsonSchema, hint string) (string, error) {
+	keys, props, err := orderedProperties(s.Properties)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range s.Required {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+			props[key] = json.RawMessage("true")
+		}
+	}
+
+	if len(keys) == 0 {
+		return b.primitive("object"), nil
+	}
+
+	name := b.reserve(hint)
+
+	var required, optional []string
+	for _, key := range keys {
+		expr, err := b.visit(props[key], hint+"-"+key)
+		if err != nil {
+			return "", err
+		}
+
+		k, err := valueLiteral(mustMarshal(key))
+		if err != nil {
+			return "", err
+		}
+
+		kv := k + ` ws ":" ws ` + expr
+		if slices.Contains(s.Required, key) {
+			required = append(required, kv)
+		} else {
+			optional = append(optional, kv)
+		}
+	}
+
+	// each optional property can be omitted so every rest rule lists all the
+	// properties that may follow it
+	rest := make([]string, len(optional))
+	for i := len(optional) - 1; i >= 0; i-- {
+		body := optional[i]
+		if i < len(optional)-1 {
+			body += ` ( "," ws ( ` + strings.Join(rest[i+1:], " | ") + ` ) )?`
+		}
+
+		rest[i] = b.define(fmt.Sprintf("%s-rest-%d", hint, i), body)
+	}
+
+	body := `"{" ws `
+	if len(required) > 0 {
+		body += strings.Join(required, ` "," ws `) + " "
+		if len(rest) > 0 {
+			body += `( "," ws ( ` + strings.Join(rest, " | ") + ` ) )? `
+		}
+	} else {
+		body += `( ` + strings.Join(rest, " | ") + ` )? `
+	}
+
+	b.rules[name] = body + `"}" ` + b.primitive("ws")
+	return name, nil
+}
+
+func (b *grammarBuilder) visitArray(s *jsonSchema, hint string) (string, error) {
+	minItems, maxItems := valueOr(s.MinItems, 0), valueOr(s.MaxItems, -1)
+	if len(s.Items) == 0 && minItems == 0 && maxItems < 0 {
+		return b.primitive("array"), nil
+	}
+
+	if err := checkRepeat("minItems", minItems); err != nil {
+		return "", err
+	} else if err := checkRepeat("maxItems", maxItems); err != nil {
+		return "", err
+	}
+
+	ws := b.primitive("ws")
+	if maxItems == 0 {
+		return b.define(hint, `"[" ws "]" `+ws), nil
+	} else if maxItems > 0 && maxItems < minItems {
+		return "", fmt.Errorf("maxItems %d is less than minItems %d", maxItems, minItems)
+	}
+
+	name := b.reserve(hint)
+	item, err := b.visit(s.Items, hint+"-item")
+	if err != nil {
+		return "", err
+	}
+
+	more := maxItems - 1
+	if maxItems < 0 {
+		more = -1
+	}
+
+	items := strings.Join(slices.DeleteFunc([]string{item, repeat(`"," ws `+item, max(minItems-1, 0), more)}, isEmpty), " ")
+	if minItems == 0 {
+		items = "( " + items + " )?"
+	}
+
+	b.rules[name] = `"[" ws ` + items + ` "]" ` + ws
+	return name, nil
+}
+
+func (b *grammarBuilder) ref(ref string) (string, error) {
+	if name, ok := b.refs[ref]; ok {
+		return name, nil
+	}
+
+	def, ok := b.defs[ref]
+	if !ok {
+		return "", fmt.Errorf("unresolved $ref %q", ref)
+	}
+
+	hint := ref[strings.LastIndex(ref, "/")+1:]
+
+	// the name is reserved before visiting the definition so recursive
+	// references resolve to it
+	name := b.reserve(hint)
+	b.refs[ref] = name
+
+	expr, err := b.visit(def, hint+"-def")
+	if err != nil {
+		return "", err
+	}
+
+	b.rules[name] = expr
+	return name, nil
+}
+
+// pattern translates a regular expression into an expression matching the
+// JSON-encoded contents of a string. Anchors are ignored.
+func (b *grammarBuilder) pattern(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkRepeats(re); err != nil {
+		return "", fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+
+	expr, err := b.regexp(re.Simplify())
+	if err != nil {
+		return "", fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+
+	if expr == "" {
+		return `""`, nil
+	}
+
+	return expr, nil
+}
+
+func (b *grammarBuilder) regexp(re *syntax.Regexp) (string, error) {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return "", nil
+	case syntax.OpLiteral:
+		var parts []string
+		var sb strings.Builder
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && unicode.SimpleFold(r) != r {
+				if sb.Len() > 0 {
+					parts = append(parts, literal(jsonEscape(sb.String())))
+					sb.Reset()
+				}
+
+				folds := []rune{r}
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					folds = append(folds, f)
+				}
+
+				var alts []string
+				for _, f := range folds {
+					alts = append(alts, literal(jsonEscape(string(f))))
+				}
+
+				parts = append(parts, "("+strings.Join(alts, " | ")+")")
+				continue
+			}
+
+			sb.WriteRune(r)
+		}
+
+		if sb.Len() > 0 {
+			parts = append(parts, literal(jsonEscape(sb.String())))
+		}
+
+		return strings.Join(parts, " "), nil
+	case syntax.OpCharClass:
+		return charClass(re.Rune)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return b.primitive("char"), nil
+	case syntax.OpCapture:
+		return b.regexp(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		expr, err := b.regexp(re.Sub[0])
+		if err != nil || expr == "" {
+			return expr, err
+		}
+
+		return "(" + expr + ")" + map[syntax.Op]string{syntax.OpStar: "*", syntax.OpPlus: "+", syntax.OpQuest: "?"}[re.Op], nil
+	case syntax.OpConcat, syntax.OpAlternate:
+		var exprs []string
+		for _, sub := range re.Sub {
+			expr, err := b.regexp(sub)
+			if err != nil {
+				return "", err
+			}
+
+			if expr == "" {
+				if re.Op == syntax.OpConcat {
+					continue
+				}
+
+				expr = `""`
+			}
+
+			exprs = append(exprs, expr)
+		}
+
+		if re.Op == syntax.OpConcat {
+			return strings.Join(exprs, " "), nil
+		}
+
+		return "(" + strings.Join(exprs, " | ") + ")", nil
+	default:
+		return "", fmt.Errorf("unsupported operator %s", re.Op)
+	}
+}
+
+// charClass translates the rune ranges of a character class. Characters
+// JSON requires to be escaped are matched in their escaped form.
+func charClass(ranges []rune) (string, error) {
+	var alts []string
+	var sb strings.Builder
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		for _, r := range []rune{'\t', '\n', '\r', '"', '\\'} {
+			if lo <= r && r <= hi {
+				alts = append(alts, literal(jsonEscape(string(r))))
+			}
+		}
+
+		// split the range around the characters that can't appear unescaped
+		for _, x := range [][2]rune{{0, 0x1f}, {'"', '"'}, {'\\', '\\'}, {0x7f, 0x7f}} {
+			if lo > hi || hi < x[0] {
+				break
+			}
+
+			if lo < x[0] {
+				writeRange(&sb, lo, x[0]-1)
+			}
+
+			lo = max(lo, x[1]+1)
+		}
+
+		if lo <= hi {
+			writeRange(&sb, lo, hi)
+		}
+	}
+
+	if sb.Len() > 0 {
+		alts = append([]string{"[" + sb.String() + "]"}, alts...)
+	}
+
+	switch len(alts) {
+	case 0:
+		return "", errors.New("character class matches no characters")
+	case 1:
+		return alts[0], nil
+	default:
+		return "(" + strings.Join(alts, " | ") + ")", nil
+	}
+}
+
+func writeRange(sb *strings.Builder, lo, hi rune) {
+	sb.WriteString(classRune(lo))
+	if hi > lo {
+		sb.WriteString("-")
+		sb.WriteString(classRune(hi))
+	}
+}
+
+func classRune(r rune) string {
+	switch {
+	case r == '\\' || r == ']' || r == '[':
+		return `\` + string(r)
+	case r == '-' || r == '^':
+		return fmt.Sprintf(`\x%02X`, r)
+	case r >= 0x20 && r < 0x7f:
+		return string(r)
+	case r <= 0xffff:
+		return fmt.Sprintf(`\u%04X`, r)
+	default:
+		return fmt.Sprintf(`\U%08X`, r)
+	}
+}
+
+// literal quotes s as a GBNF string literal
+func literal(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\x%02X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// jsonEscape returns s as it appears inside a JSON string
+func jsonEscape(s string) string {
+	bts := mustMarshal(s)
+	return string(bts[1 : len(bts)-1])
+}
+
+// valueLiteral returns a literal matching the compact encoding of the JSON value v
+func valueLiteral(v json.RawMessage) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v); err != nil {
+		return "", err
+	}
+
+	return literal(buf.String()), nil
+}
+
+func mustMarshal(s string) json.RawMessage {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		panic(err)
+	}
+
+	return bytes.TrimSpace(buf.Bytes())
+}
+
+// orderedProperties decodes a properties object, keeping its keys in the
+// order they are written
+func orderedProperties(raw json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	props := make(map[string]json.RawMessage)
+	if len(raw) == 0 {
+		return nil, props, nil
+	}
+
+	if err := json.Unmarshal(raw, &props); err != nil {
+		return nil, nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := d.Token(); err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, t.(string))
+
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return keys, props, nil
+}
+
+// maxRepeat limits the bounds of repeated strings, arrays and patterns,
+// which are expanded in the grammar
+const maxRepeat = 256
+
+func checkRepeat(keyword string, n int) error {
+	if n > maxRepeat {
+		return fmt.Errorf("%s %d is more than the maximum of %d", keyword, n, maxRepeat)
+	}
+
+	return nil
+}
+
+// checkRepeats checks the bounds of every repetition in re
+func checkRepeats(re *syntax.Regexp) error {
+	if re.Op == syntax.OpRepeat {
+		if err := checkRepeat("repetition", max(re.Min, re.Max)); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if err := checkRepeats(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repeat returns an expression matching between minN and maxN occurrences of
+// expr. maxN is unbounded if negative.
+func repeat(expr string, minN, maxN int) string {
+	parts := make([]string, 0, minN+1)
+	for range minN {
+		parts = append(parts, expr)
+	}
+
+	if maxN < 0 {
+		parts = append(parts, "("+expr+")*")
+	} else if n := maxN - minN; n > 0 {
+		// nested optionals, ( expr ( expr )? )?, so later occurrences only
+		// follow earlier ones
+		parts = append(parts, strings.Repeat("("+expr+" ", n-1)+"("+expr+")?"+strings.Repeat(")?", n-1))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func valueOr(p *int, v int) int {
+	if p != nil {
+		return *p
+	}
+
+	return v
+}
+
+func isEmpty(s string) bool {
+	return s == ""
+}
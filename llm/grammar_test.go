@@ -0,0 +1,231 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatGrammar(t *testing.T) {
+	cases := []struct {
+		format  string
+		grammar string
+		err     bool
+	}{
+		{"", "", false},
+		{`""`, "", false},
+		{"null", "", false},
+		{`"json"`, jsonGrammar, false},
+		{`"xml"`, "", true},
+		{`["json"]`, "", true},
+		{`{"type": "boolean"}`, "root ::= boolean\nboolean ::= (\"true\" | \"false\") ws\nws ::= ([ \\t\\n] ws)?\n", false},
+		{`{"type": "date"}`, "", true},
+		{`{"$ref": "#/$defs/missing"}`, "", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.format, func(t *testing.T) {
+			grammar, err := FormatGrammar(json.RawMessage(tt.format))
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tt.err, err)
+			}
+
+			if diff := cmp.Diff(grammar, tt.grammar); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSchemaGrammar(t *testing.T) {
+	cases := []struct {
+		name    string
+		schema  string
+		grammar string
+	}{
+		{
+			name: "object",
+			schema: `{
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"age": {"type": "integer"},
+					"color": {"enum": ["red", "green"]}
+				},
+				"required": ["age", "name"]
+			}`,
+			grammar: `root ::= root-1
+root-1 ::= "{" ws "\"name\"" ws ":" ws string "," ws "\"age\"" ws ":" ws integer ( "," ws ( root-rest-0 ) )? "}" ws
+string ::= "\"" char* "\"" ws
+char ::= [^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])
+ws ::= ([ \t\n] ws)?
+integer ::= ("-"? ([0-9] | [1-9] [0-9]*)) ws
+root-color ::= ("\"red\"" | "\"green\"") ws
+root-rest-0 ::= "\"color\"" ws ":" ws root-color
+`,
+		},
+		{
+			name: "optional properties",
+			schema: `{
+				"properties": {
+					"a": {"type": "number"},
+					"b": {"type": "boolean"},
+					"c": {"type": "null"}
+				}
+			}`,
+			grammar: `root ::= root-1
+root-1 ::= "{" ws ( root-rest-0 | root-rest-1 | root-rest-2 )? "}" ws
+number ::= ("-"? ([0-9] | [1-9] [0-9]*)) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws
+ws ::= ([ \t\n] ws)?
+boolean ::= ("true" | "false") ws
+null ::= "null" ws
+root-rest-2 ::= "\"c\"" ws ":" ws null
+root-rest-1 ::= "\"b\"" ws ":" ws boolean ( "," ws ( root-rest-2 ) )?
+root-rest-0 ::= "\"a\"" ws ":" ws number ( "," ws ( root-rest-1 | root-rest-2 ) )?
+`,
+		},
+		{
+			name:   "array",
+			schema: `{"type": "array", "items": {"type": "integer"}, "minItems": 1, "maxItems": 3}`,
+			grammar: `root ::= root-1
+ws ::= ([ \t\n] ws)?
+root-1 ::= "[" ws integer ("," ws integer ("," ws integer)?)? "]" ws
+integer ::= ("-"? ([0-9] | [1-9] [0-9]*)) ws
+`,
+		},
+		{
+			name:   "unbounded array",
+			schema: `{"type": "array", "items": {"type": ["string", "null"]}, "minItems": 2}`,
+			grammar: `root ::= root-1
+ws ::= ([ \t\n] ws)?
+root-1 ::= "[" ws root-item "," ws root-item ("," ws root-item)* "]" ws
+string ::= "\"" char* "\"" ws
+char ::= [^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])
+null ::= "null" ws
+root-item ::= string | null
+`,
+		},
+		{
+			name: "refs",
+			schema: `{
+				"$defs": {
+					"node": {
+						"type": "object",
+						"properties": {
+							"value": {"type": "integer"},
+							"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}
+						},
+						"required": ["value", "children"]
+					}
+				},
+				"$ref": "#/$defs/node"
+			}`,
+			grammar: `root ::= node
+node ::= node-def
+node-def ::= "{" ws "\"value\"" ws ":" ws integer "," ws "\"children\"" ws ":" ws node-def-children "}" ws
+integer ::= ("-"? ([0-9] | [1-9] [0-9]*)) ws
+ws ::= ([ \t\n] ws)?
+node-def-children ::= "[" ws ( node ("," ws node)* )? "]" ws
+`,
+		},
+		{
+			name:   "pattern",
+			schema: `{"type": "string", "pattern": "^[A-Z]{2}-\\d+(\\.\\d)?\"$"}`,
+			grammar: `root ::= root-1
+ws ::= ([ \t\n] ws)?
+root-1 ::= "\"" [A-Z] [A-Z] "-" ([0-9])+ ("." [0-9])? "\\\"" "\"" ws
+`,
+		},
+		{
+			name:   "string length",
+			schema: `{"type": "string", "minLength": 1, "maxLength": 2}`,
+			grammar: `root ::= root-1
+char ::= [^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])
+ws ::= ([ \t\n] ws)?
+root-1 ::= "\"" char (char)? "\"" ws
+`,
+		},
+		{
+			name:   "any of",
+			schema: `{"anyOf": [{"const": 1}, {"type": "string"}]}`,
+			grammar: `root ::= root-1
+ws ::= ([ \t\n] ws)?
+root-0 ::= "1" ws
+string ::= "\"" char* "\"" ws
+char ::= [^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])
+root-1 ::= root-0 | string
+`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			grammar, err := SchemaGrammar(json.RawMessage(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(grammar, tt.grammar); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSchemaGrammarLimits(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"max length", `{"type": "string", "maxLength": 1000000}`, "maxLength 1000000 is more than the maximum of 256"},
+		{"min length", `{"type": "string", "minLength": 257}`, "minLength 257 is more than the maximum of 256"},
+		{"max items", `{"type": "array", "items": {"type": "integer"}, "maxItems": 1000000}`, "maxItems 1000000 is more than the maximum of 256"},
+		{"min items", `{"type": "array", "minItems": 1000}`, "minItems 1000 is more than the maximum of 256"},
+		{"pattern", `{"type": "string", "pattern": "a{2,1000}"}`, `pattern "a{2,1000}": repetition 1000 is more than the maximum of 256`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SchemaGrammar(json.RawMessage(tt.schema)); err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// bounds up to the maximum are allowed
+	grammar, err := SchemaGrammar(json.RawMessage(`{"type": "string", "maxLength": 256}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(grammar, "(char"); n != 256 {
+		t.Errorf("expected 256 optional chars, got %d", n)
+	}
+}
+
+func TestCharClass(t *testing.T) {
+	cases := []struct {
+		ranges []rune
+		expr   string
+	}{
+		{[]rune{'a', 'z'}, `[a-z]`},
+		{[]rune{'-', '-', '^', '^'}, `[\x2D\x5E]`},
+		{[]rune{' ', '~'}, `([ -!#-\[\]-~] | "\\\"" | "\\\\")`},
+		{[]rune{'\n', '\n'}, `"\\n"`},
+		{[]rune{0x3b1, 0x3c9, 0x1f600, 0x1f64f}, `[\u03B1-\u03C9\U0001F600-\U0001F64F]`},
+	}
+
+	for _, tt := range cases {
+		expr, err := charClass(tt.ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(expr, tt.expr); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	}
+}
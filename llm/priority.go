@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority orders requests waiting for a runner. Requests with a higher
+// priority are admitted first.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// priorityAging is how long a request waits before it is treated as the
+// next higher priority, so lower priority requests are never starved
+var priorityAging = 30 * time.Second
+
+// ParsePriority parses a priority class name. An empty name is
+// PriorityNormal.
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "high":
+		return PriorityHigh, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	default:
+		return PriorityNormal, fmt.Errorf("invalid priority %q: must be one of \"high\", \"normal\" or \"low\"", s)
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// Aged returns the effective priority of a request that has been waiting
+// for d
+func (p Priority) Aged(d time.Duration) Priority {
+	return p + Priority(d/priorityAging)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying the request priority
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the request priority carried by ctx, or
+// PriorityNormal if there is none
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+
+	return PriorityNormal
+}
+
+// prioritySemaphore limits the number of concurrent requests to a runner.
+// Waiting requests are admitted by their aged priority, then in the order
+// they arrived.
+type prioritySemaphore struct {
+	mu      sync.Mutex
+	size    int
+	cur     int
+	waiters []*semaphoreWaiter
+}
+
+type semaphoreWaiter struct {
+	priority Priority
+	queued   time.Time
+	ready    chan struct{}
+}
+
+func newPrioritySemaphore(n int) *prioritySemaphore {
+	return &prioritySemaphore{size: n}
+}
+
+// Acquire blocks until the semaphore is available or ctx is done. The
+// priority of the request is taken from ctx.
+func (s *prioritySemaphore) Acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cur < s.size && len(s.waiters) == 0 {
+		s.cur++
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &semaphoreWaiter{
+		priority: PriorityFromContext(ctx),
+		queued:   time.Now(),
+		ready:    make(chan struct{}),
+	}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// acquired while being canceled, give it back
+			s.mu.Unlock()
+			s.Release()
+		default:
+			for i := range s.waiters {
+				if s.waiters[i] == w {
+					s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+		}
+
+		return ctx.Err()
+	}
+}
+
+// Release releases the semaphore and admits the next waiting request
+func (s *prioritySemaphore) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cur--
+	if s.cur < 0 {
+		panic("llm: prioritySemaphore released more than acquired")
+	}
+
+	now := time.Now()
+	for s.cur < s.size && len(s.waiters) > 0 {
+		next := 0
+		for i, w := range s.waiters[1:] {
+			if w.priority.Aged(now.Sub(w.queued)) > s.waiters[next].priority.Aged(now.Sub(s.waiters[next].queued)) {
+				next = i + 1
+			}
+		}
+
+		w := s.waiters[next]
+		s.waiters = append(s.waiters[:next], s.waiters[next+1:]...)
+		s.cur++
+		close(w.ready)
+	}
+}
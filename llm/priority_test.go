@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePriority(t *testing.T) {
+	cases := map[string]Priority{
+		"":       PriorityNormal,
+		"normal": PriorityNormal,
+		"high":   PriorityHigh,
+		"low":    PriorityLow,
+	}
+
+	for s, want := range cases {
+		got, err := ParsePriority(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParsePriority("urgent")
+	require.ErrorContains(t, err, `invalid priority "urgent"`)
+}
+
+func TestPriorityAged(t *testing.T) {
+	assert.Equal(t, PriorityLow, PriorityLow.Aged(priorityAging-time.Second))
+	assert.Equal(t, PriorityNormal, PriorityLow.Aged(priorityAging))
+	assert.Equal(t, PriorityHigh, PriorityLow.Aged(2*priorityAging))
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	assert.Equal(t, PriorityNormal, PriorityFromContext(context.Background()))
+	assert.Equal(t, PriorityHigh, PriorityFromContext(WithPriority(context.Background(), PriorityHigh)))
+}
+
+// waitFor enqueues an Acquire with priority p and waits until it is
+// blocked in the semaphore. The name is sent on order once acquired.
+func waitFor(t *testing.T, ctx context.Context, s *prioritySemaphore, p Priority, name string, order chan<- string) {
+	t.Helper()
+
+	s.mu.Lock()
+	n := len(s.waiters)
+	s.mu.Unlock()
+
+	go func() {
+		if err := s.Acquire(WithPriority(ctx, p)); err == nil {
+			order <- name
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.waiters) > n
+	}, time.Second, time.Millisecond)
+}
+
+func TestPrioritySemaphore(t *testing.T) {
+	s := newPrioritySemaphore(1)
+	require.NoError(t, s.Acquire(context.Background()))
+
+	order := make(chan string, 4)
+	canceled, cancel := context.WithCancel(context.Background())
+	waitFor(t, context.Background(), s, PriorityLow, "low", order)
+	waitFor(t, context.Background(), s, PriorityNormal, "normal", order)
+	waitFor(t, canceled, s, PriorityHigh, "canceled", order)
+	waitFor(t, context.Background(), s, PriorityHigh, "high", order)
+	waitFor(t, context.Background(), s, PriorityNormal, "normal 2", order)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.waiters) == 4
+	}, time.Second, time.Millisecond)
+
+	for _, want := range []string{"high", "normal", "normal 2", "low"} {
+		s.Release()
+		select {
+		case got := <-order:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+
+	s.Release()
+	assert.Equal(t, 0, s.cur)
+	assert.Empty(t, s.waiters)
+}
+
+func TestPrioritySemaphoreAging(t *testing.T) {
+	s := newPrioritySemaphore(1)
+	require.NoError(t, s.Acquire(context.Background()))
+
+	order := make(chan string, 2)
+	waitFor(t, context.Background(), s, PriorityLow, "low", order)
+	waitFor(t, context.Background(), s, PriorityNormal, "normal", order)
+
+	// the low priority request has waited long enough to be treated as
+	// normal and arrived first
+	s.mu.Lock()
+	s.waiters[0].queued = s.waiters[0].queued.Add(-priorityAging)
+	s.mu.Unlock()
+
+	for _, want := range []string{"low", "normal"} {
+		s.Release()
+		assert.Equal(t, want, <-order)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -19,10 +20,9 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/sync/semaphore"
-
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
@@ -34,8 +34,12 @@ type LlamaServer interface {
 	WaitUntilRunning(ctx context.Context) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
 	Embedding(ctx context.Context, input string) ([]float32, error)
+	EmbeddingBatch(ctx context.Context, inputs []string) ([][]float32, error)
+	Rerank(ctx context.Context, query string, documents []string) ([]float32, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
+	SaveSessions(ctx context.Context) error
+	NumParallel() int
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
@@ -58,7 +62,13 @@ type llmServer struct {
 	loadDuration time.Duration   // Record how long it took the model to load
 	loadProgress float32
 
-	sem *semaphore.Weighted
+	// sessionSalt identifies the model in session names so a session's
+	// cache is never restored into another model
+	sessionSalt  string
+	sessionsDir  string // empty if sessions aren't saved
+	sessionsUsed atomic.Bool
+
+	sem *prioritySemaphore
 }
 
 // LoadModel will load a model from disk. The model must be in the GGML format.
@@ -270,6 +280,18 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 
 	params = append(params, "--parallel", strconv.Itoa(numParallel))
 
+	// sessions are saved only if they may take up some space
+	sessionsDir := envconfig.Sessions()
+	if envconfig.MaxSessionsSize() == 0 {
+		sessionsDir = ""
+	} else if err := os.MkdirAll(sessionsDir, 0o755); err != nil {
+		slog.Warn("unable to create sessions directory, sessions will not be saved", "error", err)
+		sessionsDir = ""
+	} else {
+		pruneSessions(sessionsDir, envconfig.MaxSessionsSize())
+		params = append(params, "--slot-save-path", sessionsDir)
+	}
+
 	if estimate.TensorSplit != "" {
 		params = append(params, "--tensor-split", estimate.TensorSplit)
 	}
@@ -344,7 +366,9 @@ func NewLlamaServer(gpus gpu.GpuInfoList, model string, ggml *GGML, adapters, pr
 			options:     opts,
 			estimate:    estimate,
 			numParallel: numParallel,
-			sem:         semaphore.NewWeighted(int64(numParallel)),
+			sessionSalt: sessionSalt(model, adapters, projectors),
+			sessionsDir: sessionsDir,
+			sem:         newPrioritySemaphore(numParallel),
 			totalLayers: ggml.KV().BlockCount() + 1,
 			gpus:        gpus,
 			done:        make(chan error, 1),
@@ -687,6 +711,17 @@ type completion struct {
 	Prompt       string `json:"prompt"`
 	Stop         bool   `json:"stop"`
 	StoppedLimit bool   `json:"stopped_limit"`
+	StoppedWord  bool   `json:"stopped_word"`
+	StoppingWord string `json:"stopping_word"`
+
+	CompletionProbabilities []struct {
+		Content string  `json:"content"`
+		Prob    float64 `json:"prob"`
+		Probs   []struct {
+			TokStr string  `json:"tok_str"`
+			Prob   float64 `json:"prob"`
+		} `json:"probs"`
+	} `json:"completion_probabilities"`
 
 	Timings struct {
 		PredictedN  int     `json:"predicted_n"`
@@ -697,28 +732,71 @@ type completion struct {
 }
 
 type CompletionRequest struct {
-	Prompt  string
-	Format  string
+	Prompt string
+
+	// Tokens is the prompt as token IDs. If set, it's used instead of Prompt.
+	Tokens []int
+
+	Format  json.RawMessage
 	Images  []ImageData
 	Options *api.Options
+
+	// Session is an optional key the prompt cache is saved and restored
+	// under, so it outlives the slot and the runner
+	Session string
+
+	// Logprobs requests the log probability of each generated token, along
+	// with TopLogprobs of the most likely alternatives
+	Logprobs    bool
+	TopLogprobs int
 }
 
 type CompletionResponse struct {
 	Content            string
 	DoneReason         string
 	Done               bool
+	StopSequence       string // the stop sequence generation stopped on, if any
 	PromptEvalCount    int
 	PromptEvalDuration time.Duration
 	EvalCount          int
 	EvalDuration       time.Duration
+	Logprobs           []api.Logprob
+}
+
+// minLogprob stands in for the log probability of tokens the sampler ruled
+// out, which is -Inf and can't be encoded as JSON
+const minLogprob = -9999.0
+
+func logprob(p float64) float64 {
+	if p <= 0 {
+		return minLogprob
+	}
+
+	return max(math.Log(p), minLogprob)
+}
+
+func (c *completion) logprobs(top int) []api.Logprob {
+	if len(c.CompletionProbabilities) == 0 {
+		return nil
+	}
+
+	logprobs := make([]api.Logprob, len(c.CompletionProbabilities))
+	for i, p := range c.CompletionProbabilities {
+		logprobs[i] = api.Logprob{Token: p.Content, Logprob: logprob(p.Prob)}
+		for _, alt := range p.Probs[:min(top, len(p.Probs))] {
+			logprobs[i].TopLogprobs = append(logprobs[i].TopLogprobs, api.TokenLogprob{Token: alt.TokStr, Logprob: logprob(alt.Prob)})
+		}
+	}
+
+	return logprobs
 }
 
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
-	if err := s.sem.Acquire(ctx, 1); err != nil {
+	if err := s.sem.Acquire(ctx); err != nil {
 		slog.Error("Failed to acquire semaphore", "error", err)
 		return err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release()
 
 	// put an upper limit on num_predict to avoid the model running on forever
 	if req.Options.NumPredict < 0 || req.Options.NumPredict > 10*s.options.NumCtx {
@@ -751,6 +829,30 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		"cache_prompt":      true,
 	}
 
+	if len(req.Tokens) > 0 {
+		request["prompt"] = req.Tokens
+	}
+
+	if req.Logprobs || req.TopLogprobs > 0 {
+		// the runner only returns the probability of the sampled token if
+		// it's asked for at least one alternative
+		request["n_probs"] = max(req.TopLogprobs, 1)
+	}
+
+	if req.Session != "" {
+		name := sessionName(s.sessionSalt, req.Session)
+		request["session"] = name
+		s.sessionsUsed.Store(true)
+
+		if s.sessionsDir != "" {
+			// mark the session as used so it's pruned last, the runner may also
+			// save another session's cache to free a slot for it
+			now := time.Now()
+			_ = os.Chtimes(filepath.Join(s.sessionsDir, name), now, now)
+			defer pruneSessions(s.sessionsDir, envconfig.MaxSessionsSize())
+		}
+	}
+
 	// Make sure the server is ready
 	status, err := s.getServerStatusRetry(ctx)
 	if err != nil {
@@ -759,8 +861,13 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	if req.Format == "json" {
-		request["grammar"] = jsonGrammar
+	grammar, err := FormatGrammar(req.Format)
+	if err != nil {
+		return err
+	}
+
+	if grammar != "" {
+		request["grammar"] = grammar
 		if !strings.Contains(strings.ToLower(req.Prompt), "json") {
 			slog.Warn("Prompt does not specify that the LLM should response in JSON, but JSON format is expected. For best results specify that JSON is expected in the system prompt.")
 		}
@@ -842,7 +949,8 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 			if c.Content != "" {
 				fn(CompletionResponse{
-					Content: c.Content,
+					Content:  c.Content,
+					Logprobs: c.logprobs(req.TopLogprobs),
 				})
 			}
 
@@ -852,9 +960,15 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 					doneReason = "length"
 				}
 
+				var stopSequence string
+				if c.StoppedWord {
+					stopSequence = c.StoppingWord
+				}
+
 				fn(CompletionResponse{
 					Done:               true,
 					DoneReason:         doneReason,
+					StopSequence:       stopSequence,
 					PromptEvalCount:    c.Timings.PromptN,
 					PromptEvalDuration: parseDurationMs(c.Timings.PromptMS),
 					EvalCount:          c.Timings.PredictedN,
@@ -882,19 +996,108 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 }
 
 type EmbeddingRequest struct {
-	Content string `json:"content"`
+	// Content is a string, or an array of strings to embed as a batch
+	Content any `json:"content"`
 }
 
 type EmbeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
+// EmbeddingBatchResponse is the runner's response to a batch of inputs
+type EmbeddingBatchResponse struct {
+	Results []EmbeddingResponse `json:"results"`
+}
+
 func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, error) {
-	if err := s.sem.Acquire(ctx, 1); err != nil {
+	body, err := s.embedding(ctx, "embedding", EmbeddingRequest{Content: input})
+	if err != nil {
+		return nil, err
+	}
+
+	var e EmbeddingResponse
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("unmarshal tokenize response: %w", err)
+	}
+
+	return e.Embedding, nil
+}
+
+// EmbeddingBatch embeds inputs in a single request to the runner, which
+// evaluates them in parallel slots and batches their tokens together
+func (s *llmServer) EmbeddingBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	if len(inputs) == 1 {
+		e, err := s.Embedding(ctx, inputs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return [][]float32{e}, nil
+	}
+
+	body, err := s.embedding(ctx, "embedding", EmbeddingRequest{Content: inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	var e EmbeddingBatchResponse
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("unmarshal embedding response: %w", err)
+	}
+
+	if len(e.Results) != len(inputs) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(inputs), len(e.Results))
+	}
+
+	embeddings := make([][]float32, len(e.Results))
+	for i, r := range e.Results {
+		if len(r.Embedding) == 0 {
+			return nil, fmt.Errorf("no embedding for input %d", i)
+		}
+
+		embeddings[i] = r.Embedding
+	}
+
+	return embeddings, nil
+}
+
+type RerankRequest struct {
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+}
+
+type RerankResponse struct {
+	Scores []float32 `json:"scores"`
+}
+
+// Rerank scores each document's relevance to query with a cross-encoder
+// model, which evaluates the query and document together
+func (s *llmServer) Rerank(ctx context.Context, query string, documents []string) ([]float32, error) {
+	body, err := s.embedding(ctx, "rerank", RerankRequest{Query: query, Documents: documents})
+	if err != nil {
+		return nil, err
+	}
+
+	var r RerankResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal rerank response: %w", err)
+	}
+
+	if len(r.Scores) != len(documents) {
+		return nil, fmt.Errorf("expected %d scores, got %d", len(documents), len(r.Scores))
+	}
+
+	return r.Scores, nil
+}
+
+// embedding posts req to an endpoint of the runner that evaluates its
+// inputs without sampling, such as /embedding or /rerank
+func (s *llmServer) embedding(ctx context.Context, endpoint string, req any) ([]byte, error) {
+	if err := s.sem.Acquire(ctx); err != nil {
 		slog.Error("Failed to acquire semaphore", "error", err)
 		return nil, err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release()
 
 	// Make sure the server is ready
 	status, err := s.getServerStatusRetry(ctx)
@@ -904,12 +1107,12 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	data, err := json.Marshal(EmbeddingRequest{Content: input})
+	data, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling embed data: %w", err)
 	}
 
-	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/embedding", s.port), bytes.NewBuffer(data))
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/%s", s.port, endpoint), bytes.NewBuffer(data))
 	if err != nil {
 		return nil, fmt.Errorf("error creating embed request: %w", err)
 	}
@@ -931,12 +1134,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("%s", body)
 	}
 
-	var e EmbeddingResponse
-	if err := json.Unmarshal(body, &e); err != nil {
-		return nil, fmt.Errorf("unmarshal tokenize response: %w", err)
-	}
-
-	return e.Embedding, nil
+	return body, nil
 }
 
 type TokenizeRequest struct {
@@ -1043,6 +1241,48 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	return decoded.Content, nil
 }
 
+type SaveSessionsResponse struct {
+	Saved int `json:"n_saved"`
+}
+
+// SaveSessions writes the caches of sessions held by idle slots to disk so
+// they can be restored after the runner is unloaded. Sessions in slots that
+// are taken over by another request are saved by the runner itself.
+func (s *llmServer) SaveSessions(ctx context.Context) error {
+	if s.sessionsDir == "" || !s.sessionsUsed.Load() {
+		return nil
+	}
+	defer pruneSessions(s.sessionsDir, envconfig.MaxSessionsSize())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/sessions/save", s.port), nil)
+	if err != nil {
+		return fmt.Errorf("save sessions request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do save sessions request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read save sessions request: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s", body)
+	}
+
+	var saved SaveSessionsResponse
+	if err := json.Unmarshal(body, &saved); err != nil {
+		return fmt.Errorf("unmarshal save sessions response: %w", err)
+	}
+
+	slog.Debug("saved sessions", "count", saved.Saved)
+	return nil
+}
+
 func (s *llmServer) Close() error {
 	if s.cmd != nil {
 		slog.Debug("stopping llama server")
@@ -1061,6 +1301,11 @@ func (s *llmServer) Close() error {
 	return nil
 }
 
+// NumParallel returns the number of requests the runner can process at once
+func (s *llmServer) NumParallel() int {
+	return s.numParallel
+}
+
 func (s *llmServer) EstimatedVRAM() uint64 {
 	return s.estimate.VRAMSize
 }
@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCompletionLogprobs(t *testing.T) {
+	var c completion
+	if err := json.Unmarshal([]byte(`{
+		"content": "Hi",
+		"completion_probabilities": [
+			{"content": "Hi", "prob": 0.5, "probs": [{"tok_str": "Hi", "prob": 0.5}, {"tok_str": "Hello", "prob": 0.25}, {"tok_str": "Hey", "prob": 0}]}
+		]
+	}`), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		top  int
+		want []api.Logprob
+	}{
+		{0, []api.Logprob{{Token: "Hi", Logprob: -0.6931471805599453}}},
+		{2, []api.Logprob{{Token: "Hi", Logprob: -0.6931471805599453, TopLogprobs: []api.TokenLogprob{{Token: "Hi", Logprob: -0.6931471805599453}, {Token: "Hello", Logprob: -1.3862943611198906}}}}},
+		{5, []api.Logprob{{Token: "Hi", Logprob: -0.6931471805599453, TopLogprobs: []api.TokenLogprob{{Token: "Hi", Logprob: -0.6931471805599453}, {Token: "Hello", Logprob: -1.3862943611198906}, {Token: "Hey", Logprob: minLogprob}}}}},
+	}
+
+	for _, tt := range cases {
+		if diff := cmp.Diff(c.logprobs(tt.top), tt.want); diff != "" {
+			t.Errorf("top %d mismatch (-got +want):\n%s", tt.top, diff)
+		}
+	}
+}
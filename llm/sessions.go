@@ -0,0 +1,94 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionSalt identifies a model in session names so a session's cache is
+// never restored into another model
+func sessionSalt(model string, adapters, projectors []string) string {
+	return strings.Join(append(append([]string{model}, adapters...), projectors...), "\x00")
+}
+
+func sessionName(salt, key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(salt+"\x00"+key)))
+}
+
+// SessionName returns the file name the runner of a model saves the cache of
+// a session under
+func SessionName(model string, adapters, projectors []string, key string) string {
+	return sessionName(sessionSalt(model, adapters, projectors), key)
+}
+
+func isSessionName(name string) bool {
+	b, err := hex.DecodeString(name)
+	return err == nil && len(b) == sha256.Size
+}
+
+// pruneMu keeps runners of different models from pruning at the same time
+var pruneMu sync.Mutex
+
+// pruneSessions removes the least recently used session caches in dir until
+// the rest take up no more than limit bytes
+func pruneSessions(dir string, limit uint) {
+	pruneMu.Lock()
+	defer pruneMu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Warn("unable to read sessions directory", "error", err)
+		return
+	}
+
+	type session struct {
+		path    string
+		size    uint64
+		modTime time.Time
+	}
+
+	var sessions []session
+	var total uint64
+	for _, e := range entries {
+		// leave anything but session caches alone
+		if !e.Type().IsRegular() || !isSessionName(e.Name()) {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		sessions = append(sessions, session{filepath.Join(dir, e.Name()), uint64(info.Size()), info.ModTime()})
+		total += uint64(info.Size())
+	}
+
+	slices.SortFunc(sessions, func(a, b session) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	for _, s := range sessions {
+		if total <= uint64(limit) {
+			break
+		}
+
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("unable to remove session", "path", s.path, "error", err)
+			continue
+		}
+
+		slog.Debug("pruned session", "path", s.path, "size", s.size)
+		total -= s.size
+	}
+}
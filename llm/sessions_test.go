@@ -0,0 +1,62 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionName(t *testing.T) {
+	name := SessionName("model", []string{"adapter"}, nil, "document-1")
+	require.True(t, isSessionName(name))
+	require.Equal(t, name, SessionName("model", []string{"adapter"}, nil, "document-1"))
+
+	// a session's cache is never shared with another model or session
+	require.NotEqual(t, name, SessionName("model", nil, nil, "document-1"))
+	require.NotEqual(t, name, SessionName("model", []string{"adapter"}, nil, "document-2"))
+}
+
+func TestPruneSessions(t *testing.T) {
+	dir := t.TempDir()
+
+	now := time.Now()
+	names := make([]string, 3)
+	for i := range names {
+		names[i] = SessionName("model", nil, nil, string(rune('a'+i)))
+		p := filepath.Join(dir, names[i])
+		require.NoError(t, os.WriteFile(p, make([]byte, 10), 0o644))
+
+		// the first session is the least recently used
+		at := now.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(p, at, at))
+	}
+
+	// other files are left alone
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), make([]byte, 100), 0o644))
+
+	ls := func() []string {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	pruneSessions(dir, 30)
+	require.Len(t, ls(), 4)
+
+	pruneSessions(dir, 25)
+	got := ls()
+	require.Len(t, got, 3)
+	require.False(t, slices.Contains(got, names[0]))
+
+	pruneSessions(dir, 0)
+	require.Equal(t, []string{"notes.txt"}, ls())
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -38,21 +39,48 @@ type Message struct {
 }
 
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Message      Message         `json:"message"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type ChunkChoice struct {
-	Index        int     `json:"index"`
-	Delta        Message `json:"delta"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Delta        Message         `json:"delta"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs"`
+	FinishReason *string         `json:"finish_reason"`
+}
+
+type ChoiceLogprobs struct {
+	Content []Logprob `json:"content"`
+}
+
+type Logprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
 }
 
 type CompleteChunkChoice struct {
-	Text         string  `json:"text"`
-	Index        int     `json:"index"`
-	FinishReason *string `json:"finish_reason"`
+	Text         string              `json:"text"`
+	Index        int                 `json:"index"`
+	Logprobs     *CompletionLogprobs `json:"logprobs"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+type CompletionLogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
 }
 
 type Usage struct {
@@ -62,27 +90,48 @@ type Usage struct {
 }
 
 type ResponseFormat struct {
-	Type string `json:"type"`
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      *bool           `json:"strict,omitempty"`
 }
 
 type EmbedRequest struct {
-	Input any    `json:"input"`
-	Model string `json:"model"`
+	Input          any    `json:"input"`
+	Model          string `json:"model"`
+	Dimensions     int    `json:"dimensions,omitempty"`
+	EncodingFormat string `json:"encoding_format,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type ChatCompletionRequest struct {
-	Model            string          `json:"model"`
-	Messages         []Message       `json:"messages"`
-	Stream           bool            `json:"stream"`
-	MaxTokens        *int            `json:"max_tokens"`
-	Seed             *int            `json:"seed"`
-	Stop             any             `json:"stop"`
-	Temperature      *float64        `json:"temperature"`
-	FrequencyPenalty *float64        `json:"frequency_penalty"`
-	PresencePenalty  *float64        `json:"presence_penalty_penalty"`
-	TopP             *float64        `json:"top_p"`
-	ResponseFormat   *ResponseFormat `json:"response_format"`
-	Tools            []api.Tool      `json:"tools"`
+	Model             string          `json:"model"`
+	Messages          []Message       `json:"messages"`
+	Stream            bool            `json:"stream"`
+	StreamOptions     *StreamOptions  `json:"stream_options"`
+	N                 *int            `json:"n"`
+	MaxTokens         *int            `json:"max_tokens"`
+	Seed              *int            `json:"seed"`
+	Stop              any             `json:"stop"`
+	Temperature       *float64        `json:"temperature"`
+	FrequencyPenalty  *float64        `json:"frequency_penalty"`
+	PresencePenalty   *float64        `json:"presence_penalty"`
+	TopP              *float64        `json:"top_p"`
+	Logprobs          bool            `json:"logprobs"`
+	TopLogprobs       *int            `json:"top_logprobs"`
+	ResponseFormat    *ResponseFormat `json:"response_format"`
+	Tools             []api.Tool      `json:"tools"`
+	ToolChoice        any             `json:"tool_choice"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls"`
+	User              string          `json:"user"`
 }
 
 type ChatCompletion struct {
@@ -102,12 +151,17 @@ type ChatCompletionChunk struct {
 	Model             string        `json:"model"`
 	SystemFingerprint string        `json:"system_fingerprint"`
 	Choices           []ChunkChoice `json:"choices"`
+
+	// Usage is only set on the last chunk, if requested with stream_options
+	Usage *Usage `json:"usage,omitempty"`
 }
 
-// TODO (https://github.com/ollama/ollama/issues/5259): support []string, []int and [][]int
 type CompletionRequest struct {
-	Model            string   `json:"model"`
-	Prompt           string   `json:"prompt"`
+	Model string `json:"model"`
+
+	// Prompt is a string, an array of strings, an array of tokens or an
+	// array of token arrays. Each prompt is a separate choice.
+	Prompt           any      `json:"prompt"`
 	FrequencyPenalty float32  `json:"frequency_penalty"`
 	MaxTokens        *int     `json:"max_tokens"`
 	PresencePenalty  float32  `json:"presence_penalty"`
@@ -117,6 +171,7 @@ type CompletionRequest struct {
 	Temperature      *float32 `json:"temperature"`
 	TopP             float32  `json:"top_p"`
 	Suffix           string   `json:"suffix"`
+	Logprobs         *int     `json:"logprobs"`
 }
 
 type Completion struct {
@@ -139,6 +194,8 @@ type CompletionChunk struct {
 }
 
 type ToolCall struct {
+	// Index is only set on tool calls in streamed chunks
+	Index    *int   `json:"index,omitempty"`
 	ID       string `json:"id"`
 	Type     string `json:"type"`
 	Function struct {
@@ -155,9 +212,12 @@ type Model struct {
 }
 
 type Embedding struct {
-	Object    string    `json:"object"`
-	Embedding []float32 `json:"embedding"`
-	Index     int       `json:"index"`
+	Object string `json:"object"`
+
+	// Embedding is a []float32, or a string of base64 encoded float32 values
+	// when the request's encoding_format is base64
+	Embedding any `json:"embedding"`
+	Index     int `json:"index"`
 }
 
 type ListCompletion struct {
@@ -191,6 +251,18 @@ func NewError(code int, message string) ErrorResponse {
 	return ErrorResponse{Error{Type: etype, Message: message}}
 }
 
+const (
+	// maxChoices is the largest n accepted by the OpenAI API
+	maxChoices = 128
+
+	// maxTopLogprobs is the largest top_logprobs accepted by the OpenAI API
+	maxTopLogprobs = 20
+
+	// maxCompletionLogprobs is the largest logprobs accepted by the OpenAI
+	// completions API
+	maxCompletionLogprobs = 5
+)
+
 func toolCallId() string {
 	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
 	b := make([]byte, 8)
@@ -200,9 +272,9 @@ func toolCallId() string {
 	return "call_" + strings.ToLower(string(b))
 }
 
-func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
-	toolCalls := make([]ToolCall, len(r.Message.ToolCalls))
-	for i, tc := range r.Message.ToolCalls {
+func toToolCalls(tcs []api.ToolCall) []ToolCall {
+	toolCalls := make([]ToolCall, len(tcs))
+	for i, tc := range tcs {
 		toolCalls[i].ID = toolCallId()
 		toolCalls[i].Type = "function"
 		toolCalls[i].Function.Name = tc.Function.Name
@@ -216,6 +288,42 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		toolCalls[i].Function.Arguments = string(args)
 	}
 
+	return toolCalls
+}
+
+func tokenBytes(token string) []int {
+	bts := make([]int, len(token))
+	for i := range len(token) {
+		bts[i] = int(token[i])
+	}
+
+	return bts
+}
+
+func toLogprobs(lps []api.Logprob) *ChoiceLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	logprobs := ChoiceLogprobs{Content: make([]Logprob, len(lps))}
+	for i, lp := range lps {
+		logprobs.Content[i] = Logprob{
+			Token:       lp.Token,
+			Logprob:     lp.Logprob,
+			Bytes:       tokenBytes(lp.Token),
+			TopLogprobs: make([]TopLogprob, len(lp.TopLogprobs)),
+		}
+
+		for j, top := range lp.TopLogprobs {
+			logprobs.Content[i].TopLogprobs[j] = TopLogprob{Token: top.Token, Logprob: top.Logprob, Bytes: tokenBytes(top.Token)}
+		}
+	}
+
+	return &logprobs
+}
+
+func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
+	toolCalls := toToolCalls(r.Message.ToolCalls)
 	return ChatCompletion{
 		Id:                id,
 		Object:            "chat.completion",
@@ -223,8 +331,9 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []Choice{{
-			Index:   0,
-			Message: Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Index:    0,
+			Message:  Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs: toLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(toolCalls) > 0 {
 					reason = "tool_calls"
@@ -243,7 +352,18 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 	}
 }
 
-func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
+// toChunk converts a streamed chat response. toolCalls is the number of tool
+// calls sent in previous chunks and is used to index the tool calls in r.
+func toChunk(id string, r api.ChatResponse, toolCalls int) ChatCompletionChunk {
+	delta := Message{Role: "assistant", Content: r.Message.Content}
+	if len(r.Message.ToolCalls) > 0 {
+		delta.ToolCalls = toToolCalls(r.Message.ToolCalls)
+		for i := range delta.ToolCalls {
+			index := toolCalls + i
+			delta.ToolCalls[i].Index = &index
+		}
+	}
+
 	return ChatCompletionChunk{
 		Id:                id,
 		Object:            "chat.completion.chunk",
@@ -251,9 +371,13 @@ func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []ChunkChoice{{
-			Index: 0,
-			Delta: Message{Role: "assistant", Content: r.Message.Content},
+			Index:    0,
+			Delta:    delta,
+			Logprobs: toLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
+				if len(reason) > 0 && toolCalls+len(delta.ToolCalls) > 0 {
+					reason = "tool_calls"
+				}
 				if len(reason) > 0 {
 					return &reason
 				}
@@ -263,6 +387,30 @@ func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
 	}
 }
 
+// toCompletionLogprobs converts log probabilities to the format of the
+// completions API. offset is the position of the first token in the text.
+func toCompletionLogprobs(lps []api.Logprob, offset int) *CompletionLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	var logprobs CompletionLogprobs
+	for _, lp := range lps {
+		top := make(map[string]float64, len(lp.TopLogprobs))
+		for _, t := range lp.TopLogprobs {
+			top[t.Token] = t.Logprob
+		}
+
+		logprobs.Tokens = append(logprobs.Tokens, lp.Token)
+		logprobs.TokenLogprobs = append(logprobs.TokenLogprobs, lp.Logprob)
+		logprobs.TopLogprobs = append(logprobs.TopLogprobs, top)
+		logprobs.TextOffset = append(logprobs.TextOffset, offset)
+		offset += len(lp.Token)
+	}
+
+	return &logprobs
+}
+
 func toCompletion(id string, r api.GenerateResponse) Completion {
 	return Completion{
 		Id:                id,
@@ -271,8 +419,9 @@ func toCompletion(id string, r api.GenerateResponse) Completion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    0,
+			Logprobs: toCompletionLogprobs(r.Logprobs, 0),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -288,7 +437,9 @@ func toCompletion(id string, r api.GenerateResponse) Completion {
 	}
 }
 
-func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
+// toCompleteChunk converts a streamed generate response. offset is the
+// length of the text sent in previous chunks.
+func toCompleteChunk(id string, r api.GenerateResponse, offset int) CompletionChunk {
 	return CompletionChunk{
 		Id:                id,
 		Object:            "text_completion",
@@ -296,8 +447,9 @@ func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    0,
+			Logprobs: toCompletionLogprobs(r.Logprobs, offset),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -326,7 +478,7 @@ func toListCompletion(r api.ListResponse) ListCompletion {
 }
 
 func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
-	if r.Embeddings != nil {
+	if r.Embeddings != nil || r.EmbeddingsBase64 != nil {
 		var data []Embedding
 		for i, e := range r.Embeddings {
 			data = append(data, Embedding{
@@ -336,6 +488,14 @@ func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
 			})
 		}
 
+		for i, e := range r.EmbeddingsBase64 {
+			data = append(data, Embedding{
+				Object:    "embedding",
+				Embedding: e,
+				Index:     i,
+			})
+		}
+
 		return EmbeddingList{
 			Object: "list",
 			Data:   data,
@@ -475,22 +635,133 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		options["top_p"] = 1.0
 	}
 
-	var format string
-	if r.ResponseFormat != nil && r.ResponseFormat.Type == "json_object" {
-		format = "json"
+	if r.N != nil && (*r.N < 1 || *r.N > maxChoices) {
+		return nil, fmt.Errorf("n must be between 1 and %d", maxChoices)
+	}
+
+	if r.StreamOptions != nil && !r.Stream {
+		return nil, errors.New("stream_options is only allowed when stream is true")
+	}
+
+	var topLogprobs int
+	if r.TopLogprobs != nil {
+		if !r.Logprobs {
+			return nil, errors.New("logprobs must be true when top_logprobs is set")
+		}
+
+		if *r.TopLogprobs < 0 || *r.TopLogprobs > maxTopLogprobs {
+			return nil, fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+		}
+
+		topLogprobs = *r.TopLogprobs
+	}
+
+	var toolChoice string
+	switch choice := r.ToolChoice.(type) {
+	case nil:
+	case string:
+		switch choice {
+		case "none", "auto", "required":
+			toolChoice = choice
+		default:
+			return nil, fmt.Errorf("invalid tool_choice %q", choice)
+		}
+	case map[string]any:
+		function, _ := choice["function"].(map[string]any)
+		name, _ := function["name"].(string)
+		if choice["type"] != "function" || name == "" {
+			return nil, errors.New("invalid tool_choice, expected {\"type\": \"function\", \"function\": {\"name\": ...}}")
+		}
+
+		toolChoice = name
+	default:
+		return nil, fmt.Errorf("invalid tool_choice type: %T", choice)
+	}
+
+	var format json.RawMessage
+	if r.ResponseFormat != nil {
+		switch r.ResponseFormat.Type {
+		case "json_object":
+			format = json.RawMessage(`"json"`)
+		case "json_schema":
+			if r.ResponseFormat.JSONSchema == nil || len(r.ResponseFormat.JSONSchema.Schema) == 0 {
+				return nil, fmt.Errorf("response_format json_schema requires a schema")
+			}
+
+			format = r.ResponseFormat.JSONSchema.Schema
+		}
 	}
 
 	return &api.ChatRequest{
-		Model:    r.Model,
-		Messages: messages,
-		Format:   format,
-		Options:  options,
-		Stream:   &r.Stream,
-		Tools:    r.Tools,
+		Model:       r.Model,
+		Messages:    messages,
+		Format:      format,
+		Options:     options,
+		Stream:      &r.Stream,
+		Tools:       r.Tools,
+		ToolChoice:  toolChoice,
+		Logprobs:    r.Logprobs,
+		TopLogprobs: topLogprobs,
 	}, nil
 }
 
-func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
+// toTokens converts a JSON array of token IDs
+func toTokens(v []any) ([]int, bool) {
+	tokens := make([]int, len(v))
+	for i, t := range v {
+		f, ok := t.(float64)
+		if !ok || f < 0 || f != math.Trunc(f) {
+			return nil, false
+		}
+
+		tokens[i] = int(f)
+	}
+
+	return tokens, true
+}
+
+// completionPrompts returns the prompts of a completion request. Each
+// prompt is either text or tokens.
+func completionPrompts(prompt any) ([]api.GenerateRequest, error) {
+	switch p := prompt.(type) {
+	case nil:
+		return []api.GenerateRequest{{}}, nil
+	case string:
+		return []api.GenerateRequest{{Prompt: p}}, nil
+	case []any:
+		if len(p) == 0 {
+			return nil, errors.New("prompt must not be empty")
+		}
+
+		if tokens, ok := toTokens(p); ok {
+			return []api.GenerateRequest{{Tokens: tokens}}, nil
+		}
+
+		prompts := make([]api.GenerateRequest, len(p))
+		for i, e := range p {
+			switch e := e.(type) {
+			case string:
+				prompts[i].Prompt = e
+			case []any:
+				tokens, ok := toTokens(e)
+				if !ok || len(tokens) == 0 {
+					return nil, fmt.Errorf("invalid tokens in prompt %d", i)
+				}
+
+				prompts[i].Tokens = tokens
+			default:
+				return nil, fmt.Errorf("invalid type for prompt %d: %T", i, e)
+			}
+		}
+
+		return prompts, nil
+	default:
+		return nil, fmt.Errorf("invalid type for 'prompt' field: %T", p)
+	}
+}
+
+// fromCompleteRequest returns a generate request for each prompt of r
+func fromCompleteRequest(r CompletionRequest) ([]api.GenerateRequest, error) {
 	options := make(map[string]any)
 
 	switch stop := r.Stop.(type) {
@@ -502,7 +773,7 @@ func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 			if str, ok := s.(string); ok {
 				stops = append(stops, str)
 			} else {
-				return api.GenerateRequest{}, fmt.Errorf("invalid type for 'stop' field: %T", s)
+				return nil, fmt.Errorf("invalid type for 'stop' field: %T", s)
 			}
 		}
 		options["stop"] = stops
@@ -532,13 +803,30 @@ func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 		options["top_p"] = 1.0
 	}
 
-	return api.GenerateRequest{
-		Model:   r.Model,
-		Prompt:  r.Prompt,
-		Options: options,
-		Stream:  &r.Stream,
-		Suffix:  r.Suffix,
-	}, nil
+	var logprobs int
+	if r.Logprobs != nil {
+		if *r.Logprobs < 0 || *r.Logprobs > maxCompletionLogprobs {
+			return nil, fmt.Errorf("logprobs must be between 0 and %d", maxCompletionLogprobs)
+		}
+
+		logprobs = *r.Logprobs
+	}
+
+	prompts, err := completionPrompts(r.Prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range prompts {
+		prompts[i].Model = r.Model
+		prompts[i].Options = options
+		prompts[i].Stream = &r.Stream
+		prompts[i].Suffix = r.Suffix
+		prompts[i].Logprobs = r.Logprobs != nil
+		prompts[i].TopLogprobs = logprobs
+	}
+
+	return prompts, nil
 }
 
 type BaseWriter struct {
@@ -546,14 +834,37 @@ type BaseWriter struct {
 }
 
 type ChatWriter struct {
-	stream bool
-	id     string
+	stream        bool
+	streamOptions *StreamOptions
+	id            string
+	toolCalls     int
+
+	// parallelToolCalls is false if at most one tool call should be returned
+	parallelToolCalls bool
+
+	// n is the number of choices requested and index is the choice being
+	// written. Choices of a completion that isn't streamed are collected in
+	// completion until the last one is written.
+	n          int
+	index      int
+	completion *ChatCompletion
+	usage      Usage
+	failed     bool
 	BaseWriter
 }
 
 type CompleteWriter struct {
 	stream bool
 	id     string
+	offset int
+
+	// n is the number of prompts and index is the prompt being written, as
+	// in ChatWriter
+	n          int
+	index      int
+	completion *Completion
+	usage      Usage
+	failed     bool
 	BaseWriter
 }
 
@@ -594,20 +905,57 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 		return 0, err
 	}
 
+	if !w.parallelToolCalls {
+		chatResponse.Message.ToolCalls = chatResponse.Message.ToolCalls[:min(len(chatResponse.Message.ToolCalls), max(1-w.toolCalls, 0))]
+	}
+
+	if chatResponse.Done {
+		// the prompt is the same for every choice
+		w.usage.PromptTokens = chatResponse.PromptEvalCount
+		w.usage.CompletionTokens += chatResponse.EvalCount
+		w.usage.TotalTokens = w.usage.PromptTokens + w.usage.CompletionTokens
+	}
+
+	last := chatResponse.Done && w.index == w.n-1
+
 	// chat chunk
 	if w.stream {
-		d, err := json.Marshal(toChunk(w.id, chatResponse))
+		chunk := toChunk(w.id, chatResponse, w.toolCalls)
+		chunk.Choices[0].Index = w.index
+		d, err := json.Marshal(chunk)
 		if err != nil {
 			return 0, err
 		}
 
+		w.toolCalls += len(chatResponse.Message.ToolCalls)
+
 		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
 		_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d)))
 		if err != nil {
 			return 0, err
 		}
 
-		if chatResponse.Done {
+		if last && w.streamOptions != nil && w.streamOptions.IncludeUsage {
+			d, err := json.Marshal(ChatCompletionChunk{
+				Id:                w.id,
+				Object:            "chat.completion.chunk",
+				Created:           time.Now().Unix(),
+				Model:             chatResponse.Model,
+				SystemFingerprint: "fp_ollama",
+				Choices:           []ChunkChoice{},
+				Usage:             &w.usage,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d)))
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if last {
 			_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
 			if err != nil {
 				return 0, err
@@ -617,9 +965,22 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 		return len(data), nil
 	}
 
+	completion := toChatCompletion(w.id, chatResponse)
+	completion.Choices[0].Index = w.index
+	if w.completion == nil {
+		w.completion = &completion
+	} else {
+		w.completion.Choices = append(w.completion.Choices, completion.Choices...)
+	}
+
+	if !last {
+		return len(data), nil
+	}
+
 	// chat completion
+	w.completion.Usage = w.usage
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w.ResponseWriter).Encode(toChatCompletion(w.id, chatResponse))
+	err = json.NewEncoder(w.ResponseWriter).Encode(w.completion)
 	if err != nil {
 		return 0, err
 	}
@@ -630,9 +991,26 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 func (w *ChatWriter) Write(data []byte) (int, error) {
 	code := w.ResponseWriter.Status()
 	if code != http.StatusOK {
+		w.failed = true
 		return w.writeError(code, data)
 	}
 
+	// errors after the stream has started are sent as an event
+	var serr api.StatusError
+	if w.stream && json.Unmarshal(data, &serr) == nil && serr.ErrorMessage != "" {
+		w.failed = true
+		d, err := json.Marshal(NewError(http.StatusInternalServerError, serr.ErrorMessage))
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d))); err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
 	return w.writeResponse(data)
 }
 
@@ -643,20 +1021,32 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 		return 0, err
 	}
 
+	if generateResponse.Done {
+		w.usage.PromptTokens += generateResponse.PromptEvalCount
+		w.usage.CompletionTokens += generateResponse.EvalCount
+		w.usage.TotalTokens = w.usage.PromptTokens + w.usage.CompletionTokens
+	}
+
+	last := generateResponse.Done && w.index == w.n-1
+
 	// completion chunk
 	if w.stream {
-		d, err := json.Marshal(toCompleteChunk(w.id, generateResponse))
+		chunk := toCompleteChunk(w.id, generateResponse, w.offset)
+		chunk.Choices[0].Index = w.index
+		d, err := json.Marshal(chunk)
 		if err != nil {
 			return 0, err
 		}
 
+		w.offset += len(generateResponse.Response)
+
 		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
 		_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d)))
 		if err != nil {
 			return 0, err
 		}
 
-		if generateResponse.Done {
+		if last {
 			_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
 			if err != nil {
 				return 0, err
@@ -666,9 +1056,22 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 		return len(data), nil
 	}
 
+	completion := toCompletion(w.id, generateResponse)
+	completion.Choices[0].Index = w.index
+	if w.completion == nil {
+		w.completion = &completion
+	} else {
+		w.completion.Choices = append(w.completion.Choices, completion.Choices...)
+	}
+
+	if !last {
+		return len(data), nil
+	}
+
 	// completion
+	w.completion.Usage = w.usage
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w.ResponseWriter).Encode(toCompletion(w.id, generateResponse))
+	err = json.NewEncoder(w.ResponseWriter).Encode(w.completion)
 	if err != nil {
 		return 0, err
 	}
@@ -679,6 +1082,7 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 func (w *CompleteWriter) Write(data []byte) (int, error) {
 	code := w.ResponseWriter.Status()
 	if code != http.StatusOK {
+		w.failed = true
 		return w.writeError(code, data)
 	}
 
@@ -795,6 +1199,10 @@ func RetrieveMiddleware() gin.HandlerFunc {
 	}
 }
 
+// priorityHeader sets the priority class of a request, as in the priority
+// field of the native API
+const priorityHeader = "X-Ollama-Priority"
+
 func CompletionsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req CompletionRequest
@@ -804,29 +1212,67 @@ func CompletionsMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		var b bytes.Buffer
-		genReq, err := fromCompleteRequest(req)
+		genReqs, err := fromCompleteRequest(req)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
 			return
 		}
 
-		if err := json.NewEncoder(&b).Encode(genReq); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
-			return
-		}
+		bodies := make([][]byte, len(genReqs))
+		for i, genReq := range genReqs {
+			genReq.Priority = c.GetHeader(priorityHeader)
 
-		c.Request.Body = io.NopCloser(&b)
+			var b bytes.Buffer
+			if err := json.NewEncoder(&b).Encode(genReq); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+				return
+			}
+
+			bodies[i] = b.Bytes()
+		}
 
 		w := &CompleteWriter{
 			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
 			stream:     req.Stream,
 			id:         fmt.Sprintf("cmpl-%d", rand.Intn(999)),
+			n:          len(bodies),
 		}
 
 		c.Writer = w
+		serveChoices(c, bodies, func(i int) bool {
+			if i > 0 && w.failed {
+				return false
+			}
+
+			w.index, w.offset = i, 0
+			return true
+		})
+	}
+}
+
+// serveChoices sends each body to the route's handler, so each request
+// generates one choice of the response. Requests are served one after
+// another since they share the response writer, so the prompt is evaluated
+// once per choice. next is called before each request and returns false to
+// stop early.
+func serveChoices(c *gin.Context, bodies [][]byte, next func(i int) bool) {
+	if len(bodies) == 1 {
+		c.Request.Body = io.NopCloser(bytes.NewReader(bodies[0]))
 		c.Next()
+		return
+	}
+
+	handler := c.Handler()
+	for i, body := range bodies {
+		if c.Request.Context().Err() != nil || !next(i) {
+			break
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		handler(c)
 	}
+
+	c.Abort()
 }
 
 func EmbeddingsMiddleware() gin.HandlerFunc {
@@ -852,8 +1298,26 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		switch req.EncodingFormat {
+		case "", "float", "base64":
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, fmt.Sprintf("invalid encoding_format %q, must be float or base64", req.EncodingFormat)))
+			return
+		}
+
+		if req.Dimensions < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "dimensions must be greater than 0"))
+			return
+		}
+
 		var b bytes.Buffer
-		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input}); err != nil {
+		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{
+			Model:          req.Model,
+			Input:          req.Input,
+			Dimensions:     req.Dimensions,
+			EncodingFormat: req.EncodingFormat,
+			Priority:       c.GetHeader(priorityHeader),
+		}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
 			return
 		}
@@ -885,29 +1349,55 @@ func ChatMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		var b bytes.Buffer
-
 		chatReq, err := fromChatRequest(req)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
 			return
 		}
 
-		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
-			return
+		chatReq.Priority = c.GetHeader(priorityHeader)
+
+		n := 1
+		if req.N != nil {
+			n = *req.N
 		}
 
-		c.Request.Body = io.NopCloser(&b)
+		// each choice is generated by its own request to the handler
+		bodies := make([][]byte, n)
+		for i := range bodies {
+			if i > 0 {
+				// the same seed would generate the same choice every time
+				if seed, ok := chatReq.Options["seed"].(int); ok {
+					chatReq.Options["seed"] = seed + 1
+				}
+			}
+
+			var b bytes.Buffer
+			if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+				return
+			}
+
+			bodies[i] = b.Bytes()
+		}
 
 		w := &ChatWriter{
-			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
-			stream:     req.Stream,
-			id:         fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
+			BaseWriter:        BaseWriter{ResponseWriter: c.Writer},
+			stream:            req.Stream,
+			streamOptions:     req.StreamOptions,
+			id:                fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
+			parallelToolCalls: req.ParallelToolCalls == nil || *req.ParallelToolCalls,
+			n:                 n,
 		}
 
 		c.Writer = w
+		serveChoices(c, bodies, func(i int) bool {
+			if i > 0 && w.failed {
+				return false
+			}
 
-		c.Next()
+			w.index, w.toolCalls = i, 0
+			return true
+		})
 	}
 }
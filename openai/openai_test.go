@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -74,6 +76,68 @@ func TestChatMiddleware(t *testing.T) {
 				}
 			},
 		},
+		{
+			Name: "chat handler with priority",
+			Setup: func(t *testing.T, req *http.Request) {
+				body := ChatCompletionRequest{
+					Model:    "test-model",
+					Messages: []Message{{Role: "user", Content: "Hello"}},
+				}
+				prepareRequest(req, body)
+				req.Header.Set("X-Ollama-Priority", "low")
+			},
+			Expected: func(t *testing.T, req *api.ChatRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusOK {
+					t.Fatalf("expected 200, got %d", resp.Code)
+				}
+
+				if req.Priority != "low" {
+					t.Fatalf("expected 'low', got %s", req.Priority)
+				}
+			},
+		},
+		{
+			Name: "chat handler with json schema",
+			Setup: func(t *testing.T, req *http.Request) {
+				body := ChatCompletionRequest{
+					Model:    "test-model",
+					Messages: []Message{{Role: "user", Content: "Hello"}},
+					ResponseFormat: &ResponseFormat{
+						Type: "json_schema",
+						JSONSchema: &JSONSchema{
+							Name:   "greeting",
+							Schema: json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}}}`),
+						},
+					},
+				}
+				prepareRequest(req, body)
+			},
+			Expected: func(t *testing.T, req *api.ChatRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusOK {
+					t.Fatalf("expected 200, got %d", resp.Code)
+				}
+
+				if string(req.Format) != `{"type":"object","properties":{"text":{"type":"string"}}}` {
+					t.Fatalf("expected schema format, got %s", req.Format)
+				}
+			},
+		},
+		{
+			Name: "chat handler with json schema missing schema",
+			Setup: func(t *testing.T, req *http.Request) {
+				body := ChatCompletionRequest{
+					Model:          "test-model",
+					Messages:       []Message{{Role: "user", Content: "Hello"}},
+					ResponseFormat: &ResponseFormat{Type: "json_schema"},
+				}
+				prepareRequest(req, body)
+			},
+			Expected: func(t *testing.T, req *api.ChatRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", resp.Code)
+				}
+			},
+		},
 		{
 			Name: "chat handler with image content",
 			Setup: func(t *testing.T, req *http.Request) {
@@ -154,6 +218,70 @@ func TestChatMiddleware(t *testing.T) {
 				}
 			},
 		},
+		{
+			Name: "chat handler with sampling fields",
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":            "test-model",
+					"messages":         []Message{{Role: "user", Content: "Hello"}},
+					"presence_penalty": 0.5,
+					"logprobs":         true,
+					"top_logprobs":     3,
+					"user":             "user-1",
+				})
+			},
+			Expected: func(t *testing.T, req *api.ChatRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusOK {
+					t.Fatalf("expected 200, got %d", resp.Code)
+				}
+
+				if req.Options["presence_penalty"] != 1.0 {
+					t.Fatalf("expected presence_penalty 1.0, got %v", req.Options["presence_penalty"])
+				}
+
+				if !req.Logprobs || req.TopLogprobs != 3 {
+					t.Fatalf("expected logprobs with 3 alternatives, got %v %d", req.Logprobs, req.TopLogprobs)
+				}
+			},
+		},
+		{
+			Name: "chat handler with tool choice",
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":       "test-model",
+					"messages":    []Message{{Role: "user", Content: "What's the weather like in Paris Today?"}},
+					"tool_choice": map[string]any{"type": "function", "function": map[string]any{"name": "get_current_weather"}},
+				})
+			},
+			Expected: func(t *testing.T, req *api.ChatRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusOK {
+					t.Fatalf("expected 200, got %d", resp.Code)
+				}
+
+				if req.ToolChoice != "get_current_weather" {
+					t.Fatalf("expected tool choice get_current_weather, got %q", req.ToolChoice)
+				}
+			},
+		},
+		{
+			Name: "chat handler with invalid fields",
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":        "test-model",
+					"messages":     []Message{{Role: "user", Content: "Hello"}},
+					"top_logprobs": 3,
+				})
+			},
+			Expected: func(t *testing.T, req *api.ChatRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", resp.Code)
+				}
+
+				if !strings.Contains(resp.Body.String(), "logprobs must be true") {
+					t.Fatalf("unexpected error %s", resp.Body.String())
+				}
+			},
+		},
 		{
 			Name: "chat handler error forwarding",
 			Setup: func(t *testing.T, req *http.Request) {
@@ -247,6 +375,57 @@ func TestCompletionsMiddleware(t *testing.T) {
 				}
 			},
 		},
+		{
+			Name: "completions handler with logprobs",
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":    "test-model",
+					"prompt":   "Hello",
+					"logprobs": 2,
+				})
+			},
+			Expected: func(t *testing.T, req *api.GenerateRequest, resp *httptest.ResponseRecorder) {
+				if !req.Logprobs || req.TopLogprobs != 2 {
+					t.Fatalf("expected logprobs with 2 alternatives, got %v %d", req.Logprobs, req.TopLogprobs)
+				}
+			},
+		},
+		{
+			Name: "completions handler with tokens",
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":  "test-model",
+					"prompt": []int{9906, 1917},
+				})
+			},
+			Expected: func(t *testing.T, req *api.GenerateRequest, resp *httptest.ResponseRecorder) {
+				if req.Prompt != "" {
+					t.Fatalf("expected no prompt, got %s", req.Prompt)
+				}
+
+				if !slices.Equal(req.Tokens, []int{9906, 1917}) {
+					t.Fatalf("expected [9906 1917], got %v", req.Tokens)
+				}
+			},
+		},
+		{
+			Name: "completions handler invalid prompt",
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":  "test-model",
+					"prompt": []any{"Hello", 1.5},
+				})
+			},
+			Expected: func(t *testing.T, req *api.GenerateRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", resp.Code)
+				}
+
+				if !strings.Contains(resp.Body.String(), "invalid type for prompt 1") {
+					t.Fatalf("unexpected error %s", resp.Body.String())
+				}
+			},
+		},
 		{
 			Name: "completions handler error forwarding",
 			Setup: func(t *testing.T, req *http.Request) {
@@ -354,6 +533,63 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 				}
 			},
 		},
+		{
+			Name: "embed handler with priority",
+			Setup: func(t *testing.T, req *http.Request) {
+				body := EmbedRequest{
+					Input: "Hello",
+					Model: "test-model",
+				}
+				prepareRequest(req, body)
+				req.Header.Set("X-Ollama-Priority", "high")
+			},
+			Expected: func(t *testing.T, req *api.EmbedRequest, resp *httptest.ResponseRecorder) {
+				if req.Priority != "high" {
+					t.Fatalf("expected 'high', got %s", req.Priority)
+				}
+			},
+		},
+		{
+			Name: "embed handler with dimensions and encoding format",
+			Setup: func(t *testing.T, req *http.Request) {
+				body := EmbedRequest{
+					Input:          "Hello",
+					Model:          "test-model",
+					Dimensions:     256,
+					EncodingFormat: "base64",
+				}
+				prepareRequest(req, body)
+			},
+			Expected: func(t *testing.T, req *api.EmbedRequest, resp *httptest.ResponseRecorder) {
+				if req.Dimensions != 256 {
+					t.Fatalf("expected 256, got %d", req.Dimensions)
+				}
+
+				if req.EncodingFormat != "base64" {
+					t.Fatalf("expected 'base64', got %s", req.EncodingFormat)
+				}
+			},
+		},
+		{
+			Name: "embed handler invalid encoding format",
+			Setup: func(t *testing.T, req *http.Request) {
+				body := EmbedRequest{
+					Input:          "Hello",
+					Model:          "test-model",
+					EncodingFormat: "binary",
+				}
+				prepareRequest(req, body)
+			},
+			Expected: func(t *testing.T, req *api.EmbedRequest, resp *httptest.ResponseRecorder) {
+				if resp.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", resp.Code)
+				}
+
+				if !strings.Contains(resp.Body.String(), "invalid encoding_format") {
+					t.Fatalf("unexpected error %s", resp.Body.String())
+				}
+			},
+		},
 		{
 			Name: "embed handler error forwarding",
 			Setup: func(t *testing.T, req *http.Request) {
@@ -472,6 +708,401 @@ func TestMiddlewareResponses(t *testing.T) {
 				}
 			},
 		},
+		{
+			Name:     "chat stream tool calls",
+			Method:   http.MethodPost,
+			Path:     "/api/chat",
+			TestPath: "/api/chat",
+			Handler:  ChatMiddleware,
+			Endpoint: func(c *gin.Context) {
+				for _, r := range []api.ChatResponse{
+					{Model: "test-model", Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+						{Function: api.ToolCallFunction{Name: "get_current_weather", Arguments: api.ToolCallFunctionArguments{"location": "Paris, France"}}},
+					}}},
+					{Model: "test-model", Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+						{Function: api.ToolCallFunction{Name: "get_current_weather", Arguments: api.ToolCallFunctionArguments{"location": "Toronto, Canada"}}},
+					}}},
+					{Model: "test-model", Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop"},
+				} {
+					bts, _ := json.Marshal(r)
+					c.Writer.Write(bts)
+				}
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, ChatCompletionRequest{
+					Model:    "test-model",
+					Messages: []Message{{Role: "user", Content: "What's the weather like in Paris and Toronto?"}},
+					Stream:   true,
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var chunks []ChatCompletionChunk
+				for _, line := range strings.Split(resp.Body.String(), "\n") {
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok || data == "[DONE]" {
+						continue
+					}
+
+					var chunk ChatCompletionChunk
+					if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+						t.Fatal(err)
+					}
+
+					chunks = append(chunks, chunk)
+				}
+
+				if len(chunks) != 3 {
+					t.Fatalf("expected 3 chunks, got %d", len(chunks))
+				}
+
+				for i, chunk := range chunks[:2] {
+					toolCalls := chunk.Choices[0].Delta.ToolCalls
+					if len(toolCalls) != 1 {
+						t.Fatalf("expected 1 tool call, got %d", len(toolCalls))
+					}
+
+					if toolCalls[0].Index == nil || *toolCalls[0].Index != i {
+						t.Fatalf("expected tool call index %d, got %v", i, toolCalls[0].Index)
+					}
+
+					if toolCalls[0].Function.Name != "get_current_weather" {
+						t.Fatalf("expected get_current_weather, got %s", toolCalls[0].Function.Name)
+					}
+				}
+
+				if args := chunks[1].Choices[0].Delta.ToolCalls[0].Function.Arguments; args != `{"location":"Toronto, Canada"}` {
+					t.Fatalf("unexpected arguments %s", args)
+				}
+
+				if reason := chunks[2].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+					t.Fatalf("expected finish reason tool_calls, got %v", reason)
+				}
+			},
+		},
+		{
+			Name:     "completions stream logprobs",
+			Method:   http.MethodPost,
+			Path:     "/api/generate",
+			TestPath: "/api/generate",
+			Handler:  CompletionsMiddleware,
+			Endpoint: func(c *gin.Context) {
+				for _, r := range []api.GenerateResponse{
+					{Model: "test-model", Response: "Hello", Logprobs: []api.Logprob{{Token: "Hello", Logprob: -0.1, TopLogprobs: []api.TokenLogprob{{Token: "Hello", Logprob: -0.1}, {Token: "Hi", Logprob: -2.5}}}}},
+					{Model: "test-model", Response: " world", Logprobs: []api.Logprob{{Token: " world", Logprob: -0.2, TopLogprobs: []api.TokenLogprob{{Token: " world", Logprob: -0.2}, {Token: " there", Logprob: -1.5}}}}},
+					{Model: "test-model", Done: true, DoneReason: "stop"},
+				} {
+					bts, _ := json.Marshal(r)
+					c.Writer.Write(bts)
+				}
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":    "test-model",
+					"prompt":   "Say hello",
+					"stream":   true,
+					"logprobs": 2,
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var logprobs []*CompletionLogprobs
+				for _, line := range strings.Split(resp.Body.String(), "\n") {
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok || data == "[DONE]" {
+						continue
+					}
+
+					var chunk CompletionChunk
+					if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+						t.Fatal(err)
+					}
+
+					logprobs = append(logprobs, chunk.Choices[0].Logprobs)
+				}
+
+				if len(logprobs) != 3 || logprobs[2] != nil {
+					t.Fatalf("expected logprobs in the first 2 of 3 chunks, got %v", logprobs)
+				}
+
+				want := &CompletionLogprobs{
+					Tokens:        []string{" world"},
+					TokenLogprobs: []float64{-0.2},
+					TopLogprobs:   []map[string]float64{{" world": -0.2, " there": -1.5}},
+					TextOffset:    []int{5},
+				}
+
+				assert.Equal(t, want, logprobs[1])
+			},
+		},
+		{
+			Name:     "chat multiple choices",
+			Method:   http.MethodPost,
+			Path:     "/api/chat",
+			TestPath: "/api/chat",
+			Handler:  ChatMiddleware,
+			Endpoint: func(c *gin.Context) {
+				var req api.ChatRequest
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, api.ChatResponse{
+					Model:      "test-model",
+					Message:    api.Message{Role: "assistant", Content: fmt.Sprint(req.Options["seed"])},
+					Done:       true,
+					DoneReason: "stop",
+					Logprobs:   []api.Logprob{{Token: "1", Logprob: -0.5, TopLogprobs: []api.TokenLogprob{{Token: "1", Logprob: -0.5}}}},
+					Metrics:    api.Metrics{PromptEvalCount: 5, EvalCount: 1},
+				})
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":    "test-model",
+					"messages": []Message{{Role: "user", Content: "Pick a number"}},
+					"n":        2,
+					"seed":     41,
+					"logprobs": true,
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var completion ChatCompletion
+				if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(completion.Choices) != 2 {
+					t.Fatalf("expected 2 choices, got %d", len(completion.Choices))
+				}
+
+				for i, want := range []string{"41", "42"} {
+					choice := completion.Choices[i]
+					if choice.Index != i || choice.Message.Content != want {
+						t.Fatalf("unexpected choice %d: %+v", i, choice)
+					}
+
+					if choice.Logprobs == nil || len(choice.Logprobs.Content) != 1 || choice.Logprobs.Content[0].Logprob != -0.5 || len(choice.Logprobs.Content[0].TopLogprobs) != 1 {
+						t.Fatalf("unexpected logprobs %+v", choice.Logprobs)
+					}
+				}
+
+				if completion.Usage != (Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}) {
+					t.Fatalf("unexpected usage %+v", completion.Usage)
+				}
+			},
+		},
+		{
+			Name:     "chat stream multiple choices with usage",
+			Method:   http.MethodPost,
+			Path:     "/api/chat",
+			TestPath: "/api/chat",
+			Handler:  ChatMiddleware,
+			Endpoint: func(c *gin.Context) {
+				for _, r := range []api.ChatResponse{
+					{Model: "test-model", Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+						{Function: api.ToolCallFunction{Name: "get_current_weather", Arguments: api.ToolCallFunctionArguments{"location": "Paris, France"}}},
+						{Function: api.ToolCallFunction{Name: "get_current_weather", Arguments: api.ToolCallFunctionArguments{"location": "Toronto, Canada"}}},
+					}}},
+					{Model: "test-model", Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 5, EvalCount: 3}},
+				} {
+					bts, _ := json.Marshal(r)
+					c.Writer.Write(bts)
+				}
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":               "test-model",
+					"messages":            []Message{{Role: "user", Content: "What's the weather like in Paris and Toronto?"}},
+					"stream":              true,
+					"stream_options":      map[string]any{"include_usage": true},
+					"n":                   2,
+					"parallel_tool_calls": false,
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var chunks []ChatCompletionChunk
+				var done int
+				for _, line := range strings.Split(resp.Body.String(), "\n") {
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok {
+						continue
+					} else if data == "[DONE]" {
+						done++
+						continue
+					}
+
+					var chunk ChatCompletionChunk
+					if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+						t.Fatal(err)
+					}
+
+					chunks = append(chunks, chunk)
+				}
+
+				if len(chunks) != 5 || done != 1 {
+					t.Fatalf("expected 5 chunks and 1 [DONE], got %d and %d", len(chunks), done)
+				}
+
+				for i, chunk := range chunks[:4] {
+					if chunk.Choices[0].Index != i/2 {
+						t.Fatalf("expected choice %d, got %d", i/2, chunk.Choices[0].Index)
+					}
+
+					if chunk.Usage != nil {
+						t.Fatalf("unexpected usage in chunk %d", i)
+					}
+				}
+
+				for _, chunk := range []ChatCompletionChunk{chunks[0], chunks[2]} {
+					if len(chunk.Choices[0].Delta.ToolCalls) != 1 {
+						t.Fatalf("expected 1 tool call, got %d", len(chunk.Choices[0].Delta.ToolCalls))
+					}
+				}
+
+				last := chunks[4]
+				if len(last.Choices) != 0 || last.Usage == nil || *last.Usage != (Usage{PromptTokens: 5, CompletionTokens: 6, TotalTokens: 11}) {
+					t.Fatalf("unexpected usage chunk %+v", last)
+				}
+			},
+		},
+		{
+			Name:     "embeddings base64",
+			Method:   http.MethodPost,
+			Path:     "/api/embed",
+			TestPath: "/api/embed",
+			Handler:  EmbeddingsMiddleware,
+			Endpoint: func(c *gin.Context) {
+				c.JSON(http.StatusOK, api.EmbedResponse{
+					Model:            "test-model",
+					EmbeddingsBase64: []string{"AACAPw==", "AAAAQA=="},
+					PromptEvalCount:  2,
+				})
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, EmbedRequest{
+					Model:          "test-model",
+					Input:          []string{"Hello", "World"},
+					EncodingFormat: "base64",
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var list EmbeddingList
+				if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(list.Data) != 2 {
+					t.Fatalf("expected 2 embeddings, got %d", len(list.Data))
+				}
+
+				for i, want := range []string{"AACAPw==", "AAAAQA=="} {
+					if list.Data[i].Index != i || list.Data[i].Embedding != want {
+						t.Fatalf("unexpected embedding %d: %+v", i, list.Data[i])
+					}
+				}
+			},
+		},
+		{
+			Name:     "completions multiple prompts",
+			Method:   http.MethodPost,
+			Path:     "/api/generate",
+			TestPath: "/api/generate",
+			Handler:  CompletionsMiddleware,
+			Endpoint: func(c *gin.Context) {
+				var req api.GenerateRequest
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, api.GenerateResponse{
+					Model:      "test-model",
+					Response:   fmt.Sprint(req.Prompt, req.Tokens),
+					Done:       true,
+					DoneReason: "stop",
+					Metrics:    api.Metrics{PromptEvalCount: 2, EvalCount: 1},
+				})
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":  "test-model",
+					"prompt": []any{"Hello", []int{1, 2}},
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var completion Completion
+				if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(completion.Choices) != 2 {
+					t.Fatalf("expected 2 choices, got %d", len(completion.Choices))
+				}
+
+				for i, want := range []string{"Hello[]", "[1 2]"} {
+					choice := completion.Choices[i]
+					if choice.Index != i || choice.Text != want {
+						t.Fatalf("unexpected choice %d: %+v", i, choice)
+					}
+				}
+
+				if completion.Usage != (Usage{PromptTokens: 4, CompletionTokens: 2, TotalTokens: 6}) {
+					t.Fatalf("unexpected usage %+v", completion.Usage)
+				}
+			},
+		},
+		{
+			Name:     "completions stream multiple prompts",
+			Method:   http.MethodPost,
+			Path:     "/api/generate",
+			TestPath: "/api/generate",
+			Handler:  CompletionsMiddleware,
+			Endpoint: func(c *gin.Context) {
+				for _, r := range []api.GenerateResponse{
+					{Model: "test-model", Response: "Hi"},
+					{Model: "test-model", Done: true, DoneReason: "stop"},
+				} {
+					bts, _ := json.Marshal(r)
+					c.Writer.Write(bts)
+				}
+			},
+			Setup: func(t *testing.T, req *http.Request) {
+				prepareRequest(req, map[string]any{
+					"model":  "test-model",
+					"prompt": []string{"Hello", "Goodbye"},
+					"stream": true,
+				})
+			},
+			Expected: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				var chunks []CompletionChunk
+				var done int
+				for _, line := range strings.Split(resp.Body.String(), "\n") {
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok {
+						continue
+					} else if data == "[DONE]" {
+						done++
+						continue
+					}
+
+					var chunk CompletionChunk
+					if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+						t.Fatal(err)
+					}
+
+					chunks = append(chunks, chunk)
+				}
+
+				if len(chunks) != 4 || done != 1 {
+					t.Fatalf("expected 4 chunks and 1 [DONE], got %d and %d", len(chunks), done)
+				}
+
+				for i, chunk := range chunks {
+					if chunk.Choices[0].Index != i/2 {
+						t.Fatalf("expected choice %d, got %d", i/2, chunk.Choices[0].Index)
+					}
+				}
+			},
+		},
 	}
 
 	gin.SetMode(gin.TestMode)
@@ -0,0 +1,494 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Model archives are tar files in the OCI image layout. See
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+const (
+	ociLayoutFile     = "oci-layout"
+	ociLayoutVersion  = "1.0.0"
+	ociIndexFile      = "index.json"
+	ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+	ociBlobsDir       = "blobs/sha256/"
+
+	// ociRefNameAnnotation holds the tag of a manifest in the index, and
+	// ociImageNameAnnotation its full name
+	ociRefNameAnnotation   = "org.opencontainers.image.ref.name"
+	ociImageNameAnnotation = "io.containerd.image.name"
+
+	manifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+var errInvalidArchive = errors.New("invalid archive")
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ExportArchive writes the model n, its manifest and blobs to w as a tar
+// archive in the OCI image layout
+func ExportArchive(w io.Writer, n model.Name) error {
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		return err
+	}
+
+	// the manifest is copied as is so its digest doesn't change
+	manifest, err := os.ReadFile(m.filepath)
+	if err != nil {
+		return err
+	}
+
+	digest := "sha256:" + m.digest
+
+	index, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests: []ociDescriptor{
+			{
+				MediaType: cmp.Or(m.MediaType, manifestMediaType),
+				Digest:    digest,
+				Size:      int64(len(manifest)),
+				Annotations: map[string]string{
+					ociRefNameAnnotation:   n.Tag,
+					ociImageNameAnnotation: n.String(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	layout, err := json.Marshal(ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	write := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o644,
+			// a fixed time makes archives of the same model identical
+			ModTime: time.Unix(0, 0),
+		}); err != nil {
+			return err
+		}
+
+		_, err := io.Copy(tw, r)
+		return err
+	}
+
+	if err := write(ociLayoutFile, int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+
+	if err := write(ociIndexFile, int64(len(index)), bytes.NewReader(index)); err != nil {
+		return err
+	}
+
+	if err := write(ociBlobPath(digest), int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+		if layer.Digest == "" || written[layer.Digest] {
+			continue
+		}
+
+		if err := func() error {
+			f, err := layer.Open()
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			return write(ociBlobPath(layer.Digest), layer.Size, f)
+		}(); err != nil {
+			return err
+		}
+
+		written[layer.Digest] = true
+	}
+
+	return tw.Close()
+}
+
+func ociBlobPath(digest string) string {
+	return ociBlobsDir + strings.TrimPrefix(digest, "sha256:")
+}
+
+// ImportArchive installs the models in r, a tar archive in the OCI image
+// layout. Each blob's digest is verified, and blobs that already exist are
+// shared. Blobs it created are removed if the import fails.
+func ImportArchive(r io.Reader, fn func(api.ProgressResponse)) (err error) {
+	var layout *ociLayout
+	var index *ociIndex
+
+	// blobs holds the size of each blob in the archive
+	blobs := make(map[string]int64)
+	created := make(map[string]bool)
+
+	defer func() {
+		if err != nil {
+			for digest := range created {
+				layer := Layer{Digest: digest}
+				if err := layer.Remove(); err != nil {
+					slog.Warn("couldn't remove layer", "digest", digest, "error", err)
+				}
+			}
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch name := path.Clean(hdr.Name); {
+		case name == ociLayoutFile:
+			if err := json.NewDecoder(tr).Decode(&layout); err != nil {
+				return fmt.Errorf("%w: %s: %w", errInvalidArchive, ociLayoutFile, err)
+			}
+
+			if layout.ImageLayoutVersion != ociLayoutVersion {
+				return fmt.Errorf("%w: unsupported image layout version %q", errInvalidArchive, layout.ImageLayoutVersion)
+			}
+		case name == ociIndexFile:
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				return fmt.Errorf("%w: %s: %w", errInvalidArchive, ociIndexFile, err)
+			}
+		case strings.HasPrefix(name, ociBlobsDir):
+			digest := "sha256:" + strings.TrimPrefix(name, ociBlobsDir)
+			isNew, err := importBlob(tr, digest)
+			if err != nil {
+				return err
+			}
+
+			status := "using existing layer"
+			if isNew {
+				status = "creating new layer"
+				created[digest] = true
+			}
+
+			blobs[digest] = hdr.Size
+			fn(api.ProgressResponse{Status: fmt.Sprintf("%s %s", status, digest)})
+		}
+	}
+
+	if layout == nil {
+		return fmt.Errorf("%w: missing %s", errInvalidArchive, ociLayoutFile)
+	} else if index == nil {
+		return fmt.Errorf("%w: missing %s", errInvalidArchive, ociIndexFile)
+	} else if len(index.Manifests) == 0 {
+		return fmt.Errorf("%w: no manifests", errInvalidArchive)
+	}
+
+	type namedManifest struct {
+		name     model.Name
+		digest   string
+		manifest []byte
+	}
+
+	// check every manifest before writing any of them
+	var manifests []namedManifest
+	for _, desc := range index.Manifests {
+		name := model.ParseName(cmp.Or(desc.Annotations[ociImageNameAnnotation], desc.Annotations[ociRefNameAnnotation]))
+		if !name.IsValid() {
+			return fmt.Errorf("%w: manifest %s has no valid model name", errInvalidArchive, desc.Digest)
+		}
+
+		if size, ok := blobs[desc.Digest]; !ok || size != desc.Size {
+			return fmt.Errorf("%w: manifest %s is missing", errInvalidArchive, desc.Digest)
+		}
+
+		p, err := GetBlobsPath(desc.Digest)
+		if err != nil {
+			return err
+		}
+
+		bts, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var m Manifest
+		if err := json.Unmarshal(bts, &m); err != nil {
+			return fmt.Errorf("%w: manifest %s: %w", errInvalidArchive, desc.Digest, err)
+		}
+
+		for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+			if layer.Digest == "" {
+				continue
+			}
+
+			p, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				return fmt.Errorf("%w: %w", errInvalidArchive, err)
+			}
+
+			if fi, err := os.Stat(p); err != nil || fi.Size() != layer.Size {
+				return fmt.Errorf("%w: layer %s of %s is missing", errInvalidArchive, layer.Digest, name.DisplayShortest())
+			}
+		}
+
+		if err := checkNameExists(name); err != nil {
+			return fmt.Errorf("%w: %s: %w", errInvalidArchive, name.DisplayShortest(), err)
+		}
+
+		manifests = append(manifests, namedManifest{name, desc.Digest, bts})
+	}
+
+	dir, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range manifests {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("writing manifest for %s", m.name.DisplayShortest())})
+
+		p := filepath.Join(dir, m.name.Filepath())
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(p, m.manifest, 0o644); err != nil {
+			return err
+		}
+	}
+
+	// manifests aren't blobs in the local store
+	for _, m := range manifests {
+		if created[m.digest] {
+			layer := Layer{Digest: m.digest}
+			if err := layer.Remove(); err != nil {
+				return err
+			}
+
+			delete(created, m.digest)
+		}
+	}
+
+	return nil
+}
+
+// importBlob copies the blob with the given digest from r into the blob
+// store, verifying its digest. It reports whether the blob was created; a
+// blob that already exists is kept.
+func importBlob(r io.Reader, digest string) (bool, error) {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", errInvalidArchive, err)
+	}
+
+	sha256sum := sha256.New()
+	if _, err := os.Stat(p); err == nil {
+		if _, err := io.Copy(sha256sum, r); err != nil {
+			return false, fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+
+		if got := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); got != digest {
+			return false, fmt.Errorf("%w: digest mismatch, expected %s, got %s", errInvalidArchive, digest, got)
+		}
+
+		return false, nil
+	}
+
+	blobs, err := GetBlobsPath("")
+	if err != nil {
+		return false, err
+	}
+
+	temp, err := os.CreateTemp(blobs, "sha256-")
+	if err != nil {
+		return false, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if _, err := io.Copy(io.MultiWriter(temp, sha256sum), r); err != nil {
+		return false, fmt.Errorf("%w: %w", errInvalidArchive, err)
+	}
+
+	if got := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); got != digest {
+		return false, fmt.Errorf("%w: digest mismatch, expected %s, got %s", errInvalidArchive, digest, got)
+	}
+
+	if err := temp.Close(); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(temp.Name(), p); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *Server) ExportModelHandler(c *gin.Context) {
+	var req api.ExportRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid model name"})
+		return
+	}
+
+	if _, err := ParseNamedManifest(name); errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Status(http.StatusOK)
+	if err := ExportArchive(c.Writer, name); err != nil {
+		// the archive is incomplete, which clients detect when reading it
+		slog.Error("couldn't export model", "model", name.DisplayShortest(), "error", err)
+		c.Abort()
+	}
+}
+
+func (s *Server) ImportModelHandler(c *gin.Context) {
+	// progress is sent as each blob is imported, while the archive is still
+	// being read, which needs a full duplex connection. Otherwise the body
+	// must be read completely before the response is written, so progress
+	// is sent once the import is done.
+	if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+		slog.Debug("streaming import progress isn't supported", "error", err)
+		importBuffered(c)
+		return
+	}
+
+	// nothing is sent once the client is gone
+	ctx := c.Request.Context()
+	send := func(ch chan any, v any) {
+		select {
+		case ch <- v:
+		case <-ctx.Done():
+		}
+	}
+
+	progress := make(chan any)
+	go func() {
+		defer close(progress)
+		if err := ImportArchive(c.Request.Body, func(r api.ProgressResponse) {
+			send(progress, r)
+		}); err != nil {
+			send(progress, err)
+			return
+		}
+
+		send(progress, api.ProgressResponse{Status: "success"})
+	}()
+
+	// an import that fails before any progress is reported fails with a
+	// status code, later failures end the stream with an error
+	first, ok := <-progress
+	if !ok {
+		return
+	} else if err, ok := first.(error); ok {
+		abortImport(c, err)
+		return
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		send(ch, first)
+		for r := range progress {
+			if err, ok := r.(error); ok {
+				r = gin.H{"error": err.Error()}
+			}
+			send(ch, r)
+		}
+	}()
+
+	streamResponse(c, ch)
+}
+
+// importBuffered imports the archive in the request body, then sends its
+// progress
+func importBuffered(c *gin.Context) {
+	var progress []api.ProgressResponse
+	if err := ImportArchive(c.Request.Body, func(r api.ProgressResponse) {
+		progress = append(progress, r)
+	}); err != nil {
+		abortImport(c, err)
+		return
+	}
+
+	ch := make(chan any, len(progress)+1)
+	for _, r := range progress {
+		ch <- r
+	}
+
+	ch <- api.ProgressResponse{Status: "success"}
+	close(ch)
+
+	streamResponse(c, ch)
+}
+
+func abortImport(c *gin.Context, err error) {
+	if errors.Is(err, errInvalidArchive) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
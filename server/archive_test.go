@@ -0,0 +1,341 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestArchive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE {{ .Prompt }}", createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	name := model.ParseName("test")
+	m, err := ParseNamedManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := os.ReadFile(m.filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := func() []string {
+		matches, err := filepath.Glob(filepath.Join(p, "blobs", "sha256-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return matches
+	}
+
+	wantBlobs := blobs()
+	if len(wantBlobs) != 3 {
+		t.Fatalf("expected 3 blobs, got %d", len(wantBlobs))
+	}
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := api.NewClient(base, http.DefaultClient)
+
+	var archive bytes.Buffer
+	completed := make(map[string]int64)
+	if err := client.Export(context.TODO(), &api.ExportRequest{Model: "test"}, &archive, func(resp api.ProgressResponse) error {
+		completed[resp.Digest] = resp.Completed
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("export", func(t *testing.T) {
+		var names []string
+		tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			names = append(names, hdr.Name)
+		}
+
+		want := []string{ociLayoutFile, ociIndexFile, ociBlobPath("sha256:" + m.digest), ociBlobPath(m.Config.Digest)}
+		for _, layer := range m.Layers {
+			want = append(want, ociBlobPath(layer.Digest))
+		}
+
+		if diff := cmp.Diff(names, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		wantCompleted := map[string]int64{"sha256:" + m.digest: int64(len(manifest)), m.Config.Digest: m.Config.Size}
+		for _, layer := range m.Layers {
+			wantCompleted[layer.Digest] = layer.Size
+		}
+
+		if diff := cmp.Diff(completed, wantCompleted); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("export missing", func(t *testing.T) {
+		err := client.Export(context.TODO(), &api.ExportRequest{Model: "missing"}, io.Discard, func(api.ProgressResponse) error { return nil })
+		var statusErr api.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected status 404, got %v", err)
+		}
+	})
+
+	deleteModel := func(t *testing.T) {
+		t.Helper()
+		if w := createRequest(t, s.DeleteModelHandler, api.DeleteRequest{Name: "test"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if got := blobs(); len(got) != 0 {
+			t.Fatalf("expected no blobs, got %v", got)
+		}
+	}
+
+	importArchive := func(r io.Reader) ([]string, error) {
+		var statuses []string
+		err := client.Import(context.TODO(), r, func(resp api.ProgressResponse) error {
+			if resp.Digest == "" {
+				statuses = append(statuses, resp.Status)
+			}
+			return nil
+		})
+		return statuses, err
+	}
+
+	t.Run("import", func(t *testing.T) {
+		deleteModel(t)
+
+		statuses, err := importArchive(bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Contains(statuses, "creating new layer "+m.Config.Digest) || statuses[len(statuses)-1] != "success" {
+			t.Errorf("unexpected statuses %v", statuses)
+		}
+
+		got, err := ParseNamedManifest(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.digest != m.digest {
+			t.Errorf("expected manifest digest %s, got %s", m.digest, got.digest)
+		}
+
+		// the manifest isn't left in the blob store
+		if diff := cmp.Diff(blobs(), wantBlobs); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("import existing", func(t *testing.T) {
+		statuses, err := importArchive(bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Contains(statuses, "using existing layer "+m.Config.Digest) {
+			t.Errorf("unexpected statuses %v", statuses)
+		}
+
+		if diff := cmp.Diff(blobs(), wantBlobs); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("import progress", func(t *testing.T) {
+		// everything but the end of the archive is sent, which progress
+		// is reported for before the rest is
+		b := archive.Bytes()
+		end := len(b) - 1024
+		pr, pw := io.Pipe()
+		go func() {
+			if _, err := pw.Write(b[:end]); err != nil {
+				pw.CloseWithError(err)
+			}
+		}()
+
+		progress := make(chan string, 16)
+		done := make(chan error, 1)
+		go func() {
+			done <- client.Import(context.TODO(), pr, func(resp api.ProgressResponse) error {
+				if resp.Digest == "" {
+					progress <- resp.Status
+				}
+				return nil
+			})
+		}()
+
+		select {
+		case status := <-progress:
+			if !strings.Contains(status, " layer sha256:") {
+				t.Errorf("unexpected status %q", status)
+			}
+		case err := <-done:
+			t.Fatalf("import ended early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected progress before the archive was sent")
+		}
+
+		go func() {
+			_, err := pw.Write(b[end:])
+			pw.CloseWithError(err)
+		}()
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// rewrite returns the archive with the entries for which fn returns
+	// false removed, and the contents of the others replaced by fn
+	rewrite := func(t *testing.T, fn func(name string, b []byte) ([]byte, bool)) io.Reader {
+		t.Helper()
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			bts, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bts, ok := fn(hdr.Name, bts)
+			if !ok {
+				continue
+			}
+
+			hdr.Size = int64(len(bts))
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := tw.Write(bts); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		return &b
+	}
+
+	cases := []struct {
+		name string
+		fn   func(name string, b []byte) ([]byte, bool)
+		err  string
+	}{
+		{
+			name: "corrupt blob",
+			fn: func(name string, b []byte) ([]byte, bool) {
+				if name == ociBlobPath(m.Layers[0].Digest) {
+					b[len(b)-1]++
+				}
+				return b, true
+			},
+			err: "digest mismatch",
+		},
+		{
+			name: "missing layer",
+			fn: func(name string, b []byte) ([]byte, bool) {
+				return b, name != ociBlobPath(m.Layers[0].Digest)
+			},
+			err: fmt.Sprintf("layer %s of test:latest is missing", m.Layers[0].Digest),
+		},
+		{
+			name: "missing index",
+			fn: func(name string, b []byte) ([]byte, bool) {
+				return b, name != ociIndexFile
+			},
+			err: "missing index.json",
+		},
+		{
+			name: "missing name",
+			fn: func(name string, b []byte) ([]byte, bool) {
+				if name == ociIndexFile {
+					b = bytes.ReplaceAll(b, []byte("registry.ollama.ai/library/test:latest"), nil)
+					b = bytes.ReplaceAll(b, []byte(`"latest"`), []byte(`""`))
+				}
+				return b, true
+			},
+			err: "has no valid model name",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			deleteModel(t)
+
+			_, err := importArchive(rewrite(t, tt.fn))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+
+			// blobs from a failed import are removed
+			if got := blobs(); len(got) != 0 {
+				t.Errorf("expected no blobs, got %v", got)
+			}
+
+			if _, err := importArchive(bytes.NewReader(archive.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1171 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/openai"
+)
+
+const (
+	batchValidating = "validating"
+	batchFailed     = "failed"
+	batchInProgress = "in_progress"
+	batchFinalizing = "finalizing"
+	batchCompleted  = "completed"
+	batchExpired    = "expired"
+	batchCancelling = "cancelling"
+	batchCancelled  = "cancelled"
+)
+
+// batchCompletionWindow is the only completion window supported, as with
+// the OpenAI API
+const batchCompletionWindow = "24h"
+
+// maxBatchFileSize is the largest file that can be uploaded, as with the
+// OpenAI API
+var maxBatchFileSize int64 = 200 * format.MegaByte
+
+var batchEndpoints = []string{"/v1/chat/completions", "/v1/completions", "/v1/embeddings"}
+
+var (
+	fileIDPattern  = regexp.MustCompile(`^file-[0-9a-f]{32}$`)
+	batchIDPattern = regexp.MustCompile(`^batch_[0-9a-f]{32}$`)
+)
+
+// batchesMu serializes changes to the metadata of stored files and batches
+var batchesMu sync.Mutex
+
+func newBatchID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+func batchesDir(kind string) (string, error) {
+	dir := filepath.Join(envconfig.Batches(), kind)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// filePath returns the path of a stored file's content. Its metadata is
+// stored next to it with a .json extension.
+func filePath(id string) (string, error) {
+	// IDs are generated by the server, anything else can't exist
+	if !fileIDPattern.MatchString(id) {
+		return "", fmt.Errorf("file %q %w", id, os.ErrNotExist)
+	}
+
+	dir, err := batchesDir("files")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, id), nil
+}
+
+func batchPath(id string) (string, error) {
+	if !batchIDPattern.MatchString(id) {
+		return "", fmt.Errorf("batch %q %w", id, os.ErrNotExist)
+	}
+
+	dir, err := batchesDir("batches")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, id), nil
+}
+
+func readJSONFile(path string, v any) error {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bts, v)
+}
+
+// writeJSONFile replaces the file at path. The file is written to a
+// temporary file first so readers never see a partial file.
+func writeJSONFile(path string, v any) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "batch-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func readBatchFile(id string) (*openai.File, error) {
+	p, err := filePath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var f openai.File
+	if err := readJSONFile(p+".json", &f); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("file %q %w", id, os.ErrNotExist)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+func createBatchFile(r io.Reader, filename, purpose string) (*openai.File, error) {
+	id := newBatchID("file-")
+	p, err := filePath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "file-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(f.Name(), p); err != nil {
+		return nil, err
+	}
+
+	file := openai.File{
+		ID:        id,
+		Object:    "file",
+		Bytes:     n,
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+	}
+
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	if err := writeJSONFile(p+".json", file); err != nil {
+		os.Remove(p)
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+func readBatch(id string) (*openai.Batch, error) {
+	p, err := batchPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var b openai.Batch
+	if err := readJSONFile(p+".json", &b); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("batch %q %w", id, os.ErrNotExist)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func writeBatch(b *openai.Batch) error {
+	p, err := batchPath(b.ID)
+	if err != nil {
+		return err
+	}
+
+	return writeJSONFile(p+".json", b)
+}
+
+// updateBatch applies fn to the stored batch and saves it
+func updateBatch(id string, fn func(*openai.Batch) error) (*openai.Batch, error) {
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+
+	b, err := readBatch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(b); err != nil {
+		return nil, err
+	}
+
+	if err := writeBatch(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// listBatches returns all batches, oldest first
+func listBatches() ([]openai.Batch, error) {
+	dir, err := batchesDir("batches")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var batches []openai.Batch
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		b, err := readBatch(id)
+		if err != nil {
+			continue
+		}
+
+		batches = append(batches, *b)
+	}
+
+	slices.SortStableFunc(batches, func(i, j openai.Batch) int {
+		if i.CreatedAt != j.CreatedAt {
+			return int(i.CreatedAt - j.CreatedAt)
+		}
+
+		return strings.Compare(i.ID, j.ID)
+	})
+
+	return batches, nil
+}
+
+func unixPtr(t time.Time) *int64 {
+	n := t.Unix()
+	return &n
+}
+
+func handleBatchError(c *gin.Context, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, openai.NewError(http.StatusNotFound, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, openai.NewError(http.StatusInternalServerError, err.Error()))
+}
+
+func (s *Server) UploadFileHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchFileSize)
+	if _, err := c.MultipartForm(); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, openai.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than the maximum of %s", format.HumanBytes(maxBatchFileSize))))
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	purpose := c.PostForm("purpose")
+	if purpose != "batch" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, fmt.Sprintf("invalid purpose %q, only \"batch\" is supported", purpose)))
+		return
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	r, err := fh.Open()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	defer r.Close()
+
+	f, err := createBatchFile(r, fh.Filename, purpose)
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, f)
+}
+
+func (s *Server) ListFilesHandler(c *gin.Context) {
+	files := []openai.File{}
+
+	dir, err := batchesDir("files")
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		f, err := readBatchFile(id)
+		if err != nil {
+			continue
+		}
+
+		if purpose := c.Query("purpose"); purpose != "" && f.Purpose != purpose {
+			continue
+		}
+
+		files = append(files, *f)
+	}
+
+	slices.SortStableFunc(files, func(i, j openai.File) int {
+		// most recently created first
+		return int(j.CreatedAt - i.CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, openai.FileList{Object: "list", Data: files})
+}
+
+func (s *Server) GetFileHandler(c *gin.Context) {
+	f, err := readBatchFile(c.Param("id"))
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, f)
+}
+
+func (s *Server) GetFileContentHandler(c *gin.Context) {
+	f, err := readBatchFile(c.Param("id"))
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	p, err := filePath(f.ID)
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.File(p)
+}
+
+func (s *Server) DeleteFileHandler(c *gin.Context) {
+	id := c.Param("id")
+	p, err := filePath(id)
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	if err := os.Remove(p + ".json"); errors.Is(err, os.ErrNotExist) {
+		handleBatchError(c, fmt.Errorf("file %q %w", id, os.ErrNotExist))
+		return
+	} else if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		handleBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, openai.FileDeleted{ID: id, Object: "file", Deleted: true})
+}
+
+func (s *Server) CreateBatchHandler(c *gin.Context) {
+	var req openai.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	if !slices.Contains(batchEndpoints, req.Endpoint) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, fmt.Sprintf("invalid endpoint %q, must be one of %s", req.Endpoint, strings.Join(batchEndpoints, ", "))))
+		return
+	}
+
+	if req.CompletionWindow != batchCompletionWindow {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, fmt.Sprintf("invalid completion_window %q, only %q is supported", req.CompletionWindow, batchCompletionWindow)))
+		return
+	}
+
+	f, err := readBatchFile(req.InputFileID)
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, fmt.Sprintf("input file %q not found", req.InputFileID)))
+		return
+	} else if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	if f.Purpose != "batch" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, fmt.Sprintf("input file %q must have purpose \"batch\"", f.ID)))
+		return
+	}
+
+	now := time.Now()
+	b := openai.Batch{
+		ID:               newBatchID("batch_"),
+		Object:           "batch",
+		Endpoint:         req.Endpoint,
+		InputFileID:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           batchValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        unixPtr(now.Add(24 * time.Hour)),
+		Metadata:         req.Metadata,
+	}
+
+	batchesMu.Lock()
+	err = writeBatch(&b)
+	batchesMu.Unlock()
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	s.batches.notify()
+	c.JSON(http.StatusOK, b)
+}
+
+func (s *Server) GetBatchHandler(c *gin.Context) {
+	b, err := readBatch(c.Param("id"))
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, b)
+}
+
+func (s *Server) ListBatchesHandler(c *gin.Context) {
+	limit := 20
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 100 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, "limit must be between 1 and 100"))
+			return
+		}
+		limit = n
+	}
+
+	batches, err := listBatches()
+	if err != nil {
+		handleBatchError(c, err)
+		return
+	}
+
+	// most recently created first
+	slices.Reverse(batches)
+
+	if after := c.Query("after"); after != "" {
+		i := slices.IndexFunc(batches, func(b openai.Batch) bool { return b.ID == after })
+		if i < 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, openai.NewError(http.StatusNotFound, fmt.Sprintf("batch %q not found", after)))
+			return
+		}
+
+		batches = batches[i+1:]
+	}
+
+	list := openai.BatchList{Object: "list", Data: []openai.Batch{}}
+	if len(batches) > limit {
+		batches, list.HasMore = batches[:limit], true
+	}
+
+	if len(batches) > 0 {
+		list.Data = batches
+		list.FirstID = &batches[0].ID
+		list.LastID = &batches[len(batches)-1].ID
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Server) CancelBatchHandler(c *gin.Context) {
+	id := c.Param("id")
+	b, err := updateBatch(id, func(b *openai.Batch) error {
+		switch b.Status {
+		case batchValidating, batchInProgress:
+			b.Status = batchCancelling
+			b.CancellingAt = unixPtr(time.Now())
+		case batchCancelling:
+		default:
+			return fmt.Errorf("batch with status %q cannot be cancelled", b.Status)
+		}
+
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		handleBatchError(c, err)
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, openai.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	s.batches.cancel(id)
+	c.JSON(http.StatusOK, b)
+}
+
+// batchRoutes routes batch requests through the same middleware and
+// handlers as the OpenAI compatible endpoints
+func (s *Server) batchRoutes() http.Handler {
+	r := gin.New()
+	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
+	return r
+}
+
+// batchWorker processes batches in the background, one request at a time.
+// Requests are sent with low priority so they don't hold up interactive
+// requests.
+type batchWorker struct {
+	handler http.Handler
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	current string
+	abort   context.CancelFunc
+}
+
+func newBatchWorker(handler http.Handler) *batchWorker {
+	return &batchWorker{handler: handler, wake: make(chan struct{}, 1)}
+}
+
+// notify wakes the worker to pick up new batches
+func (w *batchWorker) notify() {
+	if w == nil {
+		return
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel aborts the request in flight if it's part of the batch
+func (w *batchWorker) cancel(id string) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	if w.current == id && w.abort != nil {
+		w.abort()
+	}
+	w.mu.Unlock()
+	w.notify()
+}
+
+// run processes batches until ctx is done. Batches that were in progress
+// when the server stopped are picked up where they left off.
+func (w *batchWorker) run(ctx context.Context) {
+	for {
+		for {
+			b, err := w.next()
+			if err != nil {
+				slog.Error("failed to list batches", "error", err)
+				break
+			} else if b == nil {
+				break
+			}
+
+			if err := w.process(ctx, b.ID); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				slog.Error("batch failed", "batch", b.ID, "error", err)
+				w.fail(b.ID, openai.BatchError{Code: "internal_error", Message: err.Error()})
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		}
+	}
+}
+
+// next returns the oldest batch that isn't finished
+func (w *batchWorker) next() (*openai.Batch, error) {
+	batches, err := listBatches()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range batches {
+		switch b.Status {
+		case batchValidating, batchInProgress, batchFinalizing, batchCancelling:
+			return &b, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (w *batchWorker) fail(id string, errs ...openai.BatchError) {
+	if _, err := updateBatch(id, func(b *openai.Batch) error {
+		b.Status = batchFailed
+		b.FailedAt = unixPtr(time.Now())
+		b.Errors = &openai.BatchErrors{Object: "list", Data: errs}
+		return nil
+	}); err != nil {
+		slog.Error("failed to update batch", "batch", id, "error", err)
+	}
+}
+
+// forEachLine calls fn with each non-empty line of the file and its line number
+func forEachLine(path string, fn func(n int, line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if err := fn(n, line); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// truncatePartialLine removes the last line of a file if it doesn't end with
+// a newline
+func truncatePartialLine(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// search backwards for the last newline, a chunk at a time since lines
+	// can be long
+	buf := make([]byte, 64*1024)
+	end := info.Size()
+	for off := end; off > 0; {
+		n := min(off, int64(len(buf)))
+		off -= n
+		if _, err := f.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = off + int64(i) + 1
+			break
+		} else if off == 0 {
+			end = 0
+		}
+	}
+
+	if end == info.Size() {
+		return nil
+	}
+
+	slog.Warn("removing partially written line", "path", path, "size", info.Size()-end)
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// validate checks each line of the batch's input file and returns the
+// number of requests
+func (w *batchWorker) validate(b *openai.Batch) (int, []openai.BatchError, error) {
+	p, err := filePath(b.InputFileID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var total int
+	var errs []openai.BatchError
+	ids := make(map[string]bool)
+	if err := forEachLine(p, func(n int, line []byte) error {
+		total++
+
+		var in openai.BatchInput
+		var msg string
+		switch {
+		case json.Unmarshal(line, &in) != nil:
+			msg = "invalid JSON"
+		case in.CustomID == "":
+			msg = "missing custom_id"
+		case ids[in.CustomID]:
+			msg = fmt.Sprintf("duplicate custom_id %q", in.CustomID)
+		case in.Method != http.MethodPost:
+			msg = fmt.Sprintf("invalid method %q, must be POST", in.Method)
+		case in.URL != b.Endpoint:
+			msg = fmt.Sprintf("url %q does not match the batch endpoint %q", in.URL, b.Endpoint)
+		}
+
+		ids[in.CustomID] = true
+		if msg != "" {
+			errs = append(errs, openai.BatchError{Code: "invalid_request", Message: msg, Line: &n})
+		}
+
+		return nil
+	}); errors.Is(err, os.ErrNotExist) {
+		return 0, []openai.BatchError{{Code: "invalid_file", Message: fmt.Sprintf("input file %q not found", b.InputFileID)}}, nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	if total == 0 {
+		errs = append(errs, openai.BatchError{Code: "empty_file", Message: "the input file is empty"})
+	}
+
+	return total, errs, nil
+}
+
+// partialPath returns the path results are appended to while the batch is
+// processed. kind is either "output" or "error".
+func partialPath(id, kind string) (string, error) {
+	p, err := batchPath(id)
+	if err != nil {
+		return "", err
+	}
+
+	return p + "." + kind + ".jsonl", nil
+}
+
+// recount sets the completed and failed requests of a batch from the
+// results written so far. They're ahead of the stored counts if the server
+// stopped after a result was written but before the batch was updated.
+// Counts of results that were already moved to the file store are kept.
+func recount(id string) (*openai.Batch, error) {
+	counts := make(map[string]int)
+	for _, kind := range []string{"output", "error"} {
+		p, err := partialPath(id, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		// a result that was partially written when the server stopped is
+		// dropped so its request is sent again
+		if err := truncatePartialLine(p); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		n, err := countLines(p)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[kind] = n
+	}
+
+	return updateBatch(id, func(b *openai.Batch) error {
+		if n, ok := counts["output"]; ok {
+			b.RequestCounts.Completed = n
+		}
+		if n, ok := counts["error"]; ok {
+			b.RequestCounts.Failed = n
+		}
+		return nil
+	})
+}
+
+func countLines(path string) (int, error) {
+	var count int
+	err := forEachLine(path, func(int, []byte) error {
+		count++
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	return count, err
+}
+
+func (w *batchWorker) process(ctx context.Context, id string) error {
+	b, err := readBatch(id)
+	if err != nil {
+		return err
+	}
+
+	if b.Status == batchValidating {
+		total, errs, err := w.validate(b)
+		if err != nil {
+			return err
+		}
+
+		if len(errs) > 0 {
+			w.fail(id, errs...)
+			return nil
+		}
+
+		b, err = updateBatch(id, func(b *openai.Batch) error {
+			// the batch may have been cancelled while it was validated
+			if b.Status == batchValidating {
+				b.Status = batchInProgress
+				b.InProgressAt = unixPtr(time.Now())
+			}
+			b.RequestCounts.Total = total
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch b.Status {
+	case batchInProgress:
+	case batchCancelling:
+		return w.finalize(id, batchCancelled)
+	case batchFinalizing:
+		return w.finalize(id, batchCompleted)
+	default:
+		return nil
+	}
+
+	outputPath, err := partialPath(id, "output")
+	if err != nil {
+		return err
+	}
+
+	errorPath, err := partialPath(id, "error")
+	if err != nil {
+		return err
+	}
+
+	// results already written before a restart are the source of truth for
+	// how far the batch got
+	b, err = recount(id)
+	if err != nil {
+		return err
+	}
+
+	completed, failed := b.RequestCounts.Completed, b.RequestCounts.Failed
+
+	input, err := filePath(b.InputFileID)
+	if err != nil {
+		return err
+	}
+
+	var status string
+	var skipped int
+	errStop := errors.New("stop")
+	err = forEachLine(input, func(_ int, line []byte) error {
+		if skipped < completed+failed {
+			skipped++
+			return nil
+		}
+
+		b, err := readBatch(id)
+		if err != nil {
+			return err
+		}
+
+		if b.Status == batchCancelling {
+			status = batchCancelled
+			return errStop
+		} else if b.ExpiresAt != nil && time.Now().Unix() > *b.ExpiresAt {
+			status = batchExpired
+			return errStop
+		}
+
+		out, ok := w.do(ctx, id, line)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if !ok {
+			// the request was aborted because the batch was cancelled
+			status = batchCancelled
+			return errStop
+		}
+
+		path := outputPath
+		if out.Error != nil || out.Response.StatusCode != http.StatusOK {
+			path = errorPath
+		}
+
+		if err := appendJSONLine(path, out); err != nil {
+			return err
+		}
+
+		_, err = updateBatch(id, func(b *openai.Batch) error {
+			if path == outputPath {
+				b.RequestCounts.Completed++
+			} else {
+				b.RequestCounts.Failed++
+			}
+			return nil
+		})
+		return err
+	})
+	if errors.Is(err, errStop) {
+		return w.finalize(id, status)
+	} else if err != nil {
+		return err
+	}
+
+	return w.finalize(id, batchCompleted)
+}
+
+func appendJSONLine(path string, v any) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// do sends a line of the batch to its endpoint. It returns false if the
+// request was aborted by cancelling the batch.
+func (w *batchWorker) do(ctx context.Context, id string, line []byte) (openai.BatchOutput, bool) {
+	var in openai.BatchInput
+	if err := json.Unmarshal(line, &in); err != nil {
+		return openai.BatchOutput{ID: newBatchID("batch_req_"), Error: &openai.BatchError{Code: "invalid_request", Message: err.Error()}}, true
+	}
+
+	out := openai.BatchOutput{ID: newBatchID("batch_req_"), CustomID: in.CustomID}
+
+	// responses are collected in full so streaming is always disabled
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(in.Body, &body); err != nil {
+		out.Error = &openai.BatchError{Code: "invalid_request", Message: "body must be a JSON object"}
+		return out, true
+	}
+
+	delete(body, "stream")
+	bts, err := json.Marshal(body)
+	if err != nil {
+		out.Error = &openai.BatchError{Code: "invalid_request", Message: err.Error()}
+		return out, true
+	}
+
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	w.mu.Lock()
+	w.current, w.abort = id, abort
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.current, w.abort = "", nil
+		w.mu.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.URL, bytes.NewReader(bts))
+	if err != nil {
+		out.Error = &openai.BatchError{Code: "invalid_request", Message: err.Error()}
+		return out, true
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ollama-Priority", "low")
+
+	rw := &batchResponseWriter{header: make(http.Header)}
+	w.handler.ServeHTTP(rw, req)
+	if ctx.Err() != nil {
+		return out, false
+	}
+
+	resp := rw.body.Bytes()
+	if !json.Valid(resp) {
+		resp, _ = json.Marshal(rw.body.String())
+	}
+
+	out.Response = &openai.BatchResponse{
+		StatusCode: rw.code,
+		RequestID:  rw.header.Get(requestIDHeader),
+		Body:       resp,
+	}
+
+	return out, true
+}
+
+// finalize moves the results of the batch to the file store and sets its
+// final status
+func (w *batchWorker) finalize(id, status string) error {
+	if _, err := recount(id); err != nil {
+		return err
+	}
+
+	if _, err := updateBatch(id, func(b *openai.Batch) error {
+		if status == batchCompleted {
+			b.Status = batchFinalizing
+			b.FinalizingAt = unixPtr(time.Now())
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// each file is saved on the batch before its partial results are
+	// removed, so finalizing again after the server stops doesn't lose or
+	// duplicate them
+	for _, kind := range []string{"output", "error"} {
+		p, err := partialPath(id, kind)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		b, err := readBatch(id)
+		if err != nil {
+			return err
+		}
+
+		fileID := b.OutputFileID
+		if kind == "error" {
+			fileID = b.ErrorFileID
+		}
+
+		if fileID == nil {
+			if err := saveResults(id, kind, p); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+
+	_, err := updateBatch(id, func(b *openai.Batch) error {
+		now := unixPtr(time.Now())
+		b.Status = status
+		switch status {
+		case batchCompleted:
+			b.CompletedAt = now
+		case batchCancelled:
+			b.CancelledAt = now
+		case batchExpired:
+			b.ExpiredAt = now
+		}
+		return nil
+	})
+	return err
+}
+
+// saveResults creates the output or error file of the batch from its partial
+// results at p and saves the file on the batch
+func saveResults(id, kind, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	file, err := createBatchFile(f, fmt.Sprintf("%s_%s.jsonl", id, kind), "batch_output")
+	if err != nil {
+		return err
+	}
+
+	_, err = updateBatch(id, func(b *openai.Batch) error {
+		if kind == "output" {
+			b.OutputFileID = &file.ID
+		} else {
+			b.ErrorFileID = &file.ID
+		}
+		return nil
+	})
+	return err
+}
+
+// batchResponseWriter records the response to a batch request
+type batchResponseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/openai"
+)
+
+func uploadBatchFile(t *testing.T, router http.Handler, content string) openai.File {
+	t.Helper()
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	if err := mw.WriteField("purpose", "batch"); err != nil {
+		t.Fatal(err)
+	}
+
+	fw, err := mw.CreateFormFile("file", "input.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(fw, content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/files", &b)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var f openai.File
+	if err := json.NewDecoder(w.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func readBatchOutput(t *testing.T, id *string) []openai.BatchOutput {
+	t.Helper()
+
+	if id == nil {
+		return nil
+	}
+
+	p, err := filePath(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs []openai.BatchOutput
+	if err := forEachLine(p, func(_ int, line []byte) error {
+		var out openai.BatchOutput
+		if err := json.Unmarshal(line, &out); err != nil {
+			return err
+		}
+
+		outputs = append(outputs, out)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return outputs
+}
+
+func TestBatches(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_BATCHES", t.TempDir())
+
+	var calls []string
+	var priorities []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model  string `json:"model"`
+			Stream *bool  `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if body.Stream != nil {
+			t.Error("expected stream to be removed")
+		}
+
+		calls = append(calls, r.URL.Path)
+		priorities = append(priorities, r.Header.Get("X-Ollama-Priority"))
+
+		w.Header().Set(requestIDHeader, "req-1")
+		if body.Model != "test" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"message":"model not found"}}`)
+			return
+		}
+
+		io.WriteString(w, `{"object":"chat.completion"}`)
+	})
+
+	s := Server{batches: newBatchWorker(handler)}
+	router := s.GenerateRoutes()
+
+	do := func(method, path, body string, v any) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if v != nil && w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+
+	input := uploadBatchFile(t, router, strings.Join([]string{
+		`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"test","stream":true}}`,
+		``,
+		`{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"missing"}}`,
+		`{"custom_id":"c","method":"POST","url":"/v1/chat/completions","body":{"model":"test"}}`,
+	}, "\n"))
+
+	t.Run("files", func(t *testing.T) {
+		var got openai.File
+		if w := do(http.MethodGet, "/v1/files/"+input.ID, "", &got); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(got, input); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		var list openai.FileList
+		if w := do(http.MethodGet, "/v1/files", "", &list); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(list.Data, []openai.File{input}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		for _, id := range []string{"file-00000000000000000000000000000000", "..%2Fmanifests"} {
+			if w := do(http.MethodGet, "/v1/files/"+id, "", nil); w.Code != http.StatusNotFound {
+				t.Errorf("expected status 404, got %d", w.Code)
+			}
+		}
+	})
+
+	t.Run("invalid batch", func(t *testing.T) {
+		cases := []string{
+			`{"input_file_id":"` + input.ID + `","endpoint":"/api/chat","completion_window":"24h"}`,
+			`{"input_file_id":"` + input.ID + `","endpoint":"/v1/chat/completions","completion_window":"1h"}`,
+			`{"input_file_id":"file-00000000000000000000000000000000","endpoint":"/v1/chat/completions","completion_window":"24h"}`,
+		}
+
+		for _, body := range cases {
+			if w := do(http.MethodPost, "/v1/batches", body, nil); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		}
+	})
+
+	var batch openai.Batch
+	if w := do(http.MethodPost, "/v1/batches", `{"input_file_id":"`+input.ID+`","endpoint":"/v1/chat/completions","completion_window":"24h","metadata":{"job":"test"}}`, &batch); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if batch.Status != batchValidating || batch.ExpiresAt == nil {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	t.Run("process", func(t *testing.T) {
+		if err := s.batches.process(context.Background(), batch.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		var got openai.Batch
+		if w := do(http.MethodGet, "/v1/batches/"+batch.ID, "", &got); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if got.Status != batchCompleted || got.CompletedAt == nil {
+			t.Errorf("expected completed batch, got %+v", got)
+		}
+
+		if diff := cmp.Diff(got.RequestCounts, openai.BatchRequestCounts{Total: 3, Completed: 2, Failed: 1}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(calls, []string{"/v1/chat/completions", "/v1/chat/completions", "/v1/chat/completions"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(priorities, []string{"low", "low", "low"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		var customIDs []string
+		for _, out := range readBatchOutput(t, got.OutputFileID) {
+			customIDs = append(customIDs, out.CustomID)
+			if out.Response.StatusCode != http.StatusOK || out.Response.RequestID != "req-1" {
+				t.Errorf("unexpected response %+v", out.Response)
+			}
+		}
+
+		if diff := cmp.Diff(customIDs, []string{"a", "c"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		errs := readBatchOutput(t, got.ErrorFileID)
+		if len(errs) != 1 || errs[0].CustomID != "b" || errs[0].Response.StatusCode != http.StatusNotFound {
+			t.Errorf("unexpected errors %+v", errs)
+		}
+
+		if w := do(http.MethodPost, "/v1/batches/"+batch.ID+"/cancel", "", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		calls = nil
+
+		var b openai.Batch
+		if w := do(http.MethodPost, "/v1/batches", `{"input_file_id":"`+input.ID+`","endpoint":"/v1/chat/completions","completion_window":"24h"}`, &b); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		// simulate a restart after the first request was written but before
+		// the batch was updated, while the second was being written
+		if _, err := updateBatch(b.ID, func(b *openai.Batch) error {
+			b.Status = batchInProgress
+			b.RequestCounts = openai.BatchRequestCounts{Total: 3}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := partialPath(b.ID, "output")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := appendJSONLine(p, openai.BatchOutput{ID: "batch_req_1", CustomID: "a"}); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.WriteString(`{"id":"batch_req_2","cus`); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.batches.process(context.Background(), b.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(calls) != 2 {
+			t.Errorf("expected 2 requests, got %d", len(calls))
+		}
+
+		got, err := readBatch(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(got.RequestCounts, openai.BatchRequestCounts{Total: 3, Completed: 2, Failed: 1}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected partial output to be removed, got %v", err)
+		}
+	})
+
+	t.Run("resume finalizing", func(t *testing.T) {
+		calls = nil
+
+		var b openai.Batch
+		if w := do(http.MethodPost, "/v1/batches", `{"input_file_id":"`+input.ID+`","endpoint":"/v1/chat/completions","completion_window":"24h"}`, &b); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		// simulate a restart after the output file was saved on the batch
+		// but before its partial results were removed
+		output, err := createBatchFile(strings.NewReader(`{"id":"batch_req_1","custom_id":"a"}`+"\n"), b.ID+"_output.jsonl", "batch_output")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := updateBatch(b.ID, func(b *openai.Batch) error {
+			b.Status = batchFinalizing
+			b.RequestCounts = openai.BatchRequestCounts{Total: 2}
+			b.OutputFileID = &output.ID
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		outputPath, err := partialPath(b.ID, "output")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := appendJSONLine(outputPath, openai.BatchOutput{ID: "batch_req_1", CustomID: "a"}); err != nil {
+			t.Fatal(err)
+		}
+
+		errorPath, err := partialPath(b.ID, "error")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := appendJSONLine(errorPath, openai.BatchOutput{ID: "batch_req_2", CustomID: "b"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.batches.process(context.Background(), b.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readBatch(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Status != batchCompleted || got.OutputFileID == nil || *got.OutputFileID != output.ID || got.ErrorFileID == nil {
+			t.Errorf("expected completed batch with the saved output file and an error file, got %+v", got)
+		}
+
+		if diff := cmp.Diff(got.RequestCounts, openai.BatchRequestCounts{Total: 2, Completed: 1, Failed: 1}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		for _, p := range []string{outputPath, errorPath} {
+			if _, err := os.Stat(p); !os.IsNotExist(err) {
+				t.Errorf("expected partial results to be removed, got %v", err)
+			}
+		}
+
+		if len(calls) != 0 {
+			t.Errorf("expected no requests, got %d", len(calls))
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		calls = nil
+
+		var b openai.Batch
+		if w := do(http.MethodPost, "/v1/batches", `{"input_file_id":"`+input.ID+`","endpoint":"/v1/chat/completions","completion_window":"24h"}`, &b); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if w := do(http.MethodPost, "/v1/batches/"+b.ID+"/cancel", "", &b); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if b.Status != batchCancelling {
+			t.Errorf("expected status cancelling, got %s", b.Status)
+		}
+
+		if err := s.batches.process(context.Background(), b.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readBatch(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Status != batchCancelled || got.CancelledAt == nil || len(calls) != 0 {
+			t.Errorf("expected cancelled batch, got %+v", got)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		bad := uploadBatchFile(t, router, strings.Join([]string{
+			`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{}}`,
+			`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{}}`,
+			`{"custom_id":"b","method":"POST","url":"/v1/embeddings","body":{}}`,
+			`not json`,
+		}, "\n"))
+
+		var b openai.Batch
+		if w := do(http.MethodPost, "/v1/batches", `{"input_file_id":"`+bad.ID+`","endpoint":"/v1/chat/completions","completion_window":"24h"}`, &b); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if err := s.batches.process(context.Background(), b.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readBatch(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Status != batchFailed || got.Errors == nil {
+			t.Fatalf("expected failed batch, got %+v", got)
+		}
+
+		var lines []int
+		for _, e := range got.Errors.Data {
+			lines = append(lines, *e.Line)
+		}
+
+		if diff := cmp.Diff(lines, []int{2, 3, 4}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var list openai.BatchList
+		if w := do(http.MethodGet, "/v1/batches?limit=2", "", &list); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if len(list.Data) != 2 || !list.HasMore {
+			t.Errorf("unexpected list %+v", list)
+		}
+
+		var rest openai.BatchList
+		if w := do(http.MethodGet, "/v1/batches?after="+*list.LastID, "", &rest); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if len(rest.Data) != 3 || rest.HasMore {
+			t.Errorf("unexpected list %+v", rest)
+		}
+
+		if w := do(http.MethodGet, "/v1/batches?after=batch_missing", "", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("upload too large", func(t *testing.T) {
+		size := maxBatchFileSize
+		maxBatchFileSize = 1024
+		t.Cleanup(func() { maxBatchFileSize = size })
+
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		if err := mw.WriteField("purpose", "batch"); err != nil {
+			t.Fatal(err)
+		}
+
+		fw, err := mw.CreateFormFile("file", "input.jsonl")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := fw.Write(make([]byte, maxBatchFileSize)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/files", &b)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status 413, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
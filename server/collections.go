@@ -0,0 +1,759 @@
+package server
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	collectionIndexFlat = "flat"
+	collectionIndexHNSW = "hnsw"
+
+	collectionDefaultTopK = 10
+
+	hnswM              = 16
+	hnswEfConstruction = 200
+	hnswEfSearch       = 64
+)
+
+var collectionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$`)
+
+var (
+	errCollectionExists = errors.New("already exists")
+	errEmbeddingLength  = errors.New("embedding length mismatch")
+)
+
+// collectionsMu guards collections, the collections loaded in memory by
+// directory. Collections are loaded on first use and kept until deleted.
+var (
+	collectionsMu sync.Mutex
+	collections   = make(map[string]*collection)
+)
+
+// collection is a loaded collection. Its documents file is append only:
+// added documents are appended, replacing earlier lines with the same ID,
+// and deleted documents are appended as tombstones. The file is compacted
+// when it is loaded if most of its lines are stale, and the index is
+// rebuilt when most of its nodes are.
+type collection struct {
+	mu   sync.RWMutex
+	dir  string
+	meta api.Collection
+
+	docs []collectionDocument
+	ids  map[string]int
+
+	// lines is the number of lines in the documents file
+	lines int
+
+	// index is built on the first query of an HNSW collection. nodes maps
+	// its node IDs to document IDs.
+	index *hnsw
+	nodes []string
+}
+
+type collectionDocument struct {
+	api.Document
+	embedding []float32
+	node      int
+}
+
+// storedDocument is a line of a collection's documents file. Embeddings
+// are stored as base64, like embeddings requested with that encoding.
+type storedDocument struct {
+	api.Document
+	Embedding string `json:"embedding,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+func collectionDir(name string) (string, error) {
+	if !collectionNamePattern.MatchString(name) {
+		return "", fmt.Errorf("collection %q %w", name, os.ErrNotExist)
+	}
+
+	return filepath.Join(envconfig.Collections(), name), nil
+}
+
+func createCollection(meta api.Collection) (*collection, error) {
+	dir, err := collectionDir(meta.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := os.Mkdir(dir, 0o755); errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("collection %q %w", meta.Name, errCollectionExists)
+	} else if err != nil {
+		return nil, err
+	}
+
+	c := &collection{dir: dir, meta: meta, ids: make(map[string]int)}
+	if err := writeJSONFile(filepath.Join(dir, "collection.json"), meta); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	collections[dir] = c
+	return c, nil
+}
+
+func loadCollection(name string) (*collection, error) {
+	dir, err := collectionDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	if c, ok := collections[dir]; ok {
+		return c, nil
+	}
+
+	c := &collection{dir: dir, ids: make(map[string]int)}
+	if err := readJSONFile(filepath.Join(dir, "collection.json"), &c.meta); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("collection %q %w", name, os.ErrNotExist)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// a line that was partially written when the server stopped was never
+	// added, drop it so later lines are appended after a complete line
+	if err := truncatePartialLine(c.documentsPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := forEachLine(c.documentsPath(), func(n int, line []byte) error {
+		var d storedDocument
+		if err := json.Unmarshal(line, &d); err != nil {
+			return fmt.Errorf("collection %q line %d: %w", name, n, err)
+		}
+
+		c.lines++
+		if d.Deleted {
+			c.remove(d.ID)
+			return nil
+		}
+
+		embedding, err := decodeEmbedding(d.Embedding)
+		if err != nil {
+			return fmt.Errorf("collection %q line %d: %w", name, n, err)
+		}
+
+		// collections created before the embedding length was stored take
+		// it from their first document
+		if c.meta.EmbeddingLength == 0 {
+			c.meta.EmbeddingLength = len(embedding)
+		} else if len(embedding) != c.meta.EmbeddingLength {
+			return fmt.Errorf("collection %q line %d: %w: got %d, want %d", name, n, errEmbeddingLength, len(embedding), c.meta.EmbeddingLength)
+		}
+
+		c.put(collectionDocument{Document: d.Document, embedding: embedding})
+		return nil
+	}); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if c.lines > 2*len(c.docs) {
+		if err := c.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	collections[dir] = c
+	return c, nil
+}
+
+func listCollections() ([]api.Collection, error) {
+	entries, err := os.ReadDir(envconfig.Collections())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	list := []api.Collection{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		var meta api.Collection
+		if err := readJSONFile(filepath.Join(envconfig.Collections(), e.Name(), "collection.json"), &meta); err != nil {
+			// skip directories that aren't collections or were deleted
+			// since the directory was read
+			continue
+		}
+
+		list = append(list, meta)
+	}
+
+	slices.SortFunc(list, func(a, b api.Collection) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return list, nil
+}
+
+func deleteCollection(name string) error {
+	dir, err := collectionDir(name)
+	if err != nil {
+		return err
+	}
+
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, "collection.json")); err != nil {
+		return fmt.Errorf("collection %q %w", name, os.ErrNotExist)
+	}
+
+	delete(collections, dir)
+	return os.RemoveAll(dir)
+}
+
+func (c *collection) documentsPath() string {
+	return filepath.Join(c.dir, "documents.jsonl")
+}
+
+// put adds d to the loaded collection, replacing any document with its ID
+func (c *collection) put(d collectionDocument) {
+	if c.index != nil {
+		d.node = c.index.insert(d.embedding)
+		c.nodes = append(c.nodes, d.ID)
+	}
+
+	if i, ok := c.ids[d.ID]; ok {
+		if c.index != nil {
+			c.index.delete(c.docs[i].node)
+		}
+
+		c.docs[i] = d
+		return
+	}
+
+	c.ids[d.ID] = len(c.docs)
+	c.docs = append(c.docs, d)
+}
+
+// remove deletes a document from the loaded collection
+func (c *collection) remove(id string) {
+	i, ok := c.ids[id]
+	if !ok {
+		return
+	}
+
+	if c.index != nil {
+		c.index.delete(c.docs[i].node)
+	}
+
+	last := len(c.docs) - 1
+	c.docs[i] = c.docs[last]
+	c.ids[c.docs[i].ID] = i
+	c.docs = c.docs[:last]
+	delete(c.ids, id)
+}
+
+// appendLines appends lines to the documents file. The lines are synced to
+// disk before it returns, and removed again if they can't all be written.
+func (c *collection) appendLines(lines []storedDocument) error {
+	f, err := os.OpenFile(c.documentsPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, line := range lines {
+		if err := enc.Encode(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Truncate(info.Size())
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	c.lines += len(lines)
+	return nil
+}
+
+func (c *collection) writeMeta() error {
+	c.meta.Documents = len(c.docs)
+	c.meta.UpdatedAt = time.Now().UTC()
+	return writeJSONFile(filepath.Join(c.dir, "collection.json"), c.meta)
+}
+
+// compact rewrites the documents file with only the current documents
+func (c *collection) compact() error {
+	f, err := os.CreateTemp(c.dir, "documents-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	enc := json.NewEncoder(f)
+	for _, d := range c.docs {
+		if err := enc.Encode(storedDocument{Document: d.Document, Embedding: encodeEmbedding(d.embedding)}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), c.documentsPath()); err != nil {
+		return err
+	}
+
+	c.lines = len(c.docs)
+	return nil
+}
+
+// checkEmbeddings returns errEmbeddingLength if the embeddings can't be
+// compared with the collection's, as when its model was replaced with one of
+// another size
+func (c *collection) checkEmbeddings(embeddings [][]float32) error {
+	for _, e := range embeddings {
+		if c.meta.EmbeddingLength > 0 && len(e) != c.meta.EmbeddingLength {
+			return fmt.Errorf("%w: model %q returned embeddings of length %d but the collection's are %d", errEmbeddingLength, c.meta.Model, len(e), c.meta.EmbeddingLength)
+		}
+	}
+
+	return nil
+}
+
+// maybeDropIndex drops the index if most of its nodes are deleted, it's
+// rebuilt with only the current documents on the next query
+func (c *collection) maybeDropIndex() {
+	if c.index != nil && len(c.nodes) > 2*len(c.docs) {
+		c.index = nil
+		c.nodes = nil
+	}
+}
+
+func (c *collection) add(docs []collectionDocument) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	embeddings := make([][]float32, len(docs))
+	for i, d := range docs {
+		embeddings[i] = d.embedding
+	}
+
+	if err := c.checkEmbeddings(embeddings); err != nil {
+		return err
+	}
+
+	lines := make([]storedDocument, len(docs))
+	for i, d := range docs {
+		lines[i] = storedDocument{Document: d.Document, Embedding: encodeEmbedding(d.embedding)}
+	}
+
+	if err := c.appendLines(lines); err != nil {
+		return err
+	}
+
+	for _, d := range docs {
+		c.put(d)
+	}
+
+	if c.meta.EmbeddingLength == 0 && len(docs) > 0 {
+		c.meta.EmbeddingLength = len(docs[0].embedding)
+	}
+
+	c.maybeDropIndex()
+	return c.writeMeta()
+}
+
+func (c *collection) delete(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.ids[id]; !ok {
+		return fmt.Errorf("document %q %w", id, os.ErrNotExist)
+	}
+
+	if err := c.appendLines([]storedDocument{{Document: api.Document{ID: id}, Deleted: true}}); err != nil {
+		return err
+	}
+
+	c.remove(id)
+	c.maybeDropIndex()
+	return c.writeMeta()
+}
+
+// matchFilter reports whether metadata has each key of filter with an equal
+// value
+func matchFilter(metadata, filter map[string]any) bool {
+	for k, v := range filter {
+		if m, ok := metadata[k]; !ok || !reflect.DeepEqual(m, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// query returns the k documents most similar to embedding that match
+// filter. HNSW collections are searched approximately, falling back to an
+// exact search if too few of the approximate results match the filter.
+func (c *collection) query(embedding []float32, k int, filter map[string]any) []api.QueryResult {
+	c.mu.Lock()
+	if c.meta.Index == collectionIndexHNSW && c.index == nil {
+		c.index = newHNSW(hnswM, hnswEfConstruction)
+		c.nodes = make([]string, 0, len(c.docs))
+		for i := range c.docs {
+			c.docs[i].node = c.index.insert(c.docs[i].embedding)
+			c.nodes = append(c.nodes, c.docs[i].ID)
+		}
+	}
+	c.mu.Unlock()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	results := []api.QueryResult{}
+	if c.index != nil {
+		for _, r := range c.index.search(embedding, k, hnswEfSearch) {
+			d := c.docs[c.ids[c.nodes[r.id]]]
+			if matchFilter(d.Metadata, filter) {
+				results = append(results, api.QueryResult{Document: d.Document, Score: r.score})
+			}
+		}
+
+		if len(results) == min(k, len(c.docs)) {
+			return results
+		}
+	}
+
+	// keep the k most similar documents with the least similar on top
+	top := hnswHeap{min: true}
+	for i, d := range c.docs {
+		if !matchFilter(d.Metadata, filter) {
+			continue
+		}
+
+		r := hnswResult{i, dot(embedding, d.embedding)}
+		if top.Len() < k {
+			heap.Push(&top, r)
+		} else if r.score > top.items[0].score {
+			top.items[0] = r
+			heap.Fix(&top, 0)
+		}
+	}
+
+	slices.SortFunc(top.items, func(a, b hnswResult) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	results = results[:0]
+	for _, r := range top.items {
+		results = append(results, api.QueryResult{Document: c.docs[r.id].Document, Score: r.score})
+	}
+
+	return results
+}
+
+// embedCollection embeds input with the collection's model
+func (s *Server) embedCollection(c *gin.Context, coll *collection, input []string, keepAlive *api.Duration) ([][]float32, error) {
+	_, ctx, done := s.trackRequest(c)
+	defer done()
+
+	r, m, opts, err := s.scheduleRunner(ctx, coll.meta.Model, []Capability{}, nil, keepAlive)
+	if err != nil {
+		handleScheduleError(c, coll.meta.Model, err)
+		return nil, err
+	}
+
+	embeddings, _, err := s.embedInput(ctx, r, m, opts, input, true, coll.meta.Dimensions)
+	if err == nil {
+		coll.mu.RLock()
+		err = coll.checkEmbeddings(embeddings)
+		coll.mu.RUnlock()
+	}
+
+	if errors.Is(err, errDimensionsTooLarge) || errors.Is(err, errEmbeddingLength) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, err
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, err
+	}
+
+	return embeddings, nil
+}
+
+func (s *Server) CreateCollectionHandler(c *gin.Context) {
+	var req api.CreateCollectionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case !collectionNamePattern.MatchString(req.Name):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid collection name %q", req.Name)})
+		return
+	case req.Model == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	case req.Dimensions < 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dimensions must be greater than 0"})
+		return
+	}
+
+	switch req.Index {
+	case "":
+		req.Index = collectionIndexFlat
+	case collectionIndexFlat, collectionIndexHNSW:
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid index %q, must be flat or hnsw", req.Index)})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid model name %q", req.Model)})
+		return
+	}
+
+	if _, err := GetModel(req.Model); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	now := time.Now().UTC()
+	coll, err := createCollection(api.Collection{
+		Name:       req.Name,
+		Model:      req.Model,
+		Index:      req.Index,
+		Dimensions: req.Dimensions,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if errors.Is(err, errCollectionExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coll.meta)
+}
+
+func (s *Server) ListCollectionsHandler(c *gin.Context) {
+	list, err := listCollections()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ListCollectionsResponse{Collections: list})
+}
+
+func (s *Server) GetCollectionHandler(c *gin.Context) {
+	name := c.Param("name")
+	coll, err := loadCollection(name)
+	if err != nil {
+		handleCollectionError(c, name, err)
+		return
+	}
+
+	coll.mu.RLock()
+	defer coll.mu.RUnlock()
+	c.JSON(http.StatusOK, coll.meta)
+}
+
+func (s *Server) DeleteCollectionHandler(c *gin.Context) {
+	name := c.Param("name")
+	if err := deleteCollection(name); err != nil {
+		handleCollectionError(c, name, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) AddDocumentsHandler(c *gin.Context) {
+	var req api.AddDocumentsRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Documents) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "documents are required"})
+		return
+	}
+
+	name := c.Param("name")
+	coll, err := loadCollection(name)
+	if err != nil {
+		handleCollectionError(c, name, err)
+		return
+	}
+
+	ids := make([]string, len(req.Documents))
+	input := make([]string, len(req.Documents))
+	for i, d := range req.Documents {
+		if d.Content == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("document %d has no content", i)})
+			return
+		}
+
+		if d.ID == "" {
+			req.Documents[i].ID = uuid.New().String()
+		}
+
+		ids[i] = req.Documents[i].ID
+		input[i] = d.Content
+	}
+
+	embeddings, err := s.embedCollection(c, coll, input, req.KeepAlive)
+	if err != nil {
+		return
+	}
+
+	docs := make([]collectionDocument, len(req.Documents))
+	for i, d := range req.Documents {
+		docs[i] = collectionDocument{Document: d, embedding: embeddings[i]}
+	}
+
+	if err := coll.add(docs); errors.Is(err, errEmbeddingLength) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.AddDocumentsResponse{IDs: ids})
+}
+
+func (s *Server) DeleteDocumentHandler(c *gin.Context) {
+	name := c.Param("name")
+	coll, err := loadCollection(name)
+	if err != nil {
+		handleCollectionError(c, name, err)
+		return
+	}
+
+	id := c.Param("id")
+	if err := coll.delete(id); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("document %q not found", id)})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) QueryCollectionHandler(c *gin.Context) {
+	var req api.QueryCollectionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Query == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	} else if req.TopK < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_k must be greater than 0"})
+		return
+	} else if req.TopK == 0 {
+		req.TopK = collectionDefaultTopK
+	}
+
+	name := c.Param("name")
+	coll, err := loadCollection(name)
+	if err != nil {
+		handleCollectionError(c, name, err)
+		return
+	}
+
+	embeddings, err := s.embedCollection(c, coll, []string{req.Query}, req.KeepAlive)
+	if err != nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, api.QueryCollectionResponse{Results: coll.query(embeddings[0], req.TopK, req.Filter)})
+}
+
+func handleCollectionError(c *gin.Context, name string, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("collection %q not found", name)})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
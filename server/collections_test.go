@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestCollections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_COLLECTIONS", dir)
+
+	mock := mockRunner{
+		embeddings: map[string][]float32{
+			"cats":          {1, 0, 0, 0},
+			"dogs":          {0, 1, 0, 0},
+			"cats and dogs": {1, 1, 0, 0},
+			"kittens":       {0.9, 0.1, 0, 0},
+			"short":         {1, 0},
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      gpu.GetGPUInfo,
+			getCpuFn:      gpu.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+			"general.architecture": "llama",
+			"llama.context_length": uint32(8192),
+		}, nil)),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	router := s.GenerateRoutes()
+	do := func(method, path string, body any, v any) *httptest.ResponseRecorder {
+		t.Helper()
+		var b strings.Builder
+		if body != nil {
+			if err := json.NewEncoder(&b).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(b.String())))
+		if v != nil && w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+
+	query := func(t *testing.T, name string, req api.QueryCollectionRequest) []string {
+		t.Helper()
+		var resp api.QueryCollectionResponse
+		if w := do(http.MethodPost, "/api/collections/"+name+"/query", req, &resp); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		ids := []string{}
+		for _, r := range resp.Results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	docs := []api.Document{
+		{ID: "cats", Content: "cats", Metadata: map[string]any{"kind": "feline"}},
+		{Content: "dogs", Metadata: map[string]any{"kind": "canine", "legs": 4}},
+		{ID: "both", Content: "cats and dogs"},
+	}
+
+	for _, index := range []string{"flat", "hnsw"} {
+		t.Run(index, func(t *testing.T) {
+			name := "pets-" + index
+
+			var coll api.Collection
+			if w := do(http.MethodPost, "/api/collections", api.CreateCollectionRequest{Name: name, Model: "test", Index: index}, &coll); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if coll.Name != name || coll.Model != "test" || coll.Index != index {
+				t.Fatalf("unexpected collection %+v", coll)
+			}
+
+			var added api.AddDocumentsResponse
+			if w := do(http.MethodPost, "/api/collections/"+name+"/documents", api.AddDocumentsRequest{Documents: docs}, &added); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if len(added.IDs) != 3 || added.IDs[0] != "cats" || added.IDs[1] == "" || added.IDs[2] != "both" {
+				t.Fatalf("unexpected IDs %v", added.IDs)
+			}
+
+			var resp api.QueryCollectionResponse
+			if w := do(http.MethodPost, "/api/collections/"+name+"/query", api.QueryCollectionRequest{Query: "kittens", TopK: 2}, &resp); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if diff := cmp.Diff(resp.Results, []api.QueryResult{
+				{Document: docs[0], Score: float32(0.9 / math.Hypot(0.9, 0.1))},
+				{Document: docs[2], Score: float32(1 / (math.Hypot(0.9, 0.1) * math.Sqrt2))},
+			}, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(query(t, name, api.QueryCollectionRequest{Query: "kittens", Filter: map[string]any{"kind": "canine", "legs": 4}}), []string{added.IDs[1]}); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+
+			if w := do(http.MethodDelete, "/api/collections/"+name+"/documents/cats", nil, nil); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+
+			if w := do(http.MethodDelete, "/api/collections/"+name+"/documents/cats", nil, nil); w.Code != http.StatusNotFound {
+				t.Fatalf("expected status 404, got %d", w.Code)
+			}
+
+			// replace a document
+			if w := do(http.MethodPost, "/api/collections/"+name+"/documents", api.AddDocumentsRequest{Documents: []api.Document{{ID: "both", Content: "kittens"}}}, nil); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(query(t, name, api.QueryCollectionRequest{Query: "cats"}), []string{"both", added.IDs[1]}); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+
+			// reload the collection from disk
+			collectionsMu.Lock()
+			clear(collections)
+			collectionsMu.Unlock()
+
+			if diff := cmp.Diff(query(t, name, api.QueryCollectionRequest{Query: "cats"}), []string{"both", added.IDs[1]}); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+
+			if w := do(http.MethodGet, "/api/collections/"+name, nil, &coll); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+
+			if coll.Documents != 2 || coll.EmbeddingLength != 4 {
+				t.Errorf("expected 2 documents of length 4, got %d of length %d", coll.Documents, coll.EmbeddingLength)
+			}
+		})
+	}
+
+	t.Run("embedding length", func(t *testing.T) {
+		// as if the collection's model was replaced with one of another size
+		if w := do(http.MethodPost, "/api/collections/pets-flat/query", api.QueryCollectionRequest{Query: "short"}, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if w := do(http.MethodPost, "/api/collections/pets-hnsw/documents", api.AddDocumentsRequest{Documents: []api.Document{{Content: "short"}}}, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("partial line", func(t *testing.T) {
+		f, err := os.OpenFile(filepath.Join(dir, "pets-flat", "documents.jsonl"), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a line cut short when the server stopped
+		if _, err := f.WriteString(`{"id":"torn","content":"ca`); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		collectionsMu.Lock()
+		clear(collections)
+		collectionsMu.Unlock()
+
+		if diff := cmp.Diff(query(t, "pets-flat", api.QueryCollectionRequest{Query: "cats", TopK: 1}), []string{"both"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		// documents added after it are kept
+		if w := do(http.MethodPost, "/api/collections/pets-flat/documents", api.AddDocumentsRequest{Documents: []api.Document{{ID: "cats", Content: "cats"}}}, nil); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		collectionsMu.Lock()
+		clear(collections)
+		collectionsMu.Unlock()
+
+		if diff := cmp.Diff(query(t, "pets-flat", api.QueryCollectionRequest{Query: "cats", TopK: 1}), []string{"cats"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var resp api.ListCollectionsResponse
+		if w := do(http.MethodGet, "/api/collections", nil, &resp); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var names []string
+		for _, c := range resp.Collections {
+			names = append(names, c.Name)
+		}
+
+		if diff := cmp.Diff(names, []string{"pets-flat", "pets-hnsw"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("create errors", func(t *testing.T) {
+		cases := []struct {
+			req  api.CreateCollectionRequest
+			code int
+			err  string
+		}{
+			{api.CreateCollectionRequest{Name: "../pets", Model: "test"}, http.StatusBadRequest, `{"error":"invalid collection name \"../pets\""}`},
+			{api.CreateCollectionRequest{Name: "pets"}, http.StatusBadRequest, `{"error":"model is required"}`},
+			{api.CreateCollectionRequest{Name: "pets", Model: "test", Index: "ivf"}, http.StatusBadRequest, `{"error":"invalid index \"ivf\", must be flat or hnsw"}`},
+			{api.CreateCollectionRequest{Name: "pets", Model: "missing"}, http.StatusNotFound, `{"error":"model 'missing' not found"}`},
+			{api.CreateCollectionRequest{Name: "pets-flat", Model: "test"}, http.StatusConflict, `{"error":"collection \"pets-flat\" already exists"}`},
+		}
+
+		for _, tt := range cases {
+			w := do(http.MethodPost, "/api/collections", tt.req, nil)
+			if w.Code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), tt.err); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("query errors", func(t *testing.T) {
+		if w := do(http.MethodPost, "/api/collections/pets-flat/query", api.QueryCollectionRequest{}, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if w := do(http.MethodPost, "/api/collections/missing/query", api.QueryCollectionRequest{Query: "cats"}, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := do(http.MethodDelete, "/api/collections/pets-flat", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if w := do(http.MethodGet, "/api/collections/pets-flat", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+
+		if w := do(http.MethodDelete, "/api/collections/pets-flat", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
+
+func TestCollectionIndexRebuild(t *testing.T) {
+	t.Setenv("OLLAMA_COLLECTIONS", t.TempDir())
+
+	c, err := createCollection(api.Collection{Name: "pets", Model: "test", Index: collectionIndexHNSW})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(id string, embedding []float32) {
+		t.Helper()
+		if err := c.add([]collectionDocument{{Document: api.Document{ID: id, Content: id}, embedding: embedding}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add("cats", []float32{1, 0})
+	add("dogs", []float32{0, 1})
+	c.query([]float32{1, 0}, 1, nil)
+
+	// replacing a document leaves its old node deleted in the index
+	for range 10 {
+		add("cats", []float32{1, 0})
+		if len(c.nodes) > 2*len(c.docs) {
+			t.Fatalf("expected at most %d nodes, got %d", 2*len(c.docs), len(c.nodes))
+		}
+	}
+
+	var ids []string
+	for _, r := range c.query([]float32{1, 0}, 2, nil) {
+		ids = append(ids, r.ID)
+	}
+
+	if diff := cmp.Diff(ids, []string{"cats", "dogs"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if len(c.nodes) != 2 {
+		t.Errorf("expected the index to be rebuilt with 2 nodes, got %d", len(c.nodes))
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// conversationsMu serializes changes to stored conversations so messages
+// appended by concurrent chat requests aren't lost
+var conversationsMu sync.Mutex
+
+func conversationPath(id string) (string, error) {
+	// IDs are generated by the server, anything else can't exist
+	if _, err := uuid.Parse(id); err != nil {
+		return "", fmt.Errorf("conversation %q %w", id, os.ErrNotExist)
+	}
+
+	dir := envconfig.Conversations()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, id+".json"), nil
+}
+
+func readConversation(id string) (*api.Conversation, error) {
+	p, err := conversationPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("conversation %q %w", id, os.ErrNotExist)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var conv api.Conversation
+	if err := json.Unmarshal(bts, &conv); err != nil {
+		return nil, err
+	}
+
+	return &conv, nil
+}
+
+// writeConversation replaces the stored conversation. The file is written
+// to a temporary file first so readers never see a partial conversation.
+func writeConversation(conv *api.Conversation) error {
+	p, err := conversationPath(conv.ID)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "conversation-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(conv); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+func createConversation(model, parentID string, msgs []api.Message) (*api.Conversation, error) {
+	now := time.Now().UTC()
+	conv := api.Conversation{
+		ID:        uuid.New().String(),
+		Model:     model,
+		Messages:  msgs,
+		ParentID:  parentID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+	if err := writeConversation(&conv); err != nil {
+		return nil, err
+	}
+
+	return &conv, nil
+}
+
+// conversationTurns holds the IDs of the conversations with a turn in progress
+var conversationTurns sync.Map
+
+// startTurn starts a turn of the conversation, unless one is already in
+// progress. Turns of a conversation don't overlap so each is prompted with
+// the history of those before it. The returned function ends the turn and
+// may be called more than once.
+func startTurn(id string) (func(), bool) {
+	if _, busy := conversationTurns.LoadOrStore(id, struct{}{}); busy {
+		return nil, false
+	}
+
+	return sync.OnceFunc(func() { conversationTurns.Delete(id) }), true
+}
+
+// appendConversation adds messages to the end of a stored conversation
+func appendConversation(id string, msgs ...api.Message) error {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+
+	conv, err := readConversation(id)
+	if err != nil {
+		return err
+	}
+
+	conv.Messages = append(conv.Messages, msgs...)
+	conv.UpdatedAt = time.Now().UTC()
+	return writeConversation(conv)
+}
+
+func (s *Server) CreateConversationHandler(c *gin.Context) {
+	var req api.CreateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv, err := createConversation(req.Model, "", req.Messages)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
+func (s *Server) ListConversationsHandler(c *gin.Context) {
+	conversations := []api.Conversation{}
+
+	dir := envconfig.Conversations()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		conv, err := readConversation(id)
+		if err != nil {
+			// skip files that aren't conversations or were deleted since
+			// the directory was read
+			continue
+		}
+
+		conv.Messages = nil
+		conversations = append(conversations, *conv)
+	}
+
+	slices.SortStableFunc(conversations, func(i, j api.Conversation) int {
+		// most recently updated first
+		return j.UpdatedAt.Compare(i.UpdatedAt)
+	})
+
+	c.JSON(http.StatusOK, api.ListConversationsResponse{Conversations: conversations})
+}
+
+func (s *Server) GetConversationHandler(c *gin.Context) {
+	conv, err := readConversation(c.Param("id"))
+	if err != nil {
+		handleConversationError(c, c.Param("id"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
+func (s *Server) DeleteConversationHandler(c *gin.Context) {
+	id := c.Param("id")
+	p, err := conversationPath(id)
+	if err != nil {
+		handleConversationError(c, id, err)
+		return
+	}
+
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+	if err := os.Remove(p); err != nil {
+		handleConversationError(c, id, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) ForkConversationHandler(c *gin.Context) {
+	var req api.ForkConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	parent, err := readConversation(id)
+	if err != nil {
+		handleConversationError(c, id, err)
+		return
+	}
+
+	msgs := parent.Messages
+	if req.MessageCount < 0 || req.MessageCount > len(msgs) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("message_count must be between 0 and %d", len(msgs))})
+		return
+	} else if req.MessageCount > 0 {
+		msgs = msgs[:req.MessageCount]
+	}
+
+	conv, err := createConversation(parent.Model, parent.ID, msgs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
+func handleConversationError(c *gin.Context, id string, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("conversation %q not found", id)})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
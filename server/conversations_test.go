@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestConversations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_CONVERSATIONS", dir)
+
+	var s Server
+	router := s.GenerateRoutes()
+
+	do := func(method, path, body string, v any) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if v != nil && w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+
+	var conv api.Conversation
+	if w := do(http.MethodPost, "/api/conversations", `{"model":"test","messages":[{"role":"user","content":"Hello!"},{"role":"assistant","content":"Hi!"}]}`, &conv); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, conv.ID+".json")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		var got api.Conversation
+		if w := do(http.MethodGet, "/api/conversations/"+conv.ID, "", &got); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(got, conv); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		for _, id := range []string{"00000000-0000-0000-0000-000000000000", "..%2Fmanifests"} {
+			w := do(http.MethodGet, "/api/conversations/"+id, "", nil)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected status 404, got %d", w.Code)
+			}
+		}
+	})
+
+	var fork api.Conversation
+	t.Run("fork", func(t *testing.T) {
+		if w := do(http.MethodPost, "/api/conversations/"+conv.ID+"/fork", `{"message_count":1}`, &fork); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if fork.ID == conv.ID || fork.ParentID != conv.ID || fork.Model != "test" {
+			t.Errorf("unexpected fork %+v", fork)
+		}
+
+		if diff := cmp.Diff(fork.Messages, []api.Message{{Role: "user", Content: "Hello!"}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		w := do(http.MethodPost, "/api/conversations/"+conv.ID+"/fork", `{"message_count":3}`, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		if err := appendConversation(conv.ID, api.Message{Role: "user", Content: "How are you?"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp api.ListConversationsResponse
+		if w := do(http.MethodGet, "/api/conversations", "", &resp); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		// the original was updated after the fork so it's listed first
+		if diff := cmp.Diff(resp.Conversations, []api.Conversation{
+			{ID: conv.ID, Model: "test"},
+			{ID: fork.ID, Model: "test", ParentID: conv.ID},
+		}, cmpopts.IgnoreFields(api.Conversation{}, "CreatedAt", "UpdatedAt")); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := do(http.MethodDelete, "/api/conversations/"+conv.ID, "", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if w := do(http.MethodGet, "/api/conversations/"+conv.ID, "", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+
+		if w := do(http.MethodDelete, "/api/conversations/"+conv.ID, "", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
@@ -44,6 +44,9 @@ type blobDownload struct {
 
 	Parts []*blobDownloadPart
 
+	// Mirror is the host of the mirror the blob is downloaded from, if any
+	Mirror string
+
 	context.CancelFunc
 
 	done       chan struct{}
@@ -256,16 +259,30 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 				continue
 			}
 			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusTemporaryRedirect {
+			switch resp.StatusCode {
+			case http.StatusTemporaryRedirect:
+				return resp.Location()
+			case http.StatusOK:
+				// registries that serve blobs themselves, like other
+				// Ollama servers, don't redirect
+				return resp.Request.URL, nil
+			default:
 				return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 			}
-			return resp.Location()
 		}
 	}()
 	if err != nil {
 		return err
 	}
 
+	// registries that serve blobs themselves, like mirrors, need the same
+	// credentials as the other requests. Redirects to other hosts are
+	// presigned.
+	var chunkOpts *registryOptions
+	if directURL.Host == requestURL.Host {
+		chunkOpts = opts
+	}
+
 	g, inner := errgroup.WithContext(ctx)
 	g.SetLimit(numDownloadParts)
 	for i := range b.Parts {
@@ -278,7 +295,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 			var err error
 			for try := 0; try < maxRetries; try++ {
 				w := io.NewOffsetWriter(file, part.StartsAt())
-				err = b.downloadChunk(inner, directURL, w, part)
+				err = b.downloadChunk(inner, directURL, chunkOpts, w, part)
 				switch {
 				case errors.Is(err, context.Canceled), errors.Is(err, syscall.ENOSPC):
 					// return immediately if the context is canceled or the device is out of space
@@ -322,7 +339,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 	return nil
 }
 
-func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w io.Writer, part *blobDownloadPart) error {
+func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, opts *registryOptions, w io.Writer, part *blobDownloadPart) error {
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
@@ -330,12 +347,24 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 			return err
 		}
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.StartsAt(), part.StopsAt()-1))
+		if opts != nil {
+			if opts.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+opts.Token)
+			} else if opts.Username != "" && opts.Password != "" {
+				req.SetBasicAuth(opts.Username, opts.Password)
+			}
+		}
+
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+
 		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
 		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			// rollback progress
@@ -433,18 +462,34 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 	b.acquire()
 	defer b.release()
 
+	status := fmt.Sprintf("pulling %s", b.Digest[7:19])
+	if b.Mirror != "" {
+		status = fmt.Sprintf("pulling %s from %s", b.Digest[7:19], b.Mirror)
+	}
+
+	progress := func() {
+		fn(api.ProgressResponse{
+			Status:    status,
+			Digest:    b.Digest,
+			Total:     b.Total,
+			Completed: b.Completed.Load(),
+			Mirror:    b.Mirror,
+		})
+	}
+
 	ticker := time.NewTicker(60 * time.Millisecond)
 	for {
 		select {
 		case <-b.done:
+			if b.err == nil {
+				// blobs that download between ticks still report which
+				// mirror they came from
+				progress()
+			}
+
 			return b.err
 		case <-ticker.C:
-			fn(api.ProgressResponse{
-				Status:    fmt.Sprintf("pulling %s", b.Digest[7:19]),
-				Digest:    b.Digest,
-				Total:     b.Total,
-				Completed: b.Completed.Load(),
-			})
+			progress()
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -454,7 +499,7 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 type downloadOpts struct {
 	mp      ModelPath
 	digest  string
-	regOpts *registryOptions
+	sources []pullSource
 	fn      func(api.ProgressResponse)
 }
 
@@ -484,16 +529,48 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
 	download := data.(*blobDownload)
 	if !ok {
-		requestURL := opts.mp.BaseURL()
-		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
-		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
+		source, err := findBlobSource(ctx, opts)
+		if err != nil {
+			blobDownloadManager.Delete(opts.digest)
+			return false, err
+		}
+
+		download.Mirror = source.mirror
+
+		requestURL := source.url(opts.mp, "blobs", opts.digest)
+		if err := download.Prepare(ctx, requestURL, source.regOpts); err != nil {
 			blobDownloadManager.Delete(opts.digest)
 			return false, err
 		}
 
 		//nolint:contextcheck
-		go download.Run(context.Background(), requestURL, opts.regOpts)
+		go download.Run(context.Background(), requestURL, source.regOpts)
 	}
 
 	return false, download.Wait(ctx, opts.fn)
 }
+
+// findBlobSource returns the first of the sources that has the blob. If there
+// is only one source it's returned without checking.
+func findBlobSource(ctx context.Context, opts downloadOpts) (source pullSource, err error) {
+	if len(opts.sources) == 1 {
+		return opts.sources[0], nil
+	}
+
+	for _, source = range opts.sources {
+		var resp *http.Response
+		resp, err = makeRequestWithRetry(ctx, http.MethodHead, source.url(opts.mp, "blobs", opts.digest), nil, nil, source.regOpts)
+		if err == nil {
+			resp.Body.Close()
+			return source, nil
+		} else if ctx.Err() != nil {
+			return source, err
+		}
+
+		if source.mirror != "" {
+			slog.Warn("couldn't pull blob from mirror", "mirror", source.mirror, "digest", opts.digest, "error", err)
+		}
+	}
+
+	return source, err
+}
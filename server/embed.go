@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// embedBatcher coalesces the inputs of concurrent embedding requests to the
+// same runner into batches. Up to one batch per parallel slot of the runner
+// is in flight, and the next batch is sent as soon as one finishes, so
+// inputs that arrive while the runner is busy are embedded together instead
+// of each paying for a round trip to the runner.
+type embedBatcher struct {
+	r llm.LlamaServer
+
+	// maxTokens limits the number of tokens in a batch. An input with more
+	// tokens is sent in a batch of its own.
+	maxTokens int
+
+	// parallel is the number of batches that may be in flight at once
+	parallel int
+
+	// idle is called when the batcher has no more inputs
+	idle func()
+
+	mu      sync.Mutex
+	pending []*embedItem
+	running int
+}
+
+type embedItem struct {
+	ctx    context.Context
+	input  string
+	tokens int
+
+	embedding []float32
+	err       error
+	done      chan struct{}
+}
+
+// embedBatcher returns the batcher for runner r. Batchers are removed once
+// they have no more inputs.
+func (s *Server) embedBatcher(r llm.LlamaServer, opts *api.Options) *embedBatcher {
+	b := &embedBatcher{r: r, maxTokens: opts.NumBatch, parallel: max(r.NumParallel(), 1)}
+	b.idle = func() { s.embedBatchers.CompareAndDelete(r, b) }
+
+	v, _ := s.embedBatchers.LoadOrStore(r, b)
+	return v.(*embedBatcher)
+}
+
+// embed returns the embeddings of inputs, which have the given number of
+// tokens
+func (b *embedBatcher) embed(ctx context.Context, inputs []string, tokens []int) ([][]float32, error) {
+	items := make([]*embedItem, len(inputs))
+	for i := range inputs {
+		items[i] = &embedItem{ctx: ctx, input: inputs[i], tokens: tokens[i], done: make(chan struct{})}
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, items...)
+
+	// each run goroutine sends one batch at a time, start enough of them
+	// for the new inputs to use the free slots
+	for range min(len(items), b.parallel-b.running) {
+		b.running++
+		go b.run()
+	}
+	b.mu.Unlock()
+
+	embeddings := make([][]float32, len(items))
+	for i, item := range items {
+		select {
+		case <-item.done:
+			if item.err != nil {
+				return nil, item.err
+			}
+
+			embeddings[i] = item.embedding
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return embeddings, nil
+}
+
+func (b *embedBatcher) run() {
+	for {
+		b.mu.Lock()
+		batch := b.next()
+		if len(batch) == 0 {
+			b.running--
+			if b.running == 0 && b.idle != nil {
+				b.idle()
+			}
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		b.do(batch)
+	}
+}
+
+// next removes the next batch of inputs from the queue, skipping inputs of
+// cancelled requests
+func (b *embedBatcher) next() []*embedItem {
+	var batch []*embedItem
+	var tokens int
+	for len(b.pending) > 0 {
+		item := b.pending[0]
+		if err := item.ctx.Err(); err != nil {
+			item.err = err
+			close(item.done)
+			b.pending = b.pending[1:]
+			continue
+		}
+
+		if len(batch) > 0 && tokens+item.tokens > b.maxTokens {
+			break
+		}
+
+		batch = append(batch, item)
+		tokens += item.tokens
+		b.pending = b.pending[1:]
+	}
+
+	return batch
+}
+
+func (b *embedBatcher) do(batch []*embedItem) {
+	// the batch is shared by several requests, so it isn't cancelled with
+	// any one of them and it's sent with the highest of their priorities
+	priority := llm.PriorityFromContext(batch[0].ctx)
+	for _, item := range batch[1:] {
+		priority = max(priority, llm.PriorityFromContext(item.ctx))
+	}
+
+	ctx := llm.WithPriority(context.WithoutCancel(batch[0].ctx), priority)
+
+	inputs := make([]string, len(batch))
+	for i, item := range batch {
+		inputs[i] = item.input
+	}
+
+	embeddings, err := b.r.EmbeddingBatch(ctx, inputs)
+	if err != nil && len(batch) > 1 {
+		// embed the inputs one at a time so an input the runner can't embed
+		// only fails its own request
+		for _, item := range batch {
+			e, err := b.r.EmbeddingBatch(ctx, []string{item.input})
+			if err == nil {
+				item.embedding = e[0]
+			}
+
+			item.err = err
+			close(item.done)
+		}
+
+		return
+	}
+
+	for i, item := range batch {
+		if err == nil {
+			item.embedding = embeddings[i]
+		}
+
+		item.err = err
+		close(item.done)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// batchRunner records the batches it embeds and their priorities. Each
+// input's embedding is its length. Batches wait for release if it is set.
+type batchRunner struct {
+	llm.LlamaServer
+
+	parallel int
+
+	mu         sync.Mutex
+	batches    [][]string
+	priorities []llm.Priority
+	started    chan struct{}
+	release    chan struct{}
+}
+
+func (r *batchRunner) NumParallel() int {
+	return max(r.parallel, 1)
+}
+
+func (r *batchRunner) EmbeddingBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, inputs)
+	r.priorities = append(r.priorities, llm.PriorityFromContext(ctx))
+	r.mu.Unlock()
+
+	if r.started != nil {
+		r.started <- struct{}{}
+	}
+
+	if r.release != nil {
+		<-r.release
+	}
+
+	if slices.Contains(inputs, "bad") {
+		return nil, errors.New("bad input")
+	}
+
+	embeddings := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		embeddings[i] = []float32{float32(len(input))}
+	}
+
+	return embeddings, nil
+}
+
+func TestEmbedBatcher(t *testing.T) {
+	opts := api.DefaultOptions()
+	opts.NumBatch = 10
+
+	t.Run("concurrent requests", func(t *testing.T) {
+		var s Server
+		r := &batchRunner{started: make(chan struct{}), release: make(chan struct{})}
+
+		embed := func(inputs ...string) <-chan [][]float32 {
+			ch := make(chan [][]float32, 1)
+			go func() {
+				embeddings, err := s.embedBatcher(r, &opts).embed(context.Background(), inputs, make([]int, len(inputs)))
+				if err != nil {
+					t.Error(err)
+				}
+				ch <- embeddings
+			}()
+			return ch
+		}
+
+		first := embed("a")
+		<-r.started
+
+		// requests made while the runner is busy are batched together
+		second := embed("bb", "ccc")
+		third := embed("dddd")
+
+		b := s.embedBatcher(r, &opts)
+		for {
+			b.mu.Lock()
+			n := len(b.pending)
+			b.mu.Unlock()
+			if n == 3 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		r.release <- struct{}{}
+		<-r.started
+		r.release <- struct{}{}
+
+		if diff := cmp.Diff(<-first, [][]float32{{1}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(<-second, [][]float32{{2}, {3}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(<-third, [][]float32{{4}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if len(r.batches) != 2 || len(r.batches[1]) != 3 {
+			t.Errorf("expected batches of 1 and 3 inputs, got %v", r.batches)
+		}
+	})
+
+	t.Run("parallel batches", func(t *testing.T) {
+		var s Server
+		r := &batchRunner{parallel: 2, started: make(chan struct{}), release: make(chan struct{})}
+
+		var wg sync.WaitGroup
+		for _, input := range []string{"a", "bb"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.embedBatcher(r, &opts).embed(context.Background(), []string{input}, []int{1}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+
+		// both batches are sent before either finishes
+		<-r.started
+		<-r.started
+		r.release <- struct{}{}
+		r.release <- struct{}{}
+		wg.Wait()
+
+		if len(r.batches) != 2 {
+			t.Errorf("expected 2 batches, got %v", r.batches)
+		}
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		var s Server
+		r := &batchRunner{}
+
+		b := s.embedBatcher(r, &opts)
+		low := &embedItem{ctx: llm.WithPriority(context.Background(), llm.PriorityLow), input: "low", done: make(chan struct{})}
+		high := &embedItem{ctx: llm.WithPriority(context.Background(), llm.PriorityHigh), input: "high", done: make(chan struct{})}
+		b.do([]*embedItem{low, high})
+
+		<-low.done
+		<-high.done
+
+		// the batch is sent with the highest priority of its inputs
+		if diff := cmp.Diff(r.priorities, []llm.Priority{llm.PriorityHigh}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("max tokens", func(t *testing.T) {
+		var s Server
+		r := &batchRunner{}
+
+		embeddings, err := s.embedBatcher(r, &opts).embed(context.Background(), []string{"a", "bb", "ccc", "dddd"}, []int{6, 4, 12, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(embeddings, [][]float32{{1}, {2}, {3}, {4}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(r.batches, [][]string{{"a", "bb"}, {"ccc"}, {"dddd"}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("failed input", func(t *testing.T) {
+		var s Server
+		r := &batchRunner{}
+
+		b := s.embedBatcher(r, &opts)
+		good := &embedItem{ctx: context.Background(), input: "good", done: make(chan struct{})}
+		bad := &embedItem{ctx: context.Background(), input: "bad", done: make(chan struct{})}
+		b.do([]*embedItem{good, bad})
+
+		<-good.done
+		<-bad.done
+		if good.err != nil || bad.err == nil {
+			t.Errorf("expected only the bad input to fail, got %v and %v", good.err, bad.err)
+		}
+
+		if diff := cmp.Diff(good.embedding, []float32{4}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("cancelled request", func(t *testing.T) {
+		var s Server
+		r := &batchRunner{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := s.embedBatcher(r, &opts).embed(ctx, []string{"a"}, []int{1}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context canceled, got %v", err)
+		}
+
+		// wait for the batcher to skip the input
+		for {
+			if _, ok := s.embedBatchers.Load(r); !ok {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if len(r.batches) != 0 {
+			t.Errorf("expected no batches, got %v", r.batches)
+		}
+	})
+}
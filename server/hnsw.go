@@ -0,0 +1,233 @@
+package server
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// hnsw is a hierarchical navigable small world graph for approximate nearest
+// neighbor search of normalized vectors. Nodes are never removed from the
+// graph; deleted nodes are still traversed but never returned.
+//
+// See https://arxiv.org/abs/1603.09320
+type hnsw struct {
+	// m is the number of neighbors of each node on the upper layers, and
+	// half the number on the bottom layer
+	m              int
+	efConstruction int
+	ml             float64
+	rng            *rand.Rand
+
+	nodes    []hnswNode
+	entry    int
+	maxLevel int
+}
+
+type hnswNode struct {
+	vec []float32
+
+	// neighbors holds the node's neighbors on each of its layers
+	neighbors [][]int
+	deleted   bool
+}
+
+// hnswResult is a node and its similarity to the query
+type hnswResult struct {
+	id    int
+	score float32
+}
+
+func newHNSW(m, efConstruction int) *hnsw {
+	return &hnsw{
+		m:              m,
+		efConstruction: efConstruction,
+		ml:             1 / math.Log(float64(m)),
+		// a fixed seed keeps the graph the same for the same inserts
+		rng:   rand.New(rand.NewSource(1)),
+		entry: -1,
+	}
+}
+
+// dot returns the dot product of a and b, which must be the same length
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
+
+// insert adds vec to the graph and returns its node ID
+func (h *hnsw) insert(vec []float32) int {
+	level := int(-math.Log(1-h.rng.Float64()) * h.ml)
+
+	id := len(h.nodes)
+	h.nodes = append(h.nodes, hnswNode{vec: vec, neighbors: make([][]int, level+1)})
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return id
+	}
+
+	entry := []int{h.entry}
+	for l := h.maxLevel; l > level; l-- {
+		entry = []int{h.searchLayer(vec, entry, 1, l)[0].id}
+	}
+
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(vec, entry, h.efConstruction, l)
+
+		neighbors := make([]int, 0, h.m)
+		for _, c := range candidates[:min(h.m, len(candidates))] {
+			neighbors = append(neighbors, c.id)
+		}
+
+		h.nodes[id].neighbors[l] = neighbors
+		for _, n := range neighbors {
+			h.connect(n, id, l)
+		}
+
+		entry = entry[:0]
+		for _, c := range candidates {
+			entry = append(entry, c.id)
+		}
+	}
+
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+
+	return id
+}
+
+// connect adds id to the neighbors of node n on layer l, dropping its least
+// similar neighbor if it has too many
+func (h *hnsw) connect(n, id, l int) {
+	maxNeighbors := h.m
+	if l == 0 {
+		maxNeighbors = 2 * h.m
+	}
+
+	node := &h.nodes[n]
+	node.neighbors[l] = append(node.neighbors[l], id)
+	if len(node.neighbors[l]) <= maxNeighbors {
+		return
+	}
+
+	slices.SortFunc(node.neighbors[l], func(a, b int) int {
+		sa, sb := dot(node.vec, h.nodes[a].vec), dot(node.vec, h.nodes[b].vec)
+		switch {
+		case sa > sb:
+			return -1
+		case sa < sb:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	node.neighbors[l] = node.neighbors[l][:maxNeighbors]
+}
+
+// delete excludes a node from search results
+func (h *hnsw) delete(id int) {
+	h.nodes[id].deleted = true
+}
+
+// search returns up to k nodes most similar to q, most similar first. ef is
+// the size of the candidate list; larger values are slower but more
+// accurate.
+func (h *hnsw) search(q []float32, k, ef int) []hnswResult {
+	if h.entry < 0 {
+		return nil
+	}
+
+	entry := []int{h.entry}
+	for l := h.maxLevel; l > 0; l-- {
+		entry = []int{h.searchLayer(q, entry, 1, l)[0].id}
+	}
+
+	var results []hnswResult
+	for _, r := range h.searchLayer(q, entry, max(ef, k), 0) {
+		if !h.nodes[r.id].deleted {
+			results = append(results, r)
+		}
+	}
+
+	return results[:min(k, len(results))]
+}
+
+// searchLayer returns the ef nodes on layer l most similar to q that were
+// found starting from entry, most similar first
+func (h *hnsw) searchLayer(q []float32, entry []int, ef, l int) []hnswResult {
+	visited := make(map[int]bool, ef*h.m)
+
+	// candidates are explored most similar first, while results keeps the
+	// least similar on top so it can be replaced
+	var candidates, results hnswHeap
+	results.min = true
+	for _, id := range entry {
+		visited[id] = true
+		r := hnswResult{id, dot(q, h.nodes[id].vec)}
+		heap.Push(&candidates, r)
+		heap.Push(&results, r)
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(&candidates).(hnswResult)
+		if results.Len() >= ef && c.score < results.items[0].score {
+			break
+		}
+
+		for _, n := range h.nodes[c.id].neighbors[l] {
+			if visited[n] {
+				continue
+			}
+
+			visited[n] = true
+			r := hnswResult{n, dot(q, h.nodes[n].vec)}
+			if results.Len() < ef || r.score > results.items[0].score {
+				heap.Push(&candidates, r)
+				heap.Push(&results, r)
+				if results.Len() > ef {
+					heap.Pop(&results)
+				}
+			}
+		}
+	}
+
+	sorted := make([]hnswResult, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(&results).(hnswResult)
+	}
+
+	return sorted
+}
+
+// hnswHeap is a heap of results, most similar on top unless min is set
+type hnswHeap struct {
+	items []hnswResult
+	min   bool
+}
+
+func (h hnswHeap) Len() int { return len(h.items) }
+
+func (h hnswHeap) Less(i, j int) bool {
+	if h.min {
+		return h.items[i].score < h.items[j].score
+	}
+
+	return h.items[i].score > h.items[j].score
+}
+
+func (h hnswHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *hnswHeap) Push(x any) { h.items = append(h.items, x.(hnswResult)) }
+
+func (h *hnswHeap) Pop() any {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
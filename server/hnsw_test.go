@@ -0,0 +1,107 @@
+package server
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func randomVectors(rng *rand.Rand, n, dims int) [][]float32 {
+	vecs := make([][]float32, n)
+	for i := range vecs {
+		vec := make([]float32, dims)
+		for j := range vec {
+			vec[j] = rng.Float32()*2 - 1
+		}
+
+		vecs[i] = normalize(vec)
+	}
+
+	return vecs
+}
+
+// exactSearch returns the IDs of the k vectors most similar to q
+func exactSearch(vecs [][]float32, q []float32, k int, deleted map[int]bool) []int {
+	var ids []int
+	for i := range vecs {
+		if !deleted[i] {
+			ids = append(ids, i)
+		}
+	}
+
+	slices.SortFunc(ids, func(a, b int) int {
+		sa, sb := dot(q, vecs[a]), dot(q, vecs[b])
+		switch {
+		case sa > sb:
+			return -1
+		case sa < sb:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return ids[:min(k, len(ids))]
+}
+
+func TestHNSW(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	vecs := randomVectors(rng, 1000, 16)
+
+	h := newHNSW(16, 100)
+	for i, vec := range vecs {
+		if id := h.insert(vec); id != i {
+			t.Fatalf("expected node %d, got %d", i, id)
+		}
+	}
+
+	deleted := make(map[int]bool)
+	for i := 0; i < len(vecs); i += 10 {
+		h.delete(i)
+		deleted[i] = true
+	}
+
+	const k = 10
+	var found, total int
+	for _, q := range randomVectors(rng, 50, 16) {
+		want := exactSearch(vecs, q, k, deleted)
+
+		results := h.search(q, k, 64)
+		if len(results) != k {
+			t.Fatalf("expected %d results, got %d", k, len(results))
+		}
+
+		for i, r := range results {
+			if deleted[r.id] {
+				t.Fatalf("deleted node %d returned", r.id)
+			}
+
+			if i > 0 && r.score > results[i-1].score {
+				t.Fatalf("results not sorted: %v", results)
+			}
+
+			if slices.Contains(want, r.id) {
+				found++
+			}
+		}
+
+		total += k
+	}
+
+	if recall := float64(found) / float64(total); recall < 0.95 {
+		t.Errorf("expected recall of at least 0.95, got %.2f", recall)
+	}
+}
+
+func TestHNSWEmpty(t *testing.T) {
+	h := newHNSW(16, 100)
+	if results := h.search([]float32{1, 0}, 10, 64); len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+
+	h.insert([]float32{1, 0})
+	h.delete(0)
+	if results := h.search([]float32{1, 0}, 10, 64); len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+}
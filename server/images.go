@@ -39,6 +39,7 @@ var (
 	errCapabilityCompletion = errors.New("completion")
 	errCapabilityTools      = errors.New("tools")
 	errCapabilityInsert     = errors.New("insert")
+	errCapabilityRerank     = errors.New("rerank")
 )
 
 type Capability string
@@ -47,8 +48,13 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityRerank     = Capability("rerank")
 )
 
+// poolingTypeRank is the llama.cpp pooling type of cross-encoder rerankers,
+// which output a single relevance score instead of an embedding
+const poolingTypeRank = 4
+
 type registryOptions struct {
 	Insecure bool
 	Username string
@@ -99,6 +105,25 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 			if _, ok := ggml.KV()[fmt.Sprintf("%s.pooling_type", ggml.KV().Architecture())]; ok {
 				errs = append(errs, errCapabilityCompletion)
 			}
+		case CapabilityRerank:
+			f, err := os.Open(m.ModelPath)
+			if err != nil {
+				slog.Error("couldn't open model file", "error", err)
+				errs = append(errs, errCapabilityRerank)
+				continue
+			}
+			defer f.Close()
+
+			ggml, _, err := llm.DecodeGGML(f, 0)
+			if err != nil {
+				slog.Error("couldn't decode ggml", "error", err)
+				errs = append(errs, errCapabilityRerank)
+				continue
+			}
+
+			if v, ok := ggml.KV()[fmt.Sprintf("%s.pooling_type", ggml.KV().Architecture())].(uint32); !ok || v != poolingTypeRank {
+				errs = append(errs, errCapabilityRerank)
+			}
 		case CapabilityTools:
 			if !slices.Contains(m.Template.Vars(), "tools") {
 				errs = append(errs, errCapabilityTools)
@@ -210,6 +235,15 @@ type RootFS struct {
 }
 
 func GetManifest(mp ModelPath) (*Manifest, string, error) {
+	if mp.Digest != "" {
+		m, err := ParseNamedManifest(model.ParseName(mp.GetFullTagname() + "@" + mp.Digest))
+		if err != nil {
+			return nil, "", err
+		}
+
+		return m, m.digest, nil
+	}
+
 	fp, err := mp.GetManifestPath()
 	if err != nil {
 		return nil, "", err
@@ -661,21 +695,31 @@ func CopyModel(src, dst model.Name) error {
 		return model.Unqualified(src)
 	}
 
-	if src.Filepath() == dst.Filepath() {
-		return nil
-	}
-
 	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
 	}
 
+	srcpath := filepath.Join(manifests, src.Filepath())
+	if src.Digest != "" {
+		// pinned names may resolve to another tag
+		m, err := ParseNamedManifest(src)
+		if err != nil {
+			return err
+		}
+
+		srcpath = m.filepath
+	}
+
 	dstpath := filepath.Join(manifests, dst.Filepath())
+	if srcpath == dstpath {
+		return nil
+	}
+
 	if err := os.MkdirAll(filepath.Dir(dstpath), 0o755); err != nil {
 		return err
 	}
 
-	srcpath := filepath.Join(manifests, src.Filepath())
 	srcfile, err := os.Open(srcpath)
 	if err != nil {
 		return err
@@ -825,7 +869,7 @@ func PruneDirectory(path string) error {
 	return nil
 }
 
-func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+func PushModel(ctx context.Context, name string, regOpts *registryOptions, sign bool, fn func(api.ProgressResponse)) error {
 	mp := ParseModelPath(name)
 	fn(api.ProgressResponse{Status: "retrieving manifest"})
 
@@ -869,6 +913,13 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	}
 	defer resp.Body.Close()
 
+	if sign {
+		fn(api.ProgressResponse{Status: "signing manifest"})
+		if err := pushSignature(ctx, mp, fmt.Sprintf("sha256:%x", sha256.Sum256(manifestJSON)), regOpts, fn); err != nil {
+			return fmt.Errorf("sign manifest: %w", err)
+		}
+	}
+
 	fn(api.ProgressResponse{Status: "success"})
 
 	return nil
@@ -884,7 +935,8 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	// build deleteMap to prune unused layers
 	deleteMap := make(map[string]struct{})
 
-	if !envconfig.NoPrune() {
+	// a pinned pull doesn't replace a manifest, so there's nothing to prune
+	if !envconfig.NoPrune() && mp.Digest == "" {
 		manifest, _, err = GetManifest(mp)
 		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
@@ -904,11 +956,35 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return errors.New("insecure protocol http")
 	}
 
+	sources, err := pullSources(mp, regOpts)
+	if err != nil {
+		return err
+	}
+
 	fn(api.ProgressResponse{Status: "pulling manifest"})
 
-	manifest, err = pullModelManifest(ctx, mp, regOpts)
+	manifest, manifestJSON, err := pullModelManifest(ctx, mp, sources)
 	if err != nil {
-		return fmt.Errorf("pull model manifest: %s", err)
+		return fmt.Errorf("pull model manifest: %w", err)
+	}
+
+	// signatures are verified before any blob is written
+	if policy := envconfig.VerifySignatures(); policy != "" {
+		if policy != signaturePolicyEnforce && policy != signaturePolicyWarn {
+			return fmt.Errorf("invalid signature policy %q, must be %q or %q", policy, signaturePolicyEnforce, signaturePolicyWarn)
+		}
+
+		fn(api.ProgressResponse{Status: "verifying signature"})
+		publicKey, err := verifyManifest(ctx, mp, sources, fmt.Sprintf("sha256:%x", sha256.Sum256(manifestJSON)))
+		switch {
+		case err == nil:
+			slog.Info("verified manifest signature", "model", mp.GetShortTagname(), "key", publicKey)
+		case policy == signaturePolicyWarn && (errors.Is(err, errManifestUnsigned) || errors.Is(err, errManifestUntrusted)):
+			slog.Warn("unverified manifest", "model", mp.GetShortTagname(), "error", err)
+			fn(api.ProgressResponse{Status: fmt.Sprintf("warning: %v", err)})
+		default:
+			return fmt.Errorf("verify signature: %w", err)
+		}
 	}
 
 	var layers []Layer
@@ -922,7 +998,7 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		cacheHit, err := downloadBlob(ctx, downloadOpts{
 			mp:      mp,
 			digest:  layer.Digest,
-			regOpts: regOpts,
+			sources: sources,
 			fn:      fn,
 		})
 		if err != nil {
@@ -956,12 +1032,15 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 
 	fn(api.ProgressResponse{Status: "writing manifest"})
 
-	manifestJSON, err := json.Marshal(manifest)
-	if err != nil {
-		return err
+	// the manifest is written as pulled so its digest matches the
+	// registry's, which pinned names refer to. A pinned pull doesn't move
+	// the tag; its manifest is stored under a tag named for the digest.
+	target := mp
+	if mp.Digest != "" {
+		target.Tag = strings.ReplaceAll(mp.Digest, ":", "-")
 	}
 
-	fp, err := mp.GetManifestPath()
+	fp, err := target.GetManifestPath()
 	if err != nil {
 		return err
 	}
@@ -989,23 +1068,53 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	return nil
 }
 
-func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, error) {
-	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+// pullModelManifest pulls the manifest of mp from the first of sources that
+// has it. It returns the manifest and its JSON as pulled.
+func pullModelManifest(ctx context.Context, mp ModelPath, sources []pullSource) (m *Manifest, bts []byte, err error) {
+	for _, source := range sources {
+		m, bts, err = source.pullManifest(ctx, mp)
+		if err == nil || ctx.Err() != nil {
+			return m, bts, err
+		}
+
+		if source.mirror != "" {
+			slog.Warn("couldn't pull manifest from mirror", "mirror", source.mirror, "model", mp.GetShortTagname(), "error", err)
+		}
+	}
+
+	return nil, nil, err
+}
+
+// errManifestDigestMismatch is returned when the manifest pulled for a
+// pinned name doesn't have the pinned digest
+var errManifestDigestMismatch = errors.New("manifest digest mismatch")
 
+func (s pullSource) pullManifest(ctx context.Context, mp ModelPath) (*Manifest, []byte, error) {
 	headers := make(http.Header)
 	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, s.url(mp, "manifests", cmp.Or(mp.Digest, mp.Tag)), headers, nil, s.regOpts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mp.Digest != "" {
+		if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(bts)); digest != mp.Digest {
+			return nil, nil, fmt.Errorf("%w: want %s, got %s", errManifestDigestMismatch, mp.Digest, digest)
+		}
+	}
+
 	var m *Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
-		return nil, err
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return nil, nil, err
 	}
 
-	return m, err
+	return m, bts, nil
 }
 
 // GetSHA256Digest returns the SHA256 hash of a given buffer and returns it, and the size of buffer
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestPullModelPinned(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	registry := newTestRegistry(t, []byte("a model layer"))
+	srv := httptest.NewServer(registry)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(registry.manifest))
+	name := model.ParseName(u.Host + "/library/test@" + digest)
+
+	pull := func(name model.Name) error {
+		return PullModel(context.TODO(), "http://"+name.DisplayShortest(), &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+	}
+
+	// the tag already has another manifest, which the pinned pull leaves
+	// alone with its layers
+	latest := name
+	latest.Digest = ""
+	latestPath := filepath.Join(os.Getenv("OLLAMA_MODELS"), "manifests", latest.Filepath())
+	blob := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("another model layer")))
+	blobPath, err := GetBlobsPath(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(blobPath, []byte("another model layer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	latestManifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"digest":%q,"size":19}]}`, blob))
+	if err := os.MkdirAll(filepath.Dir(latestPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(latestPath, latestManifest, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pull(name); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(latestPath); err != nil || string(got) != string(latestManifest) {
+		t.Errorf("expected tag to be unchanged, got %s: %v", got, err)
+	}
+
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Errorf("expected the tag's layer to be kept: %v", err)
+	}
+
+	if !slices.Contains(registry.served(), "GET /v2/library/test/manifests/"+digest) {
+		t.Errorf("expected manifest to be pulled by digest, got %v", registry.served())
+	}
+
+	m, err := ParseNamedManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the manifest is stored as pulled, so its digest is the registry's
+	if "sha256:"+m.digest != digest {
+		t.Errorf("expected digest %s, got sha256:%s", digest, m.digest)
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		pinned := name
+		pinned.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(nil))
+		if err := pull(pinned); !errors.Is(err, errManifestDigestMismatch) {
+			t.Fatalf("expected digest mismatch, got %v", err)
+		}
+	})
+
+	t.Run("tag moved", func(t *testing.T) {
+		if err := CopyModel(name, model.ParseName(u.Host+"/library/test:pinned")); err != nil {
+			t.Fatal(err)
+		}
+
+		// only the copy is left with the digest
+		if err := os.Remove(m.filepath); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := ParseNamedManifest(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if filepath.Base(m.filepath) != "pinned" || "sha256:"+m.digest != digest {
+			t.Errorf("expected pinned manifest, got %s with digest %s", m.filepath, m.digest)
+		}
+
+		got, err := GetModel(name.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if "sha256:"+got.Digest != digest {
+			t.Errorf("expected digest %s, got sha256:%s", digest, got.Digest)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		pinned := name
+		pinned.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(nil))
+		if _, err := ParseNamedManifest(pinned); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected not exist, got %v", err)
+		}
+
+		if _, err := GetModel(pinned.String()); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected not exist, got %v", err)
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
@@ -59,6 +60,8 @@ func (m *Manifest) RemoveLayers() error {
 	return nil
 }
 
+// ParseNamedManifest returns the manifest of n. If n is pinned to a digest,
+// it's the manifest of whichever of the model's tags has that digest.
 func ParseNamedManifest(n model.Name) (*Manifest, error) {
 	if !n.IsFullyQualified() {
 		return nil, model.Unqualified(n)
@@ -70,7 +73,36 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 	}
 
 	p := filepath.Join(manifests, n.Filepath())
+	m, err := parseManifest(p)
+	if n.Digest == "" {
+		return m, err
+	} else if err == nil && "sha256:"+m.digest == n.Digest {
+		return m, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// the tag may have moved since the model was pinned, but another tag
+	// can still have the digest
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(p), "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range matches {
+		if match == p {
+			continue
+		}
 
+		if m, err := parseManifest(match); err == nil && "sha256:"+m.digest == n.Digest {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("manifest %s: %w", n.Digest, os.ErrNotExist)
+}
+
+func parseManifest(p string) (*Manifest, error) {
 	var m Manifest
 	f, err := os.Open(p)
 	if err != nil {
@@ -88,6 +120,11 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 		return nil, err
 	}
 
+	// the digest covers anything after the JSON the decoder didn't read
+	if _, err := io.Copy(sha256sum, f); err != nil {
+		return nil, err
+	}
+
 	m.filepath = p
 	m.fi = fi
 	m.digest = hex.EncodeToString(sha256sum.Sum(nil))
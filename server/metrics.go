@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request
+// latency histogram
+var requestDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+type requestKey struct {
+	handler, model string
+}
+
+type requestStats struct {
+	// count holds the number of requests by status code
+	count map[int]uint64
+
+	// buckets holds the cumulative number of requests within each of
+	// requestDurationBuckets
+	buckets []uint64
+	sum     float64
+	total   uint64
+}
+
+type tokenStats struct {
+	prompt, eval uint64
+}
+
+// metrics collects request and token counts for the /metrics endpoint. The
+// zero value is not usable; a nil *metrics discards everything.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]*requestStats
+	tokens   map[string]*tokenStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestKey]*requestStats),
+		tokens:   make(map[string]*tokenStats),
+	}
+}
+
+// observeRequest records a finished request to handler for model
+func (m *metrics) observeRequest(handler, model string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{handler, model}
+	stats, ok := m.requests[key]
+	if !ok {
+		stats = &requestStats{
+			count:   make(map[int]uint64),
+			buckets: make([]uint64, len(requestDurationBuckets)),
+		}
+		m.requests[key] = stats
+	}
+
+	stats.count[status]++
+	stats.total++
+	stats.sum += d.Seconds()
+	for i, le := range requestDurationBuckets {
+		if d.Seconds() <= le {
+			stats.buckets[i]++
+		}
+	}
+}
+
+// observeTokens records the prompt and eval token counts of a completed response
+func (m *metrics) observeTokens(model string, prompt, eval int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	model = metricsModelName(model)
+	stats, ok := m.tokens[model]
+	if !ok {
+		stats = &tokenStats{}
+		m.tokens[model] = stats
+	}
+
+	stats.prompt += uint64(prompt)
+	stats.eval += uint64(eval)
+}
+
+// metricsPeekSize is how much of a request body is searched for the model
+const metricsPeekSize = 64 * 1024
+
+// middleware records the count and latency of every request. The model is
+// read from the "model" or "name" field of JSON request bodies, if it's
+// within the first metricsPeekSize bytes.
+func (m *metrics) middleware(c *gin.Context) {
+	start := time.Now()
+	handler := c.FullPath()
+	if m == nil || handler == "" {
+		c.Next()
+		return
+	}
+
+	var name string
+	if c.Request.Body != nil && c.Request.Method != http.MethodGet {
+		br := bufio.NewReaderSize(c.Request.Body, metricsPeekSize)
+		name = peekModelName(br)
+		c.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{br, c.Request.Body}
+	}
+
+	if name == "" {
+		name = c.Param("model")
+	}
+
+	c.Next()
+
+	m.observeRequest(handler, localModelName(name), c.Writer.Status(), time.Since(start))
+}
+
+// peekModelName returns the "model" or "name" field of a JSON object at the
+// start of br without consuming it
+func peekModelName(br *bufio.Reader) string {
+	if b, err := br.Peek(1); err != nil || b[0] != '{' {
+		return ""
+	}
+
+	// a short read only means the body is smaller than the peek
+	b, _ := br.Peek(metricsPeekSize)
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return ""
+	}
+
+	var name string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return name
+		}
+
+		switch t {
+		case "model", "name":
+			var s string
+			if err := dec.Decode(&s); err != nil {
+				return name
+			} else if t == "model" && s != "" {
+				return s
+			}
+
+			name = cmp.Or(name, s)
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return name
+			}
+		}
+	}
+
+	return name
+}
+
+// localModelName returns the name of a local model as it's labeled in
+// metrics, or an empty string if s doesn't name a local model. Requests for
+// other models are counted without a model so clients can't create
+// unbounded series.
+func localModelName(s string) string {
+	n := model.ParseName(s)
+	if !n.IsValid() {
+		return ""
+	}
+
+	if _, err := ParseNamedManifest(n); err != nil {
+		return ""
+	}
+
+	return n.DisplayShortest()
+}
+
+// metricsModelName normalizes a model name so the metrics of requests naming
+// the same model in different ways are counted together
+func metricsModelName(s string) string {
+	if n := model.ParseName(s); n.IsValid() {
+		return n.DisplayShortest()
+	}
+
+	return s
+}
+
+func (s *Server) MetricsHandler(c *gin.Context) {
+	var sb strings.Builder
+
+	if s.sched != nil {
+		writeMetricsHeader(&sb, "ollama_scheduler_pending_requests", "gauge", "Number of requests waiting to be scheduled.")
+		fmt.Fprintf(&sb, "ollama_scheduler_pending_requests %d\n", len(s.sched.pendingReqCh)+s.sched.queue.Len())
+
+		s.sched.loadedMu.Lock()
+		runners := make([]*runnerRef, 0, len(s.sched.loaded))
+		for _, r := range s.sched.loaded {
+			runners = append(runners, r)
+		}
+		s.sched.loadedMu.Unlock()
+
+		slices.SortFunc(runners, func(a, b *runnerRef) int {
+			return strings.Compare(a.modelPath, b.modelPath)
+		})
+
+		writeMetricsHeader(&sb, "ollama_loaded_runners", "gauge", "Number of loaded runners.")
+		fmt.Fprintf(&sb, "ollama_loaded_runners %d\n", len(runners))
+
+		var refCounts, vram, vramByGPU, loadDurations strings.Builder
+		for _, r := range runners {
+			name := r.modelPath
+			if r.model != nil {
+				name = r.model.ShortName
+			}
+
+			labels := metricsLabels("model", name)
+
+			// runners hold the lock while loading so skip them rather than
+			// wait for the load to finish
+			if !r.refMu.TryLock() {
+				continue
+			}
+
+			fmt.Fprintf(&refCounts, "ollama_runner_ref_count%s %d\n", labels, r.refCount)
+			fmt.Fprintf(&vram, "ollama_runner_estimated_vram_bytes%s %d\n", labels, r.estimatedVRAM)
+			fmt.Fprintf(&loadDurations, "ollama_runner_load_duration_seconds%s %s\n", labels, formatFloat(r.loadDuration.Seconds()))
+			if r.llama != nil {
+				for _, g := range r.gpus {
+					if g.Library == "cpu" {
+						continue
+					}
+
+					fmt.Fprintf(&vramByGPU, "ollama_runner_estimated_vram_by_gpu_bytes%s %d\n", metricsLabels("model", name, "gpu", g.ID), r.llama.EstimatedVRAMByGPU(g.ID))
+				}
+			}
+
+			r.refMu.Unlock()
+		}
+
+		writeMetricsHeader(&sb, "ollama_runner_ref_count", "gauge", "Number of requests using the runner.")
+		sb.WriteString(refCounts.String())
+		writeMetricsHeader(&sb, "ollama_runner_estimated_vram_bytes", "gauge", "Estimated VRAM used by the runner.")
+		sb.WriteString(vram.String())
+		writeMetricsHeader(&sb, "ollama_runner_estimated_vram_by_gpu_bytes", "gauge", "Estimated VRAM used by the runner on each GPU.")
+		sb.WriteString(vramByGPU.String())
+		writeMetricsHeader(&sb, "ollama_runner_load_duration_seconds", "gauge", "Time taken to load the runner.")
+		sb.WriteString(loadDurations.String())
+	}
+
+	s.metrics.write(&sb)
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(sb.String()))
+}
+
+// write appends the request and token metrics in the Prometheus text format
+func (m *metrics) write(sb *strings.Builder) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.handler, b.handler), strings.Compare(a.model, b.model))
+	})
+
+	writeMetricsHeader(sb, "ollama_requests_total", "counter", "Number of requests by handler, model and status code.")
+	for _, key := range keys {
+		stats := m.requests[key]
+		statuses := make([]int, 0, len(stats.count))
+		for status := range stats.count {
+			statuses = append(statuses, status)
+		}
+
+		slices.Sort(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(sb, "ollama_requests_total%s %d\n", metricsLabels("handler", key.handler, "model", key.model, "status", strconv.Itoa(status)), stats.count[status])
+		}
+	}
+
+	writeMetricsHeader(sb, "ollama_request_duration_seconds", "histogram", "Request latency by handler and model.")
+	for _, key := range keys {
+		stats := m.requests[key]
+		for i, le := range requestDurationBuckets {
+			fmt.Fprintf(sb, "ollama_request_duration_seconds_bucket%s %d\n", metricsLabels("handler", key.handler, "model", key.model, "le", formatFloat(le)), stats.buckets[i])
+		}
+
+		fmt.Fprintf(sb, "ollama_request_duration_seconds_bucket%s %d\n", metricsLabels("handler", key.handler, "model", key.model, "le", "+Inf"), stats.total)
+		fmt.Fprintf(sb, "ollama_request_duration_seconds_sum%s %s\n", metricsLabels("handler", key.handler, "model", key.model), formatFloat(stats.sum))
+		fmt.Fprintf(sb, "ollama_request_duration_seconds_count%s %d\n", metricsLabels("handler", key.handler, "model", key.model), stats.total)
+	}
+
+	models := make([]string, 0, len(m.tokens))
+	for model := range m.tokens {
+		models = append(models, model)
+	}
+
+	slices.Sort(models)
+
+	writeMetricsHeader(sb, "ollama_prompt_tokens_total", "counter", "Number of prompt tokens evaluated by model.")
+	for _, model := range models {
+		fmt.Fprintf(sb, "ollama_prompt_tokens_total%s %d\n", metricsLabels("model", model), m.tokens[model].prompt)
+	}
+
+	writeMetricsHeader(sb, "ollama_eval_tokens_total", "counter", "Number of tokens generated by model.")
+	for _, model := range models {
+		fmt.Fprintf(sb, "ollama_eval_tokens_total%s %d\n", metricsLabels("model", model), m.tokens[model].eval)
+	}
+}
+
+func writeMetricsHeader(sb *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// metricsLabels formats pairs of label names and values
+func metricsLabels(kv ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[i+1])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, kv[i], v))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
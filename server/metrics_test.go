@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := &Server{
+		sched: &Scheduler{
+			pendingReqCh: make(chan *LlmRequest, 1),
+			loaded: map[string]*runnerRef{
+				"/path/to/test": {
+					model:         &Model{ShortName: "test:latest"},
+					modelPath:     "/path/to/test",
+					llama:         &mockLlm{estimatedVRAMByGPU: map[string]uint64{"0": 1024}},
+					gpus:          gpu.GpuInfoList{{Library: "cuda", ID: "0"}},
+					refCount:      2,
+					estimatedVRAM: 1024,
+					loadDuration:  1500 * time.Millisecond,
+				},
+			},
+		},
+		metrics: newMetrics(),
+	}
+
+	s.sched.pendingReqCh <- &LlmRequest{}
+	s.metrics.observeTokens("test", 10, 20)
+	s.metrics.observeTokens("test:latest", 5, 2)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+		Stream:    &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	router := s.GenerateRoutes()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/show", strings.NewReader(`{"model":"missing"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	// the model name is found after other fields
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/show", strings.NewReader(`{"verbose":false,"name":"test"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`ollama_scheduler_pending_requests 1`,
+		`ollama_loaded_runners 1`,
+		`ollama_runner_ref_count{model="test:latest"} 2`,
+		`ollama_runner_estimated_vram_bytes{model="test:latest"} 1024`,
+		`ollama_runner_estimated_vram_by_gpu_bytes{model="test:latest",gpu="0"} 1024`,
+		`ollama_runner_load_duration_seconds{model="test:latest"} 1.5`,
+		`ollama_requests_total{handler="/api/show",model="",status="404"} 1`,
+		`ollama_requests_total{handler="/api/show",model="test:latest",status="200"} 1`,
+		`ollama_request_duration_seconds_bucket{handler="/api/show",model="",le="+Inf"} 1`,
+		`ollama_request_duration_seconds_count{handler="/api/show",model="test:latest"} 1`,
+		`ollama_prompt_tokens_total{model="test:latest"} 15`,
+		`ollama_eval_tokens_total{model="test:latest"} 22`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestPeekModelName(t *testing.T) {
+	cases := map[string]string{
+		`{"model":"test"}`:                   "test",
+		`{"name":"test"}`:                    "test",
+		`{"name":"other","model":"test"}`:    "test",
+		`{"prompt":{"a":[1,2]},"model":"x"}`: "x",
+		`{"model":1}`:                        "",
+		`not json`:                           "",
+		`{"prompt":"` + strings.Repeat("a", metricsPeekSize) + `","model":"test"}`: "",
+	}
+
+	for body, want := range cases {
+		br := bufio.NewReaderSize(strings.NewReader(body), metricsPeekSize)
+		if got := peekModelName(br); got != want {
+			t.Errorf("%.40s: expected %q, got %q", body, want, got)
+		}
+
+		// the body is left for the handler
+		rest, err := io.ReadAll(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(rest) != body {
+			t.Errorf("%.40s: body was consumed", body)
+		}
+	}
+}
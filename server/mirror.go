@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// registryMirror is a mirror of a registry, such as a pull-through cache
+type registryMirror struct {
+	// URL is the base URL of the mirror. Requests are made to the same
+	// paths under it as under the registry.
+	URL string `json:"url"`
+
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// registryMirrors are the mirrors of a registry
+type registryMirrors struct {
+	Mirrors []registryMirror `json:"mirrors"`
+
+	// Fallback is whether to pull from the registry itself if none of the
+	// mirrors can be pulled from. It defaults to true.
+	Fallback *bool `json:"fallback,omitempty"`
+}
+
+// readMirrors reads the mirrors of each registry. The file is a JSON object
+// of mirrors by registry host, for example:
+//
+//	{
+//	  "registry.ollama.ai": {
+//	    "mirrors": [{"url": "https://mirror.internal", "username": "ollama", "password": "secret"}],
+//	    "fallback": false
+//	  }
+//	}
+func readMirrors(path string) (map[string]registryMirrors, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mirrors map[string]registryMirrors
+	if err := json.Unmarshal(bts, &mirrors); err != nil {
+		return nil, fmt.Errorf("invalid mirrors file %s: %w", path, err)
+	}
+
+	for registry, m := range mirrors {
+		for i, mirror := range m.Mirrors {
+			u, err := url.Parse(mirror.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid mirrors file %s: mirror %d of %s has an invalid url %q", path, i, registry, mirror.URL)
+			}
+		}
+	}
+
+	return mirrors, nil
+}
+
+// pullSource is a registry or mirror that models are pulled from
+type pullSource struct {
+	// mirror is the host of the mirror, or empty for the registry itself
+	mirror string
+
+	baseURL *url.URL
+	regOpts *registryOptions
+}
+
+// pullSources returns the sources to pull mp from in the order to try them:
+// the mirrors of its registry, then the registry itself unless the mirrors
+// don't fall back to it
+func pullSources(mp ModelPath, regOpts *registryOptions) ([]pullSource, error) {
+	mirrors, err := readMirrors(envconfig.Mirrors())
+	if err != nil {
+		return nil, err
+	}
+
+	upstream := pullSource{baseURL: mp.BaseURL(), regOpts: regOpts}
+
+	m, ok := mirrors[mp.Registry]
+	if !ok {
+		return []pullSource{upstream}, nil
+	}
+
+	sources := make([]pullSource, 0, len(m.Mirrors)+1)
+	for _, mirror := range m.Mirrors {
+		// validated by readMirrors
+		u, _ := url.Parse(mirror.URL)
+		sources = append(sources, pullSource{
+			mirror:  u.Host,
+			baseURL: u,
+			regOpts: &registryOptions{
+				Username: mirror.Username,
+				Password: mirror.Password,
+				Token:    mirror.Token,
+			},
+		})
+	}
+
+	if m.Fallback == nil || *m.Fallback {
+		sources = append(sources, upstream)
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no mirrors for %s and fallback is disabled", mp.Registry)
+	}
+
+	return sources, nil
+}
+
+// url returns the URL of a path in the repository of mp on the source
+func (s pullSource) url(mp ModelPath, elem ...string) *url.URL {
+	return s.baseURL.JoinPath(append([]string{"v2", mp.GetNamespaceRepository()}, elem...)...)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+// testRegistry serves a single model's manifest, for any reference, and its
+// blobs. It records the paths requested of it.
+type testRegistry struct {
+	manifest []byte
+	blobs    map[string][]byte
+
+	username, password string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestRegistry(t *testing.T, blobs ...[]byte) *testRegistry {
+	t.Helper()
+	r := testRegistry{blobs: make(map[string][]byte)}
+
+	var layers []Layer
+	for _, blob := range blobs {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+		r.blobs[digest] = blob
+		layers = append(layers, Layer{MediaType: "application/vnd.ollama.image.model", Digest: digest, Size: int64(len(blob))})
+	}
+
+	var err error
+	r.manifest, err = json.Marshal(Manifest{SchemaVersion: 2, MediaType: manifestMediaType, Layers: layers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &r
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	r.mu.Unlock()
+
+	if username, password, _ := req.BasicAuth(); username != r.username || password != r.password {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case strings.Contains(req.URL.Path, "/manifests/"):
+		w.Write(r.manifest)
+	case strings.Contains(req.URL.Path, "/blobs/"):
+		blob, ok := r.blobs[req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(blob))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *testRegistry) served() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func TestPullMirrors(t *testing.T) {
+	blob := []byte("a model layer")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	upstream := newTestRegistry(t, blob)
+	upstreamServer := httptest.NewServer(upstream)
+	t.Cleanup(upstreamServer.Close)
+
+	mirror := newTestRegistry(t, blob)
+	mirror.username, mirror.password = "ollama", "secret"
+	mirrorServer := httptest.NewServer(mirror)
+	t.Cleanup(mirrorServer.Close)
+
+	u, err := url.Parse(upstreamServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirrorURL, err := url.Parse(mirrorServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := u.Host + "/library/test"
+
+	writeMirrors := func(t *testing.T, mirrors map[string]registryMirrors) {
+		t.Helper()
+		bts, err := json.Marshal(mirrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := filepath.Join(t.TempDir(), "mirrors.json")
+		if err := os.WriteFile(p, bts, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("OLLAMA_MIRRORS", p)
+	}
+
+	pull := func(t *testing.T) ([]string, error) {
+		t.Helper()
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		upstream.requests, mirror.requests = nil, nil
+
+		var mirrors []string
+		err := PullModel(context.TODO(), "http://"+name, &registryOptions{Insecure: true}, func(resp api.ProgressResponse) {
+			if resp.Digest != "" {
+				mirrors = append(mirrors, resp.Mirror)
+			}
+		})
+		return mirrors, err
+	}
+
+	f := false
+
+	t.Run("mirror", func(t *testing.T) {
+		writeMirrors(t, map[string]registryMirrors{
+			u.Host: {Mirrors: []registryMirror{{URL: mirrorServer.URL, Username: "ollama", Password: "secret"}}},
+		})
+
+		mirrors, err := pull(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(upstream.served()) != 0 {
+			t.Errorf("expected no requests to upstream, got %v", upstream.served())
+		}
+
+		if len(mirror.served()) == 0 {
+			t.Error("expected requests to mirror")
+		}
+
+		if len(mirrors) == 0 || mirrors[len(mirrors)-1] != mirrorURL.Host {
+			t.Errorf("expected blob to be pulled from %s, got %v", mirrorURL.Host, mirrors)
+		}
+	})
+
+	t.Run("mirror path", func(t *testing.T) {
+		prefixed := http.NewServeMux()
+		prefixed.Handle("/api/docker/ollama/", http.StripPrefix("/api/docker/ollama", mirror))
+		prefixedServer := httptest.NewServer(prefixed)
+		t.Cleanup(prefixedServer.Close)
+
+		writeMirrors(t, map[string]registryMirrors{
+			u.Host: {
+				Mirrors:  []registryMirror{{URL: prefixedServer.URL + "/api/docker/ollama", Username: "ollama", Password: "secret"}},
+				Fallback: &f,
+			},
+		})
+
+		if _, err := pull(t); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(mirror.served()[0], "GET /v2/library/test/manifests/latest"); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		writeMirrors(t, map[string]registryMirrors{
+			u.Host: {Mirrors: []registryMirror{{URL: mirrorServer.URL, Username: "ollama", Password: "wrong"}}},
+		})
+
+		mirrors, err := pull(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(upstream.served()) == 0 {
+			t.Error("expected requests to upstream")
+		}
+
+		if len(mirrors) == 0 || mirrors[len(mirrors)-1] != "" {
+			t.Errorf("expected blob to be pulled from upstream, got %v", mirrors)
+		}
+	})
+
+	t.Run("missing blob", func(t *testing.T) {
+		delete(mirror.blobs, digest)
+		t.Cleanup(func() { mirror.blobs[digest] = blob })
+
+		writeMirrors(t, map[string]registryMirrors{
+			u.Host: {Mirrors: []registryMirror{{URL: mirrorServer.URL, Username: "ollama", Password: "secret"}}},
+		})
+
+		mirrors, err := pull(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(mirrors) == 0 || mirrors[len(mirrors)-1] != "" {
+			t.Errorf("expected blob to be pulled from upstream, got %v", mirrors)
+		}
+	})
+
+	t.Run("no fallback", func(t *testing.T) {
+		writeMirrors(t, map[string]registryMirrors{
+			u.Host: {Mirrors: []registryMirror{{URL: mirrorServer.URL, Username: "ollama", Password: "wrong"}}, Fallback: &f},
+		})
+
+		if _, err := pull(t); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if len(upstream.served()) != 0 {
+			t.Errorf("expected no requests to upstream, got %v", upstream.served())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		writeMirrors(t, map[string]registryMirrors{
+			u.Host: {Mirrors: []registryMirror{{URL: "mirror.internal"}}},
+		})
+
+		_, err := pull(t)
+		if err == nil || !strings.Contains(err.Error(), `invalid url "mirror.internal"`) {
+			t.Fatalf("expected invalid url error, got %v", err)
+		}
+	})
+}
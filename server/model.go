@@ -239,9 +239,31 @@ func detectContentType(r io.Reader) (string, error) {
 	return "unknown", nil
 }
 
-// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
-// mxyng: this only really works if the input contains tool calls in some JSON format
-func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+// toolCallFormat describes how a model's template renders tool calls
+type toolCallFormat struct {
+	// name and arguments are the JSON keys holding the function name and arguments
+	name, arguments string
+
+	// prefix is the text, stripped of whitespace, the template writes before
+	// the first tool call object. It is empty if the template writes the
+	// objects directly.
+	prefix string
+}
+
+var toolCallPlaceholder = []api.ToolCall{
+	{
+		Function: api.ToolCallFunction{
+			Name: "@@name@@",
+			Arguments: api.ToolCallFunctionArguments{
+				"@@argument@@": 1,
+			},
+		},
+	},
+}
+
+// toolCallFormat executes the template subtree that ranges over .ToolCalls
+// with placeholder values to identify the keys used for tool calls
+func (m *Model) toolCallFormat() (*toolCallFormat, bool) {
 	// create a subtree from the node that ranges over .ToolCalls
 	tmpl := m.Template.Subtree(func(n parse.Node) bool {
 		if t, ok := n.(*parse.RangeNode); ok {
@@ -257,16 +279,7 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 
 	var b bytes.Buffer
 	if err := tmpl.Execute(&b, map[string][]api.ToolCall{
-		"ToolCalls": {
-			{
-				Function: api.ToolCallFunction{
-					Name: "@@name@@",
-					Arguments: api.ToolCallFunctionArguments{
-						"@@argument@@": 1,
-					},
-				},
-			},
-		},
+		"ToolCalls": toolCallPlaceholder,
 	}); err != nil {
 		return nil, false
 	}
@@ -293,6 +306,98 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 		return nil, false
 	}
 
+	return &toolCallFormat{name: name, arguments: arguments, prefix: m.toolCallPrefix()}, true
+}
+
+// toolCallPrefix renders an assistant message once with content and once with
+// tool calls. The text between where the two renderings diverge and the start
+// of the first tool call object is the prefix the model is expected to write
+// before calling tools, e.g. "[TOOL_CALLS] [" or "<tool_call>".
+func (m *Model) toolCallPrefix() string {
+	user := api.Message{Role: "user", Content: "@@user@@"}
+
+	var content bytes.Buffer
+	if err := m.Template.Execute(&content, template.Values{Messages: []api.Message{user, {Role: "assistant", Content: "@@content@@"}}}); err != nil {
+		return ""
+	}
+
+	var calls bytes.Buffer
+	if err := m.Template.Execute(&calls, template.Values{Messages: []api.Message{user, {Role: "assistant", ToolCalls: toolCallPlaceholder}}}); err != nil {
+		return ""
+	}
+
+	c, t := content.String(), calls.String()
+
+	end := strings.Index(t, "@@name@@")
+	if end < 0 {
+		return ""
+	}
+
+	end = strings.LastIndex(t[:end], "{")
+	if end < 0 {
+		return ""
+	}
+
+	var start int
+	for start < end && start < len(c) && c[start] == t[start] {
+		start++
+	}
+
+	return strings.Join(strings.Fields(t[start:end]), "")
+}
+
+// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
+// mxyng: this only really works if the input contains tool calls in some JSON format
+func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+	f, ok := m.toolCallFormat()
+	if !ok {
+		return nil, false
+	}
+
+	return f.parse(s)
+}
+
+// toolCallSchema returns a JSON schema matching a call to one of tools in
+// the model's tool call format. It's used as the format of requests that
+// must call a tool.
+func (m *Model) toolCallSchema(tools api.Tools) (json.RawMessage, error) {
+	f, ok := m.toolCallFormat()
+	if !ok {
+		return nil, errors.New("model does not support tool calls")
+	}
+
+	name, err := json.Marshal(f.name)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments, err := json.Marshal(f.arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	calls := make([]json.RawMessage, len(tools))
+	for i, t := range tools {
+		value, err := json.Marshal(t.Function.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		params, err := json.Marshal(t.Function.Parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		// properties are written by hand so the name comes before the arguments
+		calls[i] = json.RawMessage(fmt.Sprintf(`{"type":"object","properties":{%s:{"const":%s},%s:%s},"required":[%s,%s]}`, name, value, arguments, params, name, arguments))
+	}
+
+	return json.Marshal(map[string]any{"anyOf": calls})
+}
+
+// parse collects every JSON object in s, including nested objects, that
+// contains both the name and arguments keys
+func (f *toolCallFormat) parse(s string) ([]api.ToolCall, bool) {
 	var objs []map[string]any
 	for offset := 0; offset < len(s); {
 		var obj map[string]any
@@ -334,8 +439,8 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 
 	var toolCalls []api.ToolCall
 	for _, kv := range objs {
-		n, nok := kv[name].(string)
-		a, aok := kv[arguments].(map[string]any)
+		n, nok := kv[f.name].(string)
+		a, aok := kv[f.arguments].(map[string]any)
 		if nok && aok {
 			toolCalls = append(toolCalls, api.ToolCall{
 				Function: api.ToolCallFunction{
@@ -348,3 +453,146 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 
 	return toolCalls, len(toolCalls) > 0
 }
+
+// toolCallParser incrementally detects tool calls in streamed model output.
+// Output that may still become a tool call is held back until it can be
+// decided: the template's tool call prefix while it is being written and
+// any JSON object until it is closed.
+type toolCallParser struct {
+	format *toolCallFormat
+
+	buf     string
+	pos     int
+	checked bool // the prefix check has been resolved
+	tools   bool // the response contains tool calls; plain text is dropped
+
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+func (m *Model) newToolCallParser() (*toolCallParser, bool) {
+	f, ok := m.toolCallFormat()
+	if !ok {
+		return nil, false
+	}
+
+	return &toolCallParser{format: f, checked: f.prefix == ""}, true
+}
+
+// add consumes the next chunk of model output. It returns the content that is
+// safe to send to the client and any tool calls completed by this chunk.
+func (p *toolCallParser) add(s string) (string, []api.ToolCall) {
+	p.buf += s
+
+	if !p.checked {
+		n, matched, partial := matchPrefix(p.buf, p.format.prefix)
+		if partial {
+			return "", nil
+		}
+
+		if matched {
+			p.buf = p.buf[n:]
+			p.tools = true
+		}
+
+		p.checked = true
+	}
+
+	var sb strings.Builder
+	var calls []api.ToolCall
+	for ; p.pos < len(p.buf); p.pos++ {
+		c := p.buf[p.pos]
+		if p.depth == 0 {
+			if c == '{' {
+				p.text(&sb, p.buf[:p.pos])
+				p.buf = p.buf[p.pos:]
+				p.pos = 0
+				p.depth++
+			}
+
+			continue
+		}
+
+		switch {
+		case p.escaped:
+			p.escaped = false
+		case p.inString && c == '\\':
+			p.escaped = true
+		case c == '"':
+			p.inString = !p.inString
+		case p.inString:
+		case c == '{':
+			p.depth++
+		case c == '}':
+			p.depth--
+			if p.depth == 0 {
+				obj := p.buf[:p.pos+1]
+				if toolCalls, ok := p.format.parse(obj); ok {
+					calls = append(calls, toolCalls...)
+					p.tools = true
+				} else {
+					p.text(&sb, obj)
+				}
+
+				p.buf = p.buf[p.pos+1:]
+				p.pos = -1
+			}
+		}
+	}
+
+	if p.depth == 0 {
+		p.text(&sb, p.buf)
+		p.buf = ""
+		p.pos = 0
+	}
+
+	return sb.String(), calls
+}
+
+// done flushes any output still held back once the model finishes responding
+func (p *toolCallParser) done() (string, []api.ToolCall) {
+	s := p.buf
+	p.buf, p.pos, p.depth = "", 0, 0
+
+	if calls, ok := p.format.parse(s); ok {
+		p.tools = true
+		return "", calls
+	}
+
+	var sb strings.Builder
+	p.text(&sb, s)
+	return sb.String(), nil
+}
+
+func (p *toolCallParser) text(sb *strings.Builder, s string) {
+	if !p.tools {
+		sb.WriteString(s)
+	}
+}
+
+// matchPrefix compares s to prefix ignoring whitespace in s. n is the number
+// of bytes of s consumed by a complete match. partial is true if s is too short
+// to decide.
+func matchPrefix(s, prefix string) (n int, matched, partial bool) {
+	var j int
+	for i := 0; i < len(s); i++ {
+		if j == len(prefix) {
+			return i, true, false
+		}
+
+		switch {
+		case s[i] == ' ', s[i] == '\t', s[i] == '\n', s[i] == '\r':
+		case s[i] == prefix[j]:
+			j++
+		default:
+			return 0, false, false
+		}
+	}
+
+	if j == len(prefix) {
+		return len(s), true, false
+	}
+
+	return 0, false, true
+}
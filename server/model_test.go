@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/template"
 )
 
@@ -130,6 +132,103 @@ The temperature in San Francisco, CA is 70°F and in Toronto, Canada is 20°C.`,
 					}
 				}
 			})
+
+			t.Run("stream", func(t *testing.T) {
+				m := &Model{Template: tmpl}
+				parser, ok := m.newToolCallParser()
+				if !ok {
+					t.Fatal("expected tool call parser")
+				}
+
+				var sb strings.Builder
+				var actual []api.ToolCall
+				for s := tt.output; len(s) > 0; {
+					n := min(3, len(s))
+					content, toolCalls := parser.add(s[:n])
+					sb.WriteString(content)
+					actual = append(actual, toolCalls...)
+					s = s[n:]
+				}
+
+				content, toolCalls := parser.done()
+				sb.WriteString(content)
+				actual = append(actual, toolCalls...)
+
+				if tt.ok {
+					if diff := cmp.Diff(actual, calls); diff != "" {
+						t.Errorf("mismatch (-got +want):\n%s", diff)
+					}
+				} else {
+					if len(actual) > 0 {
+						t.Errorf("expected no tool calls, got %v", actual)
+					}
+
+					if diff := cmp.Diff(sb.String(), tt.output); diff != "" {
+						t.Errorf("mismatch (-got +want):\n%s", diff)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestToolCallSchema(t *testing.T) {
+	p := filepath.Join("testdata", "tools")
+
+	var tools []api.Tool
+	if err := json.Unmarshal(readFile(t, p, "tools.json").Bytes(), &tools); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		model  string
+		name   string
+		output string
+	}{
+		{"mistral", "name", `{"name":"get_current_weather","arguments":{"format":"celsius","location":"Toronto, Canada"}}`},
+		{"command-r-plus", "tool_name", `{"tool_name":"get_current_weather","parameters":{"format":"celsius","location":"Toronto, Canada"}}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.model, func(t *testing.T) {
+			tmpl, err := template.Parse(readFile(t, p, fmt.Sprintf("%s.gotmpl", tt.model)).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := &Model{Template: tmpl}
+			schema, err := m.toolCallSchema(tools)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := llm.SchemaGrammar(schema); err != nil {
+				t.Fatal(err)
+			}
+
+			var s struct {
+				AnyOf []struct {
+					Properties json.RawMessage `json:"properties"`
+				} `json:"anyOf"`
+			}
+			if err := json.Unmarshal(schema, &s); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.AnyOf) != 1 {
+				t.Fatalf("expected 1 tool, got %d", len(s.AnyOf))
+			}
+
+			// the name is generated before the arguments
+			if prefix := fmt.Sprintf(`{%q:{"const":"get_current_weather"}`, tt.name); !strings.HasPrefix(string(s.AnyOf[0].Properties), prefix) {
+				t.Errorf("expected properties to start with %s, got %s", prefix, s.AnyOf[0].Properties)
+			}
+
+			// output matching the schema is parsed as a tool call
+			calls, ok := m.parseToolCalls(tt.output)
+			if !ok || calls[0].Function.Name != "get_current_weather" {
+				t.Errorf("expected a tool call, got %v", calls)
+			}
 		})
 	}
 }
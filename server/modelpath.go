@@ -18,6 +18,9 @@ type ModelPath struct {
 	Namespace      string
 	Repository     string
 	Tag            string
+
+	// Digest is the digest of the manifest the path is pinned to, if any
+	Digest string
 }
 
 const (
@@ -49,6 +52,8 @@ func ParseModelPath(name string) ModelPath {
 		name = after
 	}
 
+	name, mp.Digest, _ = strings.Cut(name, "@")
+
 	name = strings.ReplaceAll(name, string(os.PathSeparator), "/")
 	parts := strings.Split(name, "/")
 	switch len(parts) {
@@ -3,6 +3,7 @@ package server
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -131,6 +132,18 @@ func TestParseModelPath(t *testing.T) {
 				Tag:            "tag",
 			},
 		},
+		{
+			"digest",
+			"example.com/ns/repo:tag@sha256:" + strings.Repeat("a", 64),
+			ModelPath{
+				ProtocolScheme: "https",
+				Registry:       "example.com",
+				Namespace:      "ns",
+				Repository:     "repo",
+				Tag:            "tag",
+				Digest:         "sha256:" + strings.Repeat("a", 64),
+			},
+		},
 		{
 			"no tag",
 			"repo",
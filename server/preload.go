@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/ollama/ollama/api"
+)
+
+// readPreload reads the list of models to load on startup. The file is a
+// JSON array of load requests, for example:
+//
+//	[
+//	  {"model": "llama3", "pin": true, "options": {"num_ctx": 8192}},
+//	  {"model": "nomic-embed-text", "keep_alive": "1h"}
+//	]
+func readPreload(path string) ([]api.LoadRequest, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqs []api.LoadRequest
+	if err := json.Unmarshal(bts, &reqs); err != nil {
+		return nil, fmt.Errorf("invalid preload file %s: %w", path, err)
+	}
+
+	for i, req := range reqs {
+		if req.Model == "" {
+			return nil, fmt.Errorf("invalid preload file %s: entry %d is missing a model", path, i)
+		}
+	}
+
+	return reqs, nil
+}
+
+// preload loads each model in turn. Models that fail to load are logged
+// and skipped.
+func (s *Server) preload(ctx context.Context, reqs []api.LoadRequest) {
+	for _, req := range reqs {
+		resp, err := s.load(ctx, req)
+		if err != nil {
+			slog.Error("failed to preload model", "model", req.Model, "error", err)
+			continue
+		}
+
+		slog.Info("preloaded model", "model", resp.Name, "pinned", resp.Pinned, "duration", resp.LoadDuration)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestReadPreload(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []api.LoadRequest
+		err     string
+	}{
+		{
+			name: "models",
+			content: `[
+				{"model": "llama3", "pin": true, "options": {"num_ctx": 8192}},
+				{"model": "nomic-embed-text", "keep_alive": "1h"}
+			]`,
+			want: []api.LoadRequest{
+				{Model: "llama3", Pin: true, Options: map[string]any{"num_ctx": float64(8192)}},
+				{Model: "nomic-embed-text", KeepAlive: &api.Duration{Duration: time.Hour}},
+			},
+		},
+		{
+			name:    "empty",
+			content: `[]`,
+			want:    []api.LoadRequest{},
+		},
+		{
+			name:    "missing model",
+			content: `[{"pin": true}]`,
+			err:     "entry 0 is missing a model",
+		},
+		{
+			name:    "invalid",
+			content: `{"model": "llama3"}`,
+			err:     "cannot unmarshal object",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "preload.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readPreload(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		got, err := readPreload("")
+		if err != nil || got != nil {
+			t.Errorf("expected no models, got %v %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,799 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/auth"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	// maxManifestSize limits the size of pushed manifests
+	maxManifestSize = 4 << 20
+
+	// maxRegistryChunkSize limits the size of each request of a blob upload.
+	// It's larger than the parts uploadBlob sends.
+	maxRegistryChunkSize = 2 << 30
+
+	// maxRegistryBlobSize limits the size of uploaded blobs
+	maxRegistryBlobSize = 1 << 40
+
+	// maxRegistryUploads limits the number of blob uploads in progress
+	maxRegistryUploads = 64
+
+	// registryTokenTTL is how long tokens issued to push are valid
+	registryTokenTTL = time.Hour
+
+	// maxTokenRequestAge limits the age of signed token requests, so they
+	// can't be replayed later
+	maxTokenRequestAge = 5 * time.Minute
+)
+
+// registryUploadTimeout is how long a blob upload may be idle before it's
+// canceled
+var registryUploadTimeout = 10 * time.Minute
+
+var errUploadTooLarge = errors.New("blob upload is too large")
+
+var (
+	// registryUploads holds the blob uploads in progress, by ID
+	registryUploads     sync.Map
+	registryUploadCount atomic.Int64
+
+	// registryTokens holds the tokens issued to push, by token, and
+	// registryNonces the nonces of the requests they were issued for
+	registryTokens sync.Map
+	registryNonces sync.Map
+)
+
+// registryUpload is a blob being pushed to the registry. Its chunks may
+// arrive in any order, so each is written at the offset in its
+// Content-Range. Uploads that are idle for registryUploadTimeout are
+// canceled.
+type registryUpload struct {
+	id   string
+	file *os.File
+
+	mu     sync.Mutex
+	size   int64
+	active int
+	closed bool
+	timer  *time.Timer
+}
+
+// registryToken is a token issued to push with the public key that signed
+// its request
+type registryToken struct {
+	key     string
+	expires time.Time
+}
+
+// RegistryHandler serves the local models as an OCI distribution registry,
+// which other servers pull from like any other registry. Repositories are
+// named by the namespace and model, optionally preceded by the host; the
+// host defaults to the default registry. Pushes are accepted if
+// OLLAMA_REGISTRY_PUSH is set, from clients with a token issued for an
+// Ollama key in OLLAMA_REGISTRY_PUSH_KEYS.
+//
+// See https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+func (s *Server) RegistryHandler(c *gin.Context) {
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+
+	p := strings.Trim(c.Param("path"), "/")
+	if p == "" {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	} else if p == "token" {
+		registryIssueToken(c)
+		return
+	}
+
+	parts := strings.Split(p, "/")
+	n := len(parts)
+	switch {
+	case n > 2 && parts[n-2] == "manifests":
+		registryManifest(c, strings.Join(parts[:n-2], "/"), parts[n-1])
+	case n > 2 && parts[n-2] == "blobs" && parts[n-1] == "uploads":
+		registryUploadBlob(c, strings.Join(parts[:n-2], "/"), "")
+	case n > 3 && parts[n-3] == "blobs" && parts[n-2] == "uploads":
+		registryUploadBlob(c, strings.Join(parts[:n-3], "/"), parts[n-1])
+	case n > 2 && parts[n-2] == "blobs":
+		registryBlob(c, strings.Join(parts[:n-2], "/"), parts[n-1])
+	default:
+		registryError(c, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+	}
+}
+
+// registryError aborts the request with an error in the format of the
+// distribution spec
+func registryError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"errors": []gin.H{{"code": code, "message": message}}})
+}
+
+// registryName returns the name of the model in repository repo with tag
+func registryName(repo, tag string) (model.Name, bool) {
+	n := model.ParseName(repo + ":" + tag)
+	return n, n.IsValid() && strings.Count(repo, "/") <= 2
+}
+
+// registryURL returns the absolute URL of a registry path, which clients
+// follow for uploads
+func registryURL(c *gin.Context, elem ...string) string {
+	u := url.URL{Scheme: registryScheme(c), Host: c.Request.Host, Path: path.Join(append([]string{"/v2"}, elem...)...)}
+	return u.String()
+}
+
+func registryScheme(c *gin.Context) string {
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		return proto
+	} else if c.Request.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// registryBaseURL returns the URL clients reach the registry at, either
+// OLLAMA_REGISTRY_URL or the address the server listens on. Token requests
+// are signed for it, so it must not come from the request.
+func registryBaseURL() *url.URL {
+	if s := envconfig.RegistryURL(); s != "" {
+		if u, err := url.Parse(s); err == nil && u.Host != "" {
+			return &url.URL{Scheme: u.Scheme, Host: u.Host}
+		}
+
+		slog.Warn("invalid registry URL, using OLLAMA_HOST", "url", s)
+	}
+
+	return envconfig.Host()
+}
+
+// sameHost reports whether host, as in a request to the registry, is the host
+// of u, defaulting either port to that of u's scheme
+func sameHost(host string, u *url.URL) bool {
+	withPort := func(host string) string {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return host
+		} else if u.Scheme == "https" {
+			return net.JoinHostPort(host, "443")
+		}
+
+		return net.JoinHostPort(host, "80")
+	}
+
+	return strings.EqualFold(withPort(host), withPort(u.Host))
+}
+
+// registryIssueToken issues a token to push for a request signed with an
+// Ollama key, as getAuthorizationToken signs it. The key must be allowed to
+// push to some namespace; each push checks it's allowed to push to its own.
+func registryIssueToken(c *gin.Context) {
+	if !envconfig.RegistryPush() {
+		registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "pushing is disabled")
+		return
+	}
+
+	query := c.Request.URL.Query()
+	ts, err := strconv.ParseInt(query.Get("ts"), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > maxTokenRequestAge {
+		registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", "token request is expired")
+		return
+	}
+
+	nonce := query.Get("nonce")
+	if nonce == "" {
+		registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", "token request has no nonce")
+		return
+	}
+
+	// the request is verified against this registry's URL so requests
+	// signed for other registries can't be replayed here
+	base := registryBaseURL()
+	if !sameHost(c.Request.Host, base) {
+		registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", fmt.Sprintf("token request is not for %s", base.Host))
+		return
+	}
+
+	u := url.URL{Scheme: base.Scheme, Host: base.Host, Path: c.Request.URL.Path, RawQuery: c.Request.URL.RawQuery}
+	sha256sum := sha256.Sum256(nil)
+	data := []byte(fmt.Sprintf("%s,%s,%s", http.MethodGet, u.String(), base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sha256sum[:])))))
+
+	key, err := auth.Verify(data, c.GetHeader("Authorization"))
+	if err != nil {
+		registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", fmt.Sprintf("invalid signature: %v", err))
+		return
+	}
+
+	keys, err := readTrustedKeys(envconfig.RegistryPushKeys())
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	var allowed bool
+	for _, ks := range keys {
+		allowed = allowed || slices.Contains(ks, key)
+	}
+
+	if !allowed {
+		registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", fmt.Sprintf("%s is not allowed to push", key))
+		return
+	}
+
+	now := time.Now()
+	pruneRegistryTokens(now)
+
+	if _, replayed := registryNonces.LoadOrStore(nonce, time.Unix(ts, 0)); replayed {
+		registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", "token request was already used")
+		return
+	}
+
+	token, err := auth.NewNonce(rand.Reader, 32)
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	registryTokens.Store(token, registryToken{key: key, expires: now.Add(registryTokenTTL)})
+	c.JSON(http.StatusOK, api.TokenResponse{Token: token})
+}
+
+// readTrustedKeys reads the public keys trusted for each namespace, to push
+// to it or to sign its models. The file is a JSON object of keys in
+// authorized_keys format by namespace, optionally preceded by the registry
+// host, for example:
+//
+//	{
+//	  "library": ["ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE... ci@example.com"],
+//	  "registry.internal/models": ["ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIK..."]
+//	}
+func readTrustedKeys(path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys map[string][]string
+	if err := json.Unmarshal(bts, &keys); err != nil {
+		return nil, fmt.Errorf("invalid trusted keys file %s: %w", path, err)
+	}
+
+	for namespace, ks := range keys {
+		for i, k := range ks {
+			// compare keys without their comments
+			fields := strings.Fields(k)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid trusted keys file %s: key %d of %s is malformed", path, i, namespace)
+			}
+
+			ks[i] = fields[0] + " " + fields[1]
+		}
+	}
+
+	return keys, nil
+}
+
+// pruneRegistryTokens forgets expired tokens and the nonces of token
+// requests too old to be accepted again
+func pruneRegistryTokens(now time.Time) {
+	registryTokens.Range(func(k, v any) bool {
+		if now.After(v.(registryToken).expires) {
+			registryTokens.Delete(k)
+		}
+		return true
+	})
+
+	registryNonces.Range(func(k, v any) bool {
+		if now.Sub(v.(time.Time)).Abs() > maxTokenRequestAge {
+			registryNonces.Delete(k)
+		}
+		return true
+	})
+}
+
+// registryAuthorize checks the request has a token to push to repo, the
+// repository of the model n. Otherwise it responds with a challenge to get
+// a token from registryIssueToken.
+func registryAuthorize(c *gin.Context, repo string, n model.Name) bool {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		if v, ok := registryTokens.Load(token); ok && time.Now().Before(v.(registryToken).expires) {
+			keys, err := readTrustedKeys(envconfig.RegistryPushKeys())
+			if err != nil {
+				registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+				return false
+			}
+
+			if slices.Contains(slices.Concat(keys[n.Host+"/"+n.Namespace], keys[n.Namespace]), v.(registryToken).key) {
+				return true
+			}
+
+			registryError(c, http.StatusForbidden, "DENIED", fmt.Sprintf("not allowed to push to %s", repo))
+			return false
+		}
+	}
+
+	realm := registryBaseURL().JoinPath("/v2/token")
+	c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="ollama",scope="repository:%s:push"`, realm, repo))
+	registryError(c, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	return false
+}
+
+func registryManifest(c *gin.Context, repo, ref string) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		if !envconfig.RegistryPush() {
+			registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "pushing is disabled")
+			return
+		}
+
+		registryPutManifest(c, repo, ref)
+		return
+	default:
+		registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+		return
+	}
+
+	m, err := registryFindManifest(repo, ref)
+	if errors.Is(err, os.ErrNotExist) {
+		registryError(c, http.StatusNotFound, "MANIFEST_UNKNOWN", fmt.Sprintf("manifest %s:%s not found", repo, ref))
+		return
+	} else if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	bts, err := os.ReadFile(m.filepath)
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	c.Header("Docker-Content-Digest", "sha256:"+m.digest)
+	c.Data(http.StatusOK, cmp.Or(m.MediaType, manifestMediaType), bts)
+}
+
+// registryFindManifest returns the manifest of repo with ref, a tag or a
+// manifest digest
+func registryFindManifest(repo, ref string) (*Manifest, error) {
+	digest, ok := strings.CutPrefix(ref, "sha256:")
+	if !ok {
+		n, ok := registryName(repo, ref)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		return ParseNamedManifest(n)
+	}
+
+	n, ok := registryName(repo, "latest")
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	ms, err := Manifests()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, m := range ms {
+		if strings.EqualFold(name.Host, n.Host) && strings.EqualFold(name.Namespace, n.Namespace) && strings.EqualFold(name.Model, n.Model) && m.digest == digest {
+			return m, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func registryPutManifest(c *gin.Context, repo, tag string) {
+	n, ok := registryName(repo, tag)
+	if !ok {
+		registryError(c, http.StatusBadRequest, "NAME_INVALID", fmt.Sprintf("invalid model name %s:%s", repo, tag))
+		return
+	}
+
+	if !registryAuthorize(c, repo, n) {
+		return
+	}
+
+	bts, err := io.ReadAll(io.LimitReader(c.Request.Body, maxManifestSize+1))
+	if err != nil {
+		registryError(c, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	} else if len(bts) > maxManifestSize {
+		registryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", "manifest is too large")
+		return
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(bts, &m); err != nil {
+		registryError(c, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+
+	for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			registryError(c, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+			return
+		}
+
+		if fi, err := os.Stat(p); err != nil || fi.Size() != layer.Size {
+			registryError(c, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", fmt.Sprintf("blob %s is unknown to registry", layer.Digest))
+			return
+		}
+	}
+
+	if err := checkNameExists(n); err != nil {
+		registryError(c, http.StatusBadRequest, "NAME_INVALID", err.Error())
+		return
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	p := filepath.Join(manifests, n.Filepath())
+
+	// pushing the same manifest again isn't an overwrite
+	if existing, err := os.ReadFile(p); err == nil && !bytes.Equal(existing, bts) && !envconfig.RegistryOverwrite() {
+		registryError(c, http.StatusConflict, "DENIED", fmt.Sprintf("%s already exists", n.DisplayShortest()))
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	if err := os.WriteFile(p, bts, 0o644); err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(bts))
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("Location", registryURL(c, repo, "manifests", digest))
+	c.Status(http.StatusCreated)
+}
+
+func registryBlob(c *gin.Context, repo, digest string) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+		return
+	}
+
+	if _, ok := registryName(repo, "latest"); !ok {
+		registryError(c, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		registryError(c, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		registryError(c, http.StatusNotFound, "BLOB_UNKNOWN", fmt.Sprintf("blob %s is unknown to registry", digest))
+		return
+	} else if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("Content-Type", "application/octet-stream")
+
+	// ServeContent handles range requests, which downloads use to fetch
+	// parts of a blob in parallel
+	http.ServeContent(c.Writer, c.Request, "", fi.ModTime(), f)
+}
+
+// registryUploadBlob handles the requests of a blob upload: POST starts an
+// upload, PATCH uploads a chunk, PUT completes it and DELETE cancels it
+func registryUploadBlob(c *gin.Context, repo, id string) {
+	if !envconfig.RegistryPush() {
+		registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "pushing is disabled")
+		return
+	}
+
+	n, ok := registryName(repo, "latest")
+	if !ok {
+		registryError(c, http.StatusBadRequest, "NAME_INVALID", "invalid repository name")
+		return
+	}
+
+	if !registryAuthorize(c, repo, n) {
+		return
+	}
+
+	if id == "" {
+		if c.Request.Method != http.MethodPost {
+			registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+			return
+		}
+
+		registryStartUpload(c, repo)
+		return
+	}
+
+	v, ok := registryUploads.Load(id)
+	if !ok {
+		registryError(c, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+
+	u := v.(*registryUpload)
+	if !u.acquire() {
+		registryError(c, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+	defer u.release()
+
+	switch c.Request.Method {
+	case http.MethodPatch:
+		if err := u.write(c); err != nil {
+			registryUploadError(c, err)
+			return
+		}
+
+		u.mu.Lock()
+		size := u.size
+		u.mu.Unlock()
+
+		c.Header("Location", registryURL(c, repo, "blobs", "uploads", id))
+		c.Header("Docker-Upload-UUID", id)
+		c.Header("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+		c.Status(http.StatusAccepted)
+	case http.MethodPut:
+		// the final request may carry the last chunk
+		if err := u.write(c); err != nil {
+			registryUploadError(c, err)
+			return
+		}
+
+		digest := c.Query("digest")
+		err := u.commit(digest)
+		u.close()
+		if err != nil {
+			registryError(c, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+			return
+		}
+
+		c.Header("Docker-Content-Digest", digest)
+		c.Header("Location", registryURL(c, repo, "blobs", digest))
+		c.Status(http.StatusCreated)
+	case http.MethodDelete:
+		u.close()
+		c.Status(http.StatusNoContent)
+	default:
+		registryError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+	}
+}
+
+func registryStartUpload(c *gin.Context, repo string) {
+	// blobs are shared by all repositories, so mounting a blob only needs it
+	// to exist
+	if digest := c.Query("mount"); digest != "" {
+		if p, err := GetBlobsPath(digest); err == nil {
+			if _, err := os.Stat(p); err == nil {
+				c.Header("Docker-Content-Digest", digest)
+				c.Header("Location", registryURL(c, repo, "blobs", digest))
+				c.Status(http.StatusCreated)
+				return
+			}
+		}
+	}
+
+	blobs, err := GetBlobsPath("")
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	if registryUploadCount.Add(1) > maxRegistryUploads {
+		registryUploadCount.Add(-1)
+		registryError(c, http.StatusTooManyRequests, "TOOMANYREQUESTS", "too many blob uploads in progress")
+		return
+	}
+
+	// uploads don't have valid digests as names, so incomplete uploads are
+	// pruned when the server starts
+	f, err := os.CreateTemp(blobs, "sha256-upload-")
+	if err != nil {
+		registryUploadCount.Add(-1)
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	id := uuid.New().String()
+	u := &registryUpload{id: id, file: f}
+	u.timer = time.AfterFunc(registryUploadTimeout, u.expire)
+	registryUploads.Store(id, u)
+
+	c.Header("Location", registryURL(c, repo, "blobs", "uploads", id))
+	c.Header("Docker-Upload-UUID", id)
+	c.Header("Range", "0-0")
+	c.Status(http.StatusAccepted)
+}
+
+// registryUploadError aborts a request that failed to write to an upload
+func registryUploadError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errUploadTooLarge) || errors.As(err, &maxBytesErr) {
+		registryError(c, http.StatusRequestEntityTooLarge, "SIZE_INVALID", err.Error())
+		return
+	}
+
+	registryError(c, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+}
+
+// acquire marks the upload in use, so it doesn't expire while a request is
+// writing to it. It returns false if the upload is closed.
+func (u *registryUpload) acquire() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return false
+	}
+
+	u.active++
+	u.timer.Stop()
+	return true
+}
+
+// release marks a request done with the upload and restarts its idle timer
+func (u *registryUpload) release() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.active--
+	if u.active == 0 && !u.closed {
+		u.timer.Reset(registryUploadTimeout)
+	}
+}
+
+// expire cancels the upload if it's still idle
+func (u *registryUpload) expire() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.active == 0 {
+		u.closeLocked()
+	}
+}
+
+// close cancels the upload and removes its file, which commit has already
+// moved if the upload completed
+func (u *registryUpload) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closeLocked()
+}
+
+func (u *registryUpload) closeLocked() {
+	if u.closed {
+		return
+	}
+
+	u.closed = true
+	u.timer.Stop()
+	registryUploads.Delete(u.id)
+	registryUploadCount.Add(-1)
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
+
+// write writes the request body to the upload at the start of the request's
+// Content-Range, or after the data uploaded so far if it has none
+func (u *registryUpload) write(c *gin.Context) error {
+	u.mu.Lock()
+	offset := u.size
+	u.mu.Unlock()
+
+	if cr := c.GetHeader("Content-Range"); cr != "" {
+		start, _, ok := strings.Cut(strings.TrimPrefix(cr, "bytes="), "-")
+		if !ok {
+			return fmt.Errorf("invalid Content-Range %q", cr)
+		}
+
+		var err error
+		offset, err = strconv.ParseInt(start, 10, 64)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("invalid Content-Range %q", cr)
+		}
+	}
+
+	if offset > maxRegistryBlobSize {
+		return errUploadTooLarge
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxRegistryChunkSize)
+	n, err := io.Copy(io.NewOffsetWriter(u.file, offset), io.LimitReader(body, maxRegistryBlobSize-offset+1))
+	if err != nil {
+		return err
+	} else if offset+n > maxRegistryBlobSize {
+		return errUploadTooLarge
+	}
+
+	if c.Request.ContentLength >= 0 && n != c.Request.ContentLength {
+		return fmt.Errorf("expected %d bytes, got %d", c.Request.ContentLength, n)
+	}
+
+	u.mu.Lock()
+	u.size = max(u.size, offset+n)
+	u.mu.Unlock()
+	return nil
+}
+
+// commit verifies the upload's digest and moves it into the blob store. The
+// upload is closed afterwards either way.
+func (u *registryUpload) commit(digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	sha256sum := sha256.New()
+	if _, err := io.Copy(sha256sum, u.file); err != nil {
+		return err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); got != digest {
+		return fmt.Errorf("digest mismatch, expected %s, got %s", digest, got)
+	}
+
+	if err := u.file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(u.file.Name(), p)
+}
@@ -0,0 +1,476 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/auth"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// newTestKey writes a new ollama key to dir and returns its public key
+func newTestKey(t *testing.T, dir string) string {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, ".ollama"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".ollama", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+}
+
+// writePushKeys allows keys to push to the registry
+func writePushKeys(t *testing.T, keys map[string][]string) {
+	t.Helper()
+	bts, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(t.TempDir(), "push_keys.json")
+	if err := os.WriteFile(p, bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_REGISTRY_PUSH_KEYS", p)
+}
+
+func TestRegistry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRY", "1")
+	t.Setenv("OLLAMA_REGISTRY_PUSH", "1")
+
+	writePushKeys(t, map[string][]string{"library": {newTestKey(t, home)}})
+
+	var s Server
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s\nTEMPLATE {{ .Prompt }}", createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := os.ReadFile(m.filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+	t.Setenv("OLLAMA_REGISTRY_URL", srv.URL)
+
+	do := func(t *testing.T, method, url string, header http.Header, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	expectStatus := func(t *testing.T, resp *http.Response, status int) {
+		t.Helper()
+		if resp.StatusCode != status {
+			bts, _ := io.ReadAll(resp.Body)
+			t.Fatalf("expected status %d, got %d: %s", status, resp.StatusCode, bts)
+		}
+	}
+
+	t.Run("version check", func(t *testing.T) {
+		resp := do(t, http.MethodGet, srv.URL+"/v2/", nil, nil)
+		expectStatus(t, resp, http.StatusOK)
+
+		if got := resp.Header.Get("Docker-Distribution-API-Version"); got != "registry/2.0" {
+			t.Errorf("expected registry/2.0, got %q", got)
+		}
+	})
+
+	t.Run("manifest", func(t *testing.T) {
+		for _, ref := range []string{"latest", "sha256:" + m.digest} {
+			resp := do(t, http.MethodGet, srv.URL+"/v2/library/test/manifests/"+ref, nil, nil)
+			expectStatus(t, resp, http.StatusOK)
+
+			bts, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(bts, manifest) {
+				t.Errorf("expected manifest %s, got %s", manifest, bts)
+			}
+
+			if got := resp.Header.Get("Docker-Content-Digest"); got != "sha256:"+m.digest {
+				t.Errorf("expected digest sha256:%s, got %s", m.digest, got)
+			}
+		}
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		resp := do(t, http.MethodGet, srv.URL+"/v2/library/missing/manifests/latest", nil, nil)
+		expectStatus(t, resp, http.StatusNotFound)
+
+		var errs struct {
+			Errors []struct{ Code string }
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(errs.Errors) != 1 || errs.Errors[0].Code != "MANIFEST_UNKNOWN" {
+			t.Errorf("unexpected errors %+v", errs)
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		layer := m.Layers[0]
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blob, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := do(t, http.MethodHead, srv.URL+"/v2/library/test/blobs/"+layer.Digest, nil, nil)
+		expectStatus(t, resp, http.StatusOK)
+
+		if resp.ContentLength != layer.Size {
+			t.Errorf("expected size %d, got %d", layer.Size, resp.ContentLength)
+		}
+
+		resp = do(t, http.MethodGet, srv.URL+"/v2/library/test/blobs/"+layer.Digest, http.Header{"Range": {"bytes=4-9"}}, nil)
+		expectStatus(t, resp, http.StatusPartialContent)
+
+		bts, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(bts, blob[4:10]); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	// token gets a token to push the way pushes do, after the registry
+	// challenges an unauthorized request
+	token := func(t *testing.T) string {
+		t.Helper()
+		resp := do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/", nil, nil)
+		expectStatus(t, resp, http.StatusUnauthorized)
+
+		token, err := getAuthorizationToken(context.TODO(), parseRegistryChallenge(resp.Header.Get("WWW-Authenticate")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token
+	}
+
+	pushToken := token(t)
+	push := func(header http.Header) http.Header {
+		header = header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+
+		header.Set("Authorization", "Bearer "+pushToken)
+		return header
+	}
+
+	t.Run("push", func(t *testing.T) {
+		blob := []byte("a blob pushed in two chunks")
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+		resp := do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/", push(nil), nil)
+		expectStatus(t, resp, http.StatusAccepted)
+		location := resp.Header.Get("Location")
+
+		// chunks are uploaded out of order, as uploadBlob does in parallel
+		resp = do(t, http.MethodPatch, location, push(http.Header{"Content-Range": {"10-26"}}), blob[10:])
+		expectStatus(t, resp, http.StatusAccepted)
+
+		resp = do(t, http.MethodPatch, location, push(http.Header{"Content-Range": {"0-9"}}), blob[:10])
+		expectStatus(t, resp, http.StatusAccepted)
+		location = resp.Header.Get("Location")
+
+		resp = do(t, http.MethodPut, location+"?digest="+digest, push(nil), nil)
+		expectStatus(t, resp, http.StatusCreated)
+
+		resp = do(t, http.MethodHead, srv.URL+"/v2/library/pushed/blobs/"+digest, nil, nil)
+		expectStatus(t, resp, http.StatusOK)
+
+		// existing blobs are mounted rather than uploaded
+		resp = do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/?mount="+digest+"&from=library/test", push(nil), nil)
+		expectStatus(t, resp, http.StatusCreated)
+
+		pushed := Manifest{
+			SchemaVersion: 2,
+			MediaType:     manifestMediaType,
+			Config:        m.Config,
+			Layers:        append(m.Layers, Layer{MediaType: "application/vnd.ollama.image.license", Digest: digest, Size: int64(len(blob))}),
+		}
+
+		bts, err := json.Marshal(pushed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp = do(t, http.MethodPut, srv.URL+"/v2/library/pushed/manifests/latest", push(nil), bts)
+		expectStatus(t, resp, http.StatusCreated)
+
+		got, err := ParseNamedManifest(model.ParseName("pushed"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := fmt.Sprintf("%x", sha256.Sum256(bts)); got.digest != want {
+			t.Errorf("expected digest %s, got %s", want, got.digest)
+		}
+	})
+
+	t.Run("push digest mismatch", func(t *testing.T) {
+		resp := do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/", push(nil), nil)
+		expectStatus(t, resp, http.StatusAccepted)
+
+		resp = do(t, http.MethodPut, resp.Header.Get("Location")+"?digest=sha256:"+m.digest, push(nil), []byte("not the manifest"))
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("push missing blob", func(t *testing.T) {
+		bts, err := json.Marshal(Manifest{
+			SchemaVersion: 2,
+			MediaType:     manifestMediaType,
+			Config:        m.Config,
+			Layers:        []Layer{{MediaType: "application/vnd.ollama.image.model", Digest: "sha256:" + m.digest, Size: 1}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := do(t, http.MethodPut, srv.URL+"/v2/library/broken/manifests/latest", push(nil), bts)
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("push existing", func(t *testing.T) {
+		// pushing the same manifest again is allowed
+		resp := do(t, http.MethodPut, srv.URL+"/v2/library/test/manifests/latest", push(nil), manifest)
+		expectStatus(t, resp, http.StatusCreated)
+
+		pushed, err := ParseNamedManifest(model.ParseName("pushed"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bts, err := os.ReadFile(pushed.filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp = do(t, http.MethodPut, srv.URL+"/v2/library/test/manifests/latest", push(nil), bts)
+		expectStatus(t, resp, http.StatusConflict)
+
+		t.Setenv("OLLAMA_REGISTRY_OVERWRITE", "1")
+		resp = do(t, http.MethodPut, srv.URL+"/v2/library/test/manifests/latest", push(nil), bts)
+		expectStatus(t, resp, http.StatusCreated)
+	})
+
+	t.Run("push unauthorized", func(t *testing.T) {
+		resp := do(t, http.MethodPut, srv.URL+"/v2/library/test/manifests/latest", nil, manifest)
+		expectStatus(t, resp, http.StatusUnauthorized)
+
+		resp = do(t, http.MethodPut, srv.URL+"/v2/library/test/manifests/latest", http.Header{"Authorization": {"Bearer invalid"}}, manifest)
+		expectStatus(t, resp, http.StatusUnauthorized)
+
+		// the token is only for namespaces its key may push to
+		resp = do(t, http.MethodPost, srv.URL+"/v2/models/test/blobs/uploads/", push(nil), nil)
+		expectStatus(t, resp, http.StatusForbidden)
+
+		resp = do(t, http.MethodPost, srv.URL+"/v2/registry.ollama.ai/library/test/blobs/uploads/", push(nil), nil)
+		expectStatus(t, resp, http.StatusAccepted)
+		do(t, http.MethodDelete, resp.Header.Get("Location"), push(nil), nil)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		resp := do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/", nil, nil)
+		expectStatus(t, resp, http.StatusUnauthorized)
+		challenge := parseRegistryChallenge(resp.Header.Get("WWW-Authenticate"))
+
+		u, err := challenge.URL()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sha256sum := sha256.Sum256(nil)
+		signature, err := auth.Sign(context.TODO(), []byte(fmt.Sprintf("%s,%s,%s", http.MethodGet, u, base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sha256sum[:]))))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp = do(t, http.MethodGet, u.String(), http.Header{"Authorization": {signature}}, nil)
+		expectStatus(t, resp, http.StatusOK)
+
+		// requests can't be replayed
+		resp = do(t, http.MethodGet, u.String(), http.Header{"Authorization": {signature}}, nil)
+		expectStatus(t, resp, http.StatusUnauthorized)
+
+		// or signed for other requests
+		q := u.Query()
+		q.Set("nonce", "other")
+		u.RawQuery = q.Encode()
+		resp = do(t, http.MethodGet, u.String(), http.Header{"Authorization": {signature}}, nil)
+		expectStatus(t, resp, http.StatusUnauthorized)
+
+		// or signed for another registry and sent with its host
+		other := *u
+		other.Host = "registry.example.com"
+		q.Set("nonce", "another")
+		other.RawQuery = q.Encode()
+		signature, err = auth.Sign(context.TODO(), []byte(fmt.Sprintf("%s,%s,%s", http.MethodGet, other.String(), base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sha256sum[:]))))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL+other.RequestURI(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = other.Host
+		req.Header.Set("Authorization", signature)
+
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		expectStatus(t, resp, http.StatusUnauthorized)
+
+		// keys that aren't allowed to push don't get tokens
+		writePushKeys(t, map[string][]string{"library": {newTestKey(t, t.TempDir())}})
+		if _, err := getAuthorizationToken(context.TODO(), challenge); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("upload too large", func(t *testing.T) {
+		resp := do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/", push(nil), nil)
+		expectStatus(t, resp, http.StatusAccepted)
+		location := resp.Header.Get("Location")
+		t.Cleanup(func() { do(t, http.MethodDelete, location, push(nil), nil) })
+
+		resp = do(t, http.MethodPatch, location, push(http.Header{"Content-Range": {fmt.Sprintf("%d-%d", int64(maxRegistryBlobSize), int64(maxRegistryBlobSize)+1)}}), []byte("ab"))
+		expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("upload expires", func(t *testing.T) {
+		timeout := registryUploadTimeout
+		registryUploadTimeout = 10 * time.Millisecond
+		t.Cleanup(func() { registryUploadTimeout = timeout })
+
+		resp := do(t, http.MethodPost, srv.URL+"/v2/library/pushed/blobs/uploads/", push(nil), nil)
+		expectStatus(t, resp, http.StatusAccepted)
+		id := resp.Header.Get("Docker-Upload-UUID")
+
+		v, ok := registryUploads.Load(id)
+		if !ok {
+			t.Fatal("expected upload")
+		}
+
+		// wait for the upload to expire
+		for _, ok := registryUploads.Load(id); ok; _, ok = registryUploads.Load(id) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if _, err := os.Stat(v.(*registryUpload).file.Name()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected upload to be removed, got %v", err)
+		}
+
+		resp = do(t, http.MethodPatch, resp.Header.Get("Location"), push(nil), []byte("late"))
+		expectStatus(t, resp, http.StatusNotFound)
+	})
+}
+
+func TestRegistryDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRY", "1")
+
+	var s Server
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Post(srv.URL+"/v2/library/test/blobs/uploads/", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected pushes to be rejected, got status %d", resp.StatusCode)
+	}
+}
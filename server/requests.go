@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader is the response header holding the ID assigned to
+// generate, chat and embed requests
+const requestIDHeader = "X-Request-Id"
+
+// trackRequest assigns the request an ID and returns a context that is
+// canceled when the request is canceled through DELETE /api/requests/:id.
+// The ID is set in the response header. done must be called once the
+// request is finished.
+func (s *Server) trackRequest(c *gin.Context) (id string, ctx context.Context, done func()) {
+	id = uuid.New().String()
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	s.requests.Store(id, cancel)
+	c.Header(requestIDHeader, id)
+
+	return id, ctx, func() {
+		s.requests.Delete(id)
+		cancel()
+	}
+}
+
+func (s *Server) CancelRequestHandler(c *gin.Context) {
+	id := c.Param("id")
+	cancel, ok := s.requests.LoadAndDelete(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("request %q not found", id)})
+		return
+	}
+
+	cancel.(context.CancelFunc)()
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+// blockingRunner sends a single response and then blocks until the request
+// is canceled
+type blockingRunner struct {
+	mockRunner
+}
+
+func (blockingRunner) Completion(ctx context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+	fn(llm.CompletionResponse{Content: "Hello"})
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCancelRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock blockingRunner
+	s := &Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			getGpuFn:      gpu.GetGPUInfo,
+			getCpuFn:      gpu.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+
+	t.Run("not found", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/api/requests/missing", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"test","prompt":"Hello!"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		id := resp.Header.Get(requestIDHeader)
+		if id == "" {
+			t.Fatal("expected request ID header")
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		if !scanner.Scan() {
+			t.Fatal("expected a response")
+		}
+
+		var first api.GenerateResponse
+		if err := json.Unmarshal(scanner.Bytes(), &first); err != nil {
+			t.Fatal(err)
+		}
+
+		if first.RequestID != id {
+			t.Errorf("expected request ID %q, got %q", id, first.RequestID)
+		}
+
+		base, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := api.NewClient(base, http.DefaultClient).CancelRequest(context.TODO(), id); err != nil {
+			t.Fatal(err)
+		}
+
+		if !scanner.Scan() {
+			t.Fatal("expected an error response")
+		}
+
+		if !strings.Contains(scanner.Text(), "context canceled") {
+			t.Errorf("expected context canceled, got %s", scanner.Text())
+		}
+
+		if scanner.Scan() {
+			t.Errorf("expected end of stream, got %s", scanner.Text())
+		}
+
+		if _, ok := s.requests.Load(id); ok {
+			t.Error("expected request to be removed")
+		}
+	})
+}
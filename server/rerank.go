@@ -0,0 +1,106 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func (s *Server) RerankHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.RerankRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Query == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	}
+
+	if req.TopN < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_n must be greater than 0"})
+		return
+	}
+
+	if len(req.Documents) == 0 {
+		c.JSON(http.StatusOK, api.RerankResponse{Model: req.Model, Results: []api.RerankResult{}})
+		return
+	}
+
+	_, ctx, done := s.trackRequest(c)
+	defer done()
+
+	r, m, opts, err := s.scheduleRunner(ctx, req.Model, []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	var scores []float32
+	if m.CheckCapabilities(CapabilityRerank) == nil {
+		scores, err = r.Rerank(ctx, req.Query, req.Documents)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// rerankers output logits, which are mapped to probabilities so
+		// scores are comparable across queries
+		for i, score := range scores {
+			scores[i] = float32(1 / (1 + math.Exp(-float64(score))))
+		}
+	} else {
+		embeddings, _, err := s.embedInput(ctx, r, m, opts, append([]string{req.Query}, req.Documents...), true, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// embeddings are normalized, so their dot product is their cosine
+		// similarity
+		scores = make([]float32, len(req.Documents))
+		for i, e := range embeddings[1:] {
+			scores[i] = dot(embeddings[0], e)
+		}
+	}
+
+	results := make([]api.RerankResult, len(scores))
+	for i, score := range scores {
+		results[i] = api.RerankResult{Index: i, RelevanceScore: score}
+		if req.ReturnDocuments {
+			results[i].Document = req.Documents[i]
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b api.RerankResult) int {
+		return cmp.Compare(b.RelevanceScore, a.RelevanceScore)
+	})
+
+	if req.TopN > 0 && req.TopN < len(results) {
+		results = results[:req.TopN]
+	}
+
+	c.JSON(http.StatusOK, api.RerankResponse{
+		Model:         req.Model,
+		Results:       results,
+		TotalDuration: time.Since(checkpointStart),
+		LoadDuration:  checkpointLoaded.Sub(checkpointStart),
+	})
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+// Rerank returns each document's score in scores
+func (m *mockRunner) Rerank(_ context.Context, _ string, documents []string) ([]float32, error) {
+	scores := make([]float32, len(documents))
+	for i, d := range documents {
+		scores[i] = m.scores[d]
+	}
+
+	return scores, nil
+}
+
+func TestRerank(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		embeddings: map[string][]float32{
+			"cats":    {1, 0, 0, 0},
+			"dogs":    {0, 1, 0, 0},
+			"kittens": {0.6, 0.8, 0, 0},
+			"birds":   {0, 0, 1, 0},
+		},
+		scores: map[string]float32{
+			"dogs":    -2,
+			"kittens": 3,
+			"birds":   0,
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      gpu.GetGPUInfo,
+			getCpuFn:      gpu.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	for name, kv := range map[string]llm.KV{
+		"embed": {
+			"general.architecture": "bert",
+			"bert.context_length":  uint32(512),
+			"bert.pooling_type":    uint32(1),
+		},
+		"reranker": {
+			"general.architecture": "bert",
+			"bert.context_length":  uint32(512),
+			"bert.pooling_type":    uint32(poolingTypeRank),
+		},
+	} {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Model:     name,
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, kv, nil)),
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+	}
+
+	documents := []string{"dogs", "kittens", "birds"}
+
+	sigmoid := func(x float64) float32 { return float32(1 / (1 + math.Exp(-x))) }
+
+	cases := []struct {
+		name string
+		req  api.RerankRequest
+		want []api.RerankResult
+	}{
+		{
+			name: "embedding model",
+			req:  api.RerankRequest{Model: "embed", Query: "cats", Documents: documents},
+			want: []api.RerankResult{
+				{Index: 1, RelevanceScore: 0.6},
+				{Index: 0, RelevanceScore: 0},
+				{Index: 2, RelevanceScore: 0},
+			},
+		},
+		{
+			name: "reranker model",
+			req:  api.RerankRequest{Model: "reranker", Query: "cats", Documents: documents},
+			want: []api.RerankResult{
+				{Index: 1, RelevanceScore: sigmoid(3)},
+				{Index: 2, RelevanceScore: 0.5},
+				{Index: 0, RelevanceScore: sigmoid(-2)},
+			},
+		},
+		{
+			name: "top n",
+			req:  api.RerankRequest{Model: "reranker", Query: "cats", Documents: documents, TopN: 1, ReturnDocuments: true},
+			want: []api.RerankResult{
+				{Index: 1, Document: "kittens", RelevanceScore: sigmoid(3)},
+			},
+		},
+		{
+			name: "no documents",
+			req:  api.RerankRequest{Model: "reranker", Query: "cats"},
+			want: []api.RerankResult{},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.RerankHandler, tt.req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp api.RerankResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(resp.Results, tt.want, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("missing query", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{Model: "reranker", Documents: documents})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"query is required"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{Model: "missing", Query: "cats", Documents: documents})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status 404, got %d", w.Code)
+		}
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,13 +20,14 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/sync/errgroup"
 
+	"github.com/ollama/ollama/anthropic"
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/gpu"
@@ -40,8 +43,17 @@ import (
 var mode string = gin.DebugMode
 
 type Server struct {
-	addr  net.Addr
-	sched *Scheduler
+	addr    net.Addr
+	sched   *Scheduler
+	metrics *metrics
+
+	// requests maps the IDs of in-flight requests to their cancel functions
+	requests sync.Map
+
+	// embedBatchers maps runners to the batchers of their embedding inputs
+	embedBatchers sync.Map
+
+	batches *batchWorker
 }
 
 func init() {
@@ -106,6 +118,9 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 	return runner.llama, model, &opts, nil
 }
 
+// maxTopLogprobs is the most alternatives returned for each token
+const maxTopLogprobs = 20
+
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 	var req api.GenerateRequest
@@ -117,20 +132,41 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
-	if req.Format != "" && req.Format != "json" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format must be empty or \"json\""})
+	priority, err := llm.ParsePriority(req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.TopLogprobs < 0 || req.TopLogprobs > maxTopLogprobs {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("top_logprobs must be between 0 and %d", maxTopLogprobs)})
+		return
+	}
+
+	if _, err := llm.FormatGrammar(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	} else if req.Raw && (req.Template != "" || req.System != "" || len(req.Context) > 0) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "raw mode does not support template, system, or context"})
 		return
+	} else if len(req.Tokens) > 0 && (req.Prompt != "" || req.Suffix != "" || req.Template != "" || req.System != "" || len(req.Context) > 0 || len(req.Images) > 0) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "tokens cannot be used with prompt, suffix, template, system, context, or images"})
+		return
 	}
 
+	// a prompt of tokens is sent as is, like a raw prompt
+	raw := req.Raw || len(req.Tokens) > 0
+
 	caps := []Capability{CapabilityCompletion}
 	if req.Suffix != "" {
 		caps = append(caps, CapabilityInsert)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, caps, req.Options, req.KeepAlive)
+	requestID, ctx, done := s.trackRequest(c)
+	defer done()
+	ctx = llm.WithPriority(ctx, priority)
+
+	r, m, opts, err := s.scheduleRunner(ctx, req.Model, caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
 		return
@@ -141,12 +177,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	checkpointLoaded := time.Now()
 
-	if req.Prompt == "" {
+	if req.Prompt == "" && len(req.Tokens) == 0 {
 		c.JSON(http.StatusOK, api.GenerateResponse{
 			Model:      req.Model,
 			CreatedAt:  time.Now().UTC(),
 			Done:       true,
 			DoneReason: "load",
+			RequestID:  requestID,
 		})
 		return
 	}
@@ -157,7 +194,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	}
 
 	prompt := req.Prompt
-	if !req.Raw {
+	if !raw {
 		tmpl := m.Template
 		if req.Template != "" {
 			tmpl, err = template.Parse(req.Template)
@@ -192,7 +229,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 		var b bytes.Buffer
 		if req.Context != nil {
-			s, err := r.Detokenize(c.Request.Context(), req.Context)
+			s, err := r.Detokenize(ctx, req.Context)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
@@ -208,25 +245,32 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		prompt = b.String()
 	}
 
-	slog.Debug("generate request", "prompt", prompt, "images", images)
+	slog.Debug("generate request", "prompt", prompt, "tokens", len(req.Tokens), "images", images)
 
 	ch := make(chan any)
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
 		var sb strings.Builder
+		var sent bool
 		defer close(ch)
-		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+		if err := r.Completion(ctx, llm.CompletionRequest{
+			Prompt:      prompt,
+			Tokens:      req.Tokens,
+			Images:      images,
+			Format:      req.Format,
+			Options:     opts,
+			Session:     req.Session,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
-				Model:      req.Model,
-				CreatedAt:  time.Now().UTC(),
-				Response:   cr.Content,
-				Done:       cr.Done,
-				DoneReason: cr.DoneReason,
+				Model:        req.Model,
+				CreatedAt:    time.Now().UTC(),
+				Response:     cr.Content,
+				Done:         cr.Done,
+				DoneReason:   cr.DoneReason,
+				StopSequence: cr.StopSequence,
+				Logprobs:     cr.Logprobs,
 				Metrics: api.Metrics{
 					PromptEvalCount:    cr.PromptEvalCount,
 					PromptEvalDuration: cr.PromptEvalDuration,
@@ -242,9 +286,10 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			if cr.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				s.metrics.observeTokens(req.Model, cr.PromptEvalCount, cr.EvalCount)
 
-				if !req.Raw {
-					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
+				if !raw {
+					tokens, err := r.Tokenize(ctx, prompt+sb.String())
 					if err != nil {
 						ch <- gin.H{"error": err.Error()}
 						return
@@ -253,6 +298,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				}
 			}
 
+			if !sent {
+				res.RequestID = requestID
+				sent = true
+			}
+
 			ch <- res
 		}); err != nil {
 			ch <- gin.H{"error": err.Error()}
@@ -261,11 +311,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	if req.Stream != nil && !*req.Stream {
 		var r api.GenerateResponse
+		var logprobs []api.Logprob
 		var sb strings.Builder
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
 				sb.WriteString(t.Response)
+				logprobs = append(logprobs, t.Logprobs...)
 				r = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -282,6 +334,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		r.Response = sb.String()
+		r.RequestID = requestID
+		r.Logprobs = logprobs
 		c.JSON(http.StatusOK, r)
 		return
 	}
@@ -302,6 +356,38 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	priority, err := llm.ParsePriority(req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Dimensions < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dimensions must be greater than 0"})
+		return
+	}
+
+	switch req.EncodingFormat {
+	case "", "float", "base64":
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid encoding_format %q, must be float or base64", req.EncodingFormat)})
+		return
+	}
+
+	if req.Chunking != nil {
+		switch req.Chunking.Pooling {
+		case "", embedPoolingNone, embedPoolingMean, embedPoolingMax:
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid chunking pooling %q, must be none, mean or max", req.Chunking.Pooling)})
+			return
+		}
+
+		if req.Chunking.Size < 0 || req.Chunking.Overlap < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "chunking size and overlap must not be negative"})
+			return
+		}
+	}
+
 	truncate := true
 
 	if req.Truncate != nil && !*req.Truncate {
@@ -333,7 +419,11 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	_, ctx, done := s.trackRequest(c)
+	defer done()
+	ctx = llm.WithPriority(ctx, priority)
+
+	r, m, opts, err := s.scheduleRunner(ctx, req.Model, []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -341,67 +431,284 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 
 	checkpointLoaded := time.Now()
 
-	kvData, err := getKVData(m.ModelPath, false)
-	if err != nil {
+	var embeddings [][]float32
+	var chunks [][]api.EmbedChunk
+	var count int
+	if req.Chunking != nil {
+		chunks, count, err = s.embedChunks(ctx, r, m, opts, input, req.Chunking.Size, req.Chunking.Overlap, req.Dimensions)
+		if err == nil && req.Chunking.Pooling != embedPoolingNone {
+			embeddings = make([][]float32, len(chunks))
+			for i := range chunks {
+				embeddings[i] = poolEmbeddings(chunks[i], req.Chunking.Pooling)
+			}
+
+			chunks = nil
+		}
+	} else {
+		embeddings, count, err = s.embedInput(ctx, r, m, opts, input, truncate, req.Dimensions)
+	}
+
+	if errors.Is(err, errInputTooLong) || errors.Is(err, errDimensionsTooLarge) || errors.Is(err, errOverlapTooLarge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	resp := api.EmbedResponse{
+		Model:           req.Model,
+		Embeddings:      embeddings,
+		Chunks:          chunks,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+	}
+
+	if req.EncodingFormat == "base64" {
+		if embeddings != nil {
+			resp.Embeddings = nil
+			resp.EmbeddingsBase64 = make([]string, len(embeddings))
+			for i, embedding := range embeddings {
+				resp.EmbeddingsBase64[i] = encodeEmbedding(embedding)
+			}
+		}
+
+		for _, chunks := range resp.Chunks {
+			for i := range chunks {
+				chunks[i].EmbeddingBase64 = encodeEmbedding(chunks[i].Embedding)
+				chunks[i].Embedding = nil
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+var (
+	errInputTooLong       = errors.New("input length exceeds maximum context length")
+	errDimensionsTooLarge = errors.New("dimensions exceeds the model's embedding length")
+	errOverlapTooLarge    = errors.New("chunk overlap must be less than the chunk size")
+)
+
+const (
+	embedPoolingNone = "none"
+	embedPoolingMean = "mean"
+	embedPoolingMax  = "max"
+)
+
+// embedInput embeds each input with the runner r of model m, batched with
+// the inputs of concurrent requests. Inputs longer than the context window
+// are truncated, or return errInputTooLong if truncate is false. Embeddings
+// are truncated to dimensions, if set, and normalized. It also returns the
+// number of input tokens.
+func (s *Server) embedInput(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, input []string, truncate bool, dimensions int) ([][]float32, int, error) {
+	kvData, err := getKVData(m.ModelPath, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	var count int
-	for i, s := range input {
-		tokens, err := r.Tokenize(c.Request.Context(), s)
+	counts := make([]int, len(input))
+	for i, text := range input {
+		tokens, err := r.Tokenize(ctx, text)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, 0, err
 		}
 
 		ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
 		if len(tokens) > ctxLen {
 			if !truncate {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
-				return
+				return nil, 0, errInputTooLong
 			}
 
 			tokens = tokens[:ctxLen]
-			s, err = r.Detokenize(c.Request.Context(), tokens)
+			text, err = r.Detokenize(ctx, tokens)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+				return nil, 0, err
 			}
 		}
 
 		count += len(tokens)
+		counts[i] = len(tokens)
+
+		input[i] = text
+	}
+
+	embeddings, err := s.embedTexts(ctx, r, opts, input, counts, dimensions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return embeddings, count, nil
+}
+
+// embedSpecialTokens is the room left in the context window for the special
+// tokens, such as BOS or CLS and SEP, the runner adds to each input
+const embedSpecialTokens = 2
+
+// embedChunks splits each input into chunks of up to size tokens, which
+// share overlap tokens with the previous chunk, and embeds each chunk as in
+// embedInput. A size of zero or more than the context window, less room for
+// special tokens, is limited to it. It also returns the number of chunk
+// tokens.
+func (s *Server) embedChunks(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, input []string, size, overlap, dimensions int) ([][]api.EmbedChunk, int, error) {
+	kvData, err := getKVData(m.ModelPath, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctxLen := max(min(opts.NumCtx, int(kvData.ContextLength()))-embedSpecialTokens, 1)
+	if size <= 0 || size > ctxLen {
+		size = ctxLen
+	}
 
-		input[i] = s
+	if overlap >= size {
+		return nil, 0, fmt.Errorf("%w of %d", errOverlapTooLarge, size)
 	}
 
-	var g errgroup.Group
-	embeddings := make([][]float32, len(input))
+	var count int
+	var texts []string
+	var counts []int
+	chunks := make([][]api.EmbedChunk, len(input))
 	for i, text := range input {
-		g.Go(func() error {
-			embedding, err := r.Embedding(c.Request.Context(), text)
+		tokens, err := r.Tokenize(ctx, text)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(tokens) <= size {
+			chunks[i] = []api.EmbedChunk{{Start: 0, End: len(text)}}
+			texts = append(texts, text)
+			counts = append(counts, len(tokens))
+			count += len(tokens)
+			continue
+		}
+
+		// offset is the byte offset of the chunk in the input. Offsets are
+		// derived from the lengths of the detokenized chunks, which may
+		// differ slightly from the input if the tokenizer normalizes it, so
+		// they're kept within the input.
+		var offset int
+		for start := 0; ; start += size - overlap {
+			end := min(start+size, len(tokens))
+
+			// the chunk is re-tokenized by the runner, which may not split it
+			// on the same boundaries, so its token count is an estimate
+			chunk, err := r.Detokenize(ctx, tokens[start:end])
 			if err != nil {
-				return err
+				return nil, 0, err
 			}
-			embeddings[i] = normalize(embedding)
-			return nil
-		})
+
+			chunks[i] = append(chunks[i], api.EmbedChunk{Start: offset, End: min(offset+len(chunk), len(text))})
+			texts = append(texts, chunk)
+			counts = append(counts, end-start)
+			count += end - start
+
+			if end == len(tokens) {
+				break
+			}
+
+			// the next chunk starts after the tokens this one doesn't share
+			// with it
+			if overlap == 0 {
+				offset += len(chunk)
+			} else {
+				prefix, err := r.Detokenize(ctx, tokens[start:start+size-overlap])
+				if err != nil {
+					return nil, 0, err
+				}
+
+				offset += len(prefix)
+			}
+
+			offset = min(offset, len(text))
+		}
+	}
+
+	embeddings, err := s.embedTexts(ctx, r, opts, texts, counts, dimensions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range chunks {
+		for j := range chunks[i] {
+			chunks[i][j].Embedding, embeddings = embeddings[0], embeddings[1:]
+		}
 	}
 
-	if err := g.Wait(); err != nil {
+	return chunks, count, nil
+}
+
+// embedTexts embeds texts, which have the given number of tokens, then
+// truncates the embeddings to dimensions, if set, and normalizes them
+func (s *Server) embedTexts(ctx context.Context, r llm.LlamaServer, opts *api.Options, texts []string, counts []int, dimensions int) ([][]float32, error) {
+	embeddings, err := s.embedBatcher(r, opts).embed(ctx, texts, counts)
+	if err != nil {
 		slog.Error("embedding generation failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("failed to generate embeddings: %v", err)})
-		return
+		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
 	}
 
-	resp := api.EmbedResponse{
-		Model:           req.Model,
-		Embeddings:      embeddings,
-		TotalDuration:   time.Since(checkpointStart),
-		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
-		PromptEvalCount: count,
+	for i, embedding := range embeddings {
+		if dimensions > 0 {
+			if dimensions > len(embedding) {
+				return nil, fmt.Errorf("%w of %d", errDimensionsTooLarge, len(embedding))
+			}
+
+			embedding = embedding[:dimensions]
+		}
+
+		embeddings[i] = normalize(embedding)
 	}
-	c.JSON(http.StatusOK, resp)
+
+	return embeddings, nil
+}
+
+// poolEmbeddings combines the embeddings of an input's chunks into a single
+// normalized embedding, either by their mean or their element-wise maximum
+func poolEmbeddings(chunks []api.EmbedChunk, pooling string) []float32 {
+	pooled := slices.Clone(chunks[0].Embedding)
+	for _, chunk := range chunks[1:] {
+		for i, v := range chunk.Embedding {
+			switch pooling {
+			case embedPoolingMax:
+				pooled[i] = max(pooled[i], v)
+			default:
+				pooled[i] += v
+			}
+		}
+	}
+
+	// the mean is proportional to the sum, so normalizing the sum is enough
+	return normalize(pooled)
+}
+
+// encodeEmbedding packs vec as little-endian float32 values and encodes them
+// as base64, the format of OpenAI's base64 embeddings
+func encodeEmbedding(vec []float32) string {
+	b := make([]byte, 0, 4*len(vec))
+	for _, v := range vec {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// decodeEmbedding decodes an embedding encoded with encodeEmbedding
+func decodeEmbedding(s string) ([]float32, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	} else if len(b)%4 != 0 {
+		return nil, errors.New("invalid embedding length")
+	}
+
+	vec := make([]float32, len(b)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+
+	return vec, nil
 }
 
 func normalize(vec []float32) []float32 {
@@ -431,7 +738,10 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	_, ctx, done := s.trackRequest(c)
+	defer done()
+
+	r, _, _, err := s.scheduleRunner(ctx, req.Model, []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -443,7 +753,7 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
+	embedding, err := r.Embedding(ctx, req.Prompt)
 	if err != nil {
 		slog.Info(fmt.Sprintf("embedding generation failed: %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate embedding"})
@@ -461,6 +771,72 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+func (s *Server) TokenizeHandler(c *gin.Context) {
+	var req api.TokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	// an empty request loads the model
+	if req.Text == "" {
+		c.JSON(http.StatusOK, api.TokenizeResponse{Model: req.Model, Tokens: []int{}})
+		return
+	}
+
+	tokens, err := r.Tokenize(c.Request.Context(), req.Text)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []int{}
+	}
+
+	c.JSON(http.StatusOK, api.TokenizeResponse{Model: req.Model, Tokens: tokens})
+}
+
+func (s *Server) DetokenizeHandler(c *gin.Context) {
+	var req api.DetokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	// an empty request loads the model
+	if len(req.Tokens) == 0 {
+		c.JSON(http.StatusOK, api.DetokenizeResponse{Model: req.Model})
+		return
+	}
+
+	text, err := r.Detokenize(c.Request.Context(), req.Tokens)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.DetokenizeResponse{Model: req.Model, Text: text})
+}
+
 func (s *Server) PullModelHandler(c *gin.Context) {
 	var req api.PullRequest
 	err := c.ShouldBindJSON(&req)
@@ -547,7 +923,7 @@ func (s *Server) PushModelHandler(c *gin.Context) {
 		ctx, cancel := context.WithCancel(c.Request.Context())
 		defer cancel()
 
-		if err := PushModel(ctx, model, regOpts, fn); err != nil {
+		if err := PushModel(ctx, model, regOpts, req.Sign, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
 		}
 	}()
@@ -561,6 +937,9 @@ func (s *Server) PushModelHandler(c *gin.Context) {
 }
 
 func checkNameExists(name model.Name) error {
+	// names are compared by their tags, so pinned names exist as their tag
+	name.Digest = ""
+
 	names, err := Manifests()
 	if err != nil {
 		return err
@@ -586,7 +965,7 @@ func (s *Server) CreateModelHandler(c *gin.Context) {
 	}
 
 	name := model.ParseName(cmp.Or(r.Model, r.Name))
-	if !name.IsValid() {
+	if !name.IsValid() || name.Digest != "" {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
 		return
 	}
@@ -678,6 +1057,39 @@ func (s *Server) DeleteModelHandler(c *gin.Context) {
 	}
 }
 
+func (s *Server) DeleteSessionHandler(c *gin.Context) {
+	var req api.DeleteSessionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Session == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "session is required"})
+		return
+	}
+
+	m, err := GetModel(req.Model)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	p := filepath.Join(envconfig.Sessions(), llm.SessionName(m.ModelPath, m.AdapterPaths, m.ProjectorPaths, req.Session))
+	if err := os.Remove(p); errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", req.Session)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
 func (s *Server) ShowModelHandler(c *gin.Context) {
 	var req api.ShowRequest
 	err := c.ShouldBindJSON(&req)
@@ -894,7 +1306,7 @@ func (s *Server) CopyModelHandler(c *gin.Context) {
 	}
 
 	dst := model.ParseName(r.Destination)
-	if !dst.IsValid() {
+	if !dst.IsValid() || dst.Digest != "" {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("destination %q is invalid", r.Destination)})
 		return
 	}
@@ -1072,11 +1484,13 @@ func (s *Server) GenerateRoutes() http.Handler {
 		config.AllowHeaders = append(config.AllowHeaders, "x-stainless-"+prop)
 	}
 	config.AllowOrigins = envconfig.Origins()
+	config.ExposeHeaders = []string{requestIDHeader}
 
 	r := gin.Default()
 	r.Use(
 		cors.New(config),
 		allowedHostsMiddleware(s.addr),
+		s.metrics.middleware,
 	)
 
 	r.POST("/api/pull", s.PullModelHandler)
@@ -1084,21 +1498,65 @@ func (s *Server) GenerateRoutes() http.Handler {
 	r.POST("/api/chat", s.ChatHandler)
 	r.POST("/api/embed", s.EmbedHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.POST("/api/rerank", s.RerankHandler)
+	r.POST("/api/tokenize", s.TokenizeHandler)
+	r.POST("/api/detokenize", s.DetokenizeHandler)
 	r.POST("/api/create", s.CreateModelHandler)
 	r.POST("/api/push", s.PushModelHandler)
+	r.POST("/api/export", s.ExportModelHandler)
+	r.POST("/api/import", s.ImportModelHandler)
 	r.POST("/api/copy", s.CopyModelHandler)
 	r.DELETE("/api/delete", s.DeleteModelHandler)
+	r.DELETE("/api/sessions", s.DeleteSessionHandler)
 	r.POST("/api/show", s.ShowModelHandler)
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
 	r.GET("/api/ps", s.ProcessHandler)
+	r.POST("/api/load", s.LoadHandler)
+	r.POST("/api/unload", s.UnloadHandler)
+	r.GET("/api/conversations", s.ListConversationsHandler)
+	r.POST("/api/conversations", s.CreateConversationHandler)
+	r.GET("/api/conversations/:id", s.GetConversationHandler)
+	r.DELETE("/api/conversations/:id", s.DeleteConversationHandler)
+	r.POST("/api/conversations/:id/fork", s.ForkConversationHandler)
+
+	r.GET("/api/collections", s.ListCollectionsHandler)
+	r.POST("/api/collections", s.CreateCollectionHandler)
+	r.GET("/api/collections/:name", s.GetCollectionHandler)
+	r.DELETE("/api/collections/:name", s.DeleteCollectionHandler)
+	r.POST("/api/collections/:name/documents", s.AddDocumentsHandler)
+	r.DELETE("/api/collections/:name/documents/:id", s.DeleteDocumentHandler)
+	r.POST("/api/collections/:name/query", s.QueryCollectionHandler)
+	r.DELETE("/api/requests/:id", s.CancelRequestHandler)
+	r.GET("/metrics", s.MetricsHandler)
 
 	// Compatibility endpoints
 	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/messages", anthropic.MessagesMiddleware(), s.ChatHandler)
 	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
 	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
 	r.GET("/v1/models", openai.ListMiddleware(), s.ListModelsHandler)
 	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowModelHandler)
+	r.POST("/v1/files", s.UploadFileHandler)
+	r.GET("/v1/files", s.ListFilesHandler)
+	r.GET("/v1/files/:id", s.GetFileHandler)
+	r.GET("/v1/files/:id/content", s.GetFileContentHandler)
+	r.DELETE("/v1/files/:id", s.DeleteFileHandler)
+	r.POST("/v1/batches", s.CreateBatchHandler)
+	r.GET("/v1/batches", s.ListBatchesHandler)
+	r.GET("/v1/batches/:id", s.GetBatchHandler)
+	r.POST("/v1/batches/:id/cancel", s.CancelBatchHandler)
+
+	if envconfig.Registry() {
+		r.GET("/v2/*path", s.RegistryHandler)
+		r.HEAD("/v2/*path", s.RegistryHandler)
+		if envconfig.RegistryPush() {
+			r.POST("/v2/*path", s.RegistryHandler)
+			r.PATCH("/v2/*path", s.RegistryHandler)
+			r.PUT("/v2/*path", s.RegistryHandler)
+			r.DELETE("/v2/*path", s.RegistryHandler)
+		}
+	}
 
 	for _, method := range []string{http.MethodGet, http.MethodHead} {
 		r.Handle(method, "/", func(c *gin.Context) {
@@ -1160,10 +1618,16 @@ func Serve(ln net.Listener) error {
 		}
 	}
 
+	preload, err := readPreload(envconfig.Preload())
+	if err != nil {
+		return err
+	}
+
 	ctx, done := context.WithCancel(context.Background())
 	schedCtx, schedDone := context.WithCancel(ctx)
 	sched := InitScheduler(schedCtx)
-	s := &Server{addr: ln.Addr(), sched: sched}
+	s := &Server{addr: ln.Addr(), sched: sched, metrics: newMetrics()}
+	s.batches = newBatchWorker(s.batchRoutes())
 
 	http.Handle("/", s.GenerateRoutes())
 
@@ -1203,6 +1667,9 @@ func Serve(ln net.Listener) error {
 	gpus := gpu.GetGPUInfo()
 	gpus.LogDetails()
 
+	go s.preload(schedCtx, preload)
+	go s.batches.run(schedCtx)
+
 	err = srvr.Serve(ln)
 	// If server is closed from the signal handler, wait for the ctx to be done
 	// otherwise error out quickly
@@ -1271,36 +1738,18 @@ func (s *Server) ProcessHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
 	for _, v := range s.sched.loaded {
-		model := v.model
-		modelDetails := api.ModelDetails{
-			Format:            model.Config.ModelFormat,
-			Family:            model.Config.ModelFamily,
-			Families:          model.Config.ModelFamilies,
-			ParameterSize:     model.Config.ModelType,
-			QuantizationLevel: model.Config.FileType,
-		}
-
-		mr := api.ProcessModelResponse{
-			Model:     model.ShortName,
-			Name:      model.ShortName,
-			Size:      int64(v.estimatedTotal),
-			SizeVRAM:  int64(v.estimatedVRAM),
-			Digest:    model.Digest,
-			Details:   modelDetails,
-			ExpiresAt: v.expiresAt,
-		}
-		// The scheduler waits to set expiresAt, so if a model is loading it's
-		// possible that it will be set to the unix epoch. For those cases, just
-		// calculate the time w/ the sessionDuration instead.
-		var epoch time.Time
-		if v.expiresAt == epoch {
-			mr.ExpiresAt = time.Now().Add(v.sessionDuration)
-		}
-
-		models = append(models, mr)
+		models = append(models, processModel(v))
 	}
 
 	slices.SortStableFunc(models, func(i, j api.ProcessModelResponse) int {
+		// pinned models never expire so are listed first
+		if i.Pinned != j.Pinned {
+			if i.Pinned {
+				return -1
+			}
+			return 1
+		}
+
 		// longest duration remaining listed first
 		return cmp.Compare(j.ExpiresAt.Unix(), i.ExpiresAt.Unix())
 	})
@@ -1308,6 +1757,150 @@ func (s *Server) ProcessHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, api.ProcessResponse{Models: models})
 }
 
+func processModel(v *runnerRef) api.ProcessModelResponse {
+	model := v.model
+	modelDetails := api.ModelDetails{
+		Format:            model.Config.ModelFormat,
+		Family:            model.Config.ModelFamily,
+		Families:          model.Config.ModelFamilies,
+		ParameterSize:     model.Config.ModelType,
+		QuantizationLevel: model.Config.FileType,
+	}
+
+	mr := api.ProcessModelResponse{
+		Model:     model.ShortName,
+		Name:      model.ShortName,
+		Size:      int64(v.estimatedTotal),
+		SizeVRAM:  int64(v.estimatedVRAM),
+		Digest:    model.Digest,
+		Details:   modelDetails,
+		ExpiresAt: v.expiresAt,
+	}
+	// The scheduler waits to set expiresAt, so if a model is loading it's
+	// possible that it will be set to the unix epoch. For those cases, just
+	// calculate the time w/ the sessionDuration instead.
+	var epoch time.Time
+	if v.expiresAt == epoch {
+		mr.ExpiresAt = time.Now().Add(v.sessionDuration)
+	}
+
+	// Pinned models don't expire
+	if v.pinned {
+		mr.ExpiresAt = epoch
+		mr.Pinned = true
+	}
+
+	return mr
+}
+
+// load loads the model without generating a response
+func (s *Server) load(ctx context.Context, req api.LoadRequest) (*api.LoadResponse, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model %w", errRequired)
+	}
+
+	model, err := GetModel(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := modelOptions(model, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	// the runner is released as soon as it's loaded, which starts its
+	// keep alive timer
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.sched.loadedMu.Lock()
+	loaded := s.sched.loaded[model.ModelPath]
+	s.sched.loadedMu.Unlock()
+
+	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, req.KeepAlive)
+	var runner *runnerRef
+	select {
+	case runner = <-runnerCh:
+	case err = <-errCh:
+		return nil, err
+	}
+
+	if req.Pin {
+		s.sched.pin(runner)
+	}
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	resp := api.LoadResponse{ProcessModelResponse: processModel(runner)}
+	// the load duration is only reported if the model wasn't loaded already
+	if runner != loaded {
+		resp.LoadDuration = runner.loadDuration
+	}
+	if !runner.pinned {
+		resp.ExpiresAt = time.Now().Add(runner.sessionDuration)
+	}
+
+	return &resp, nil
+}
+
+func (s *Server) LoadHandler(c *gin.Context) {
+	var req api.LoadRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.load(c.Request.Context(), req)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) UnloadHandler(c *gin.Context) {
+	var req api.UnloadRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	model, err := GetModel(req.Model)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	runner, err := s.sched.unload(c.Request.Context(), model)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	} else if runner == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q is not loaded", req.Model)})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.UnloadResponse{
+		Name:     model.ShortName,
+		Model:    model.ShortName,
+		Size:     int64(runner.estimatedTotal),
+		SizeVRAM: int64(runner.estimatedVRAM),
+	})
+}
+
 func (s *Server) ChatHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 
@@ -1320,12 +1913,75 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	priority, err := llm.ParsePriority(req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.TopLogprobs < 0 || req.TopLogprobs > maxTopLogprobs {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("top_logprobs must be between 0 and %d", maxTopLogprobs)})
+		return
+	}
+
+	if _, err := llm.FormatGrammar(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch req.ToolChoice {
+	case "", "auto", "required":
+	case "none":
+		req.Tools = nil
+	default:
+		i := slices.IndexFunc(req.Tools, func(t api.Tool) bool { return t.Function.Name == req.ToolChoice })
+		if i < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tool_choice %q is not one of the tools", req.ToolChoice)})
+			return
+		}
+
+		req.Tools = req.Tools[i : i+1]
+	}
+
+	// the model is made to call a tool by constraining its output
+	forceTools := req.ToolChoice != "" && req.ToolChoice != "auto" && req.ToolChoice != "none"
+	if forceTools && len(req.Tools) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "tool_choice requires tools"})
+		return
+	} else if forceTools && len(req.Format) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format cannot be used when tool_choice requires a tool call"})
+		return
+	}
+
+	var conv *api.Conversation
+	endTurn := func() {}
+	defer func() { endTurn() }()
+	if req.ConversationID != "" {
+		var ok bool
+		endTurn, ok = startTurn(req.ConversationID)
+		if !ok {
+			endTurn = func() {}
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("conversation %q has a turn in progress", req.ConversationID)})
+			return
+		}
+
+		conv, err = readConversation(req.ConversationID)
+		if err != nil {
+			handleConversationError(c, req.ConversationID, err)
+			return
+		}
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, caps, req.Options, req.KeepAlive)
+	requestID, ctx, done := s.trackRequest(c)
+	defer done()
+	ctx = llm.WithPriority(ctx, priority)
+
+	r, m, opts, err := s.scheduleRunner(ctx, req.Model, caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
 		return
@@ -1343,16 +1999,22 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			Message:    api.Message{Role: "assistant"},
 			Done:       true,
 			DoneReason: "load",
+			RequestID:  requestID,
 		})
 		return
 	}
 
-	msgs := append(m.Messages, req.Messages...)
-	if req.Messages[0].Role != "system" && m.System != "" {
+	history := req.Messages
+	if conv != nil {
+		history = append(slices.Clone(conv.Messages), req.Messages...)
+	}
+
+	msgs := append(m.Messages, history...)
+	if history[0].Role != "system" && m.System != "" {
 		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
 	}
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
+	prompt, images, err := chatPrompt(ctx, m, r.Tokenize, opts, msgs, req.Tools)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1360,21 +2022,51 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 	slog.Debug("chat request", "images", len(images), "prompt", prompt)
 
+	format := req.Format
+	if forceTools {
+		format, err = m.toolCallSchema(req.Tools)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// tool calls in streamed responses are parsed as they are generated
+	var parser *toolCallParser
+	if len(req.Tools) > 0 && (req.Stream == nil || *req.Stream) {
+		parser, _ = m.newToolCallParser()
+	}
+
+	// the turn lasts until the reply is saved, even if the client has gone
+	end := endTurn
+	endTurn = func() {}
+
 	ch := make(chan any)
 	go func() {
+		var sent bool
+		var reply api.Message
+		var logprobs []api.Logprob
+		defer end()
 		defer close(ch)
-		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+		if err := r.Completion(ctx, llm.CompletionRequest{
+			Prompt:      prompt,
+			Images:      images,
+			Format:      format,
+			Options:     opts,
+			Session:     req.Session,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
 		}, func(r llm.CompletionResponse) {
+			// log probabilities of held back content are sent with the next chunk
+			logprobs = append(logprobs, r.Logprobs...)
+
 			res := api.ChatResponse{
-				Model:      req.Model,
-				CreatedAt:  time.Now().UTC(),
-				Message:    api.Message{Role: "assistant", Content: r.Content},
-				Done:       r.Done,
-				DoneReason: r.DoneReason,
+				Model:        req.Model,
+				CreatedAt:    time.Now().UTC(),
+				Message:      api.Message{Role: "assistant", Content: r.Content},
+				Done:         r.Done,
+				DoneReason:   r.DoneReason,
+				StopSequence: r.StopSequence,
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
@@ -1386,8 +2078,53 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			if r.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				s.metrics.observeTokens(req.Model, r.PromptEvalCount, r.EvalCount)
+			}
+
+			if parser != nil {
+				res.Message.Content, res.Message.ToolCalls = parser.add(r.Content)
+				if r.Done {
+					content, toolCalls := parser.done()
+					res.Message.Content += content
+					res.Message.ToolCalls = append(res.Message.ToolCalls, toolCalls...)
+				}
+
+				// hold back chunks until there is something to send
+				if res.Message.Content == "" && len(res.Message.ToolCalls) == 0 && !r.Done {
+					return
+				}
+			}
+
+			if conv != nil {
+				reply.Content += res.Message.Content
+				reply.ToolCalls = append(reply.ToolCalls, res.Message.ToolCalls...)
+
+				// save the reply before the final response so the next
+				// request sees it
+				if r.Done {
+					reply.Role = "assistant"
+					if parser == nil && len(req.Tools) > 0 {
+						if toolCalls, ok := m.parseToolCalls(reply.Content); ok {
+							reply.ToolCalls = toolCalls
+							reply.Content = ""
+						}
+					}
+
+					err := appendConversation(conv.ID, append(slices.Clone(req.Messages), reply)...)
+					end()
+					if err != nil {
+						ch <- gin.H{"error": err.Error()}
+						return
+					}
+				}
+			}
+
+			if !sent {
+				res.RequestID = requestID
+				sent = true
 			}
 
+			res.Logprobs, logprobs = logprobs, nil
 			ch <- res
 		}); err != nil {
 			ch <- gin.H{"error": err.Error()}
@@ -1396,11 +2133,13 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 	if req.Stream != nil && !*req.Stream {
 		var resp api.ChatResponse
+		var logprobs []api.Logprob
 		var sb strings.Builder
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.ChatResponse:
 				sb.WriteString(t.Message.Content)
+				logprobs = append(logprobs, t.Logprobs...)
 				resp = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -1417,6 +2156,8 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}
 
 		resp.Message.Content = sb.String()
+		resp.RequestID = requestID
+		resp.Logprobs = logprobs
 
 		if len(req.Tools) > 0 {
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
@@ -1438,7 +2179,7 @@ func handleScheduleError(c *gin.Context, name string, err error) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	case errors.Is(err, context.Canceled):
 		c.JSON(499, gin.H{"error": "request canceled"})
-	case errors.Is(err, ErrMaxQueue):
+	case errors.Is(err, ErrMaxQueue), errors.Is(err, ErrPinned):
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 	case errors.Is(err, os.ErrNotExist):
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found, try pulling it first", name)})
@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/types/model"
 )
 
@@ -109,3 +111,57 @@ func TestDeleteDuplicateLayers(t *testing.T) {
 
 	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{})
 }
+
+func TestDeleteSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Name:      "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, nil, nil)),
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := filepath.Join(p, "sessions")
+	if err := os.MkdirAll(sessions, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	name := llm.SessionName(m.ModelPath, m.AdapterPaths, m.ProjectorPaths, "document-1")
+	if err := os.WriteFile(filepath.Join(sessions, name), []byte("cache"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = createRequest(t, s.DeleteSessionHandler, api.DeleteSessionRequest{Model: "test", Session: "document-1"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(sessions, "*"), []string{})
+
+	w = createRequest(t, s.DeleteSessionHandler, api.DeleteSessionRequest{Model: "test", Session: "document-1"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.DeleteSessionHandler, api.DeleteSessionRequest{Model: "missing", Session: "document-1"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.DeleteSessionHandler, api.DeleteSessionRequest{Model: "test"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code 400, actual %d", w.Code)
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+// Embedding returns the input's embedding in embeddings, or [3 4 0 12]
+// with a norm of 13 if it has none
+func (m *mockRunner) Embedding(_ context.Context, s string) ([]float32, error) {
+	if e, ok := m.embeddings[s]; ok {
+		return slices.Clone(e), nil
+	}
+
+	return []float32{3, 4, 0, 12}, nil
+}
+
+func (m *mockRunner) EmbeddingBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		embeddings[i], _ = m.Embedding(ctx, input)
+	}
+
+	return embeddings, nil
+}
+
+func TestEmbed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		// the chunks of "a b c d e f" with a size of 3 and an overlap of 1
+		embeddings: map[string][]float32{
+			"<0> <1> <2>": {1, 0, 0, 0},
+			"<2> <3> <4>": {0.6, 0.8, 0, 0},
+			"<4> <5>":     {0, 1, 0, 0},
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      gpu.GetGPUInfo,
+			getCpuFn:      gpu.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+			"general.architecture": "llama",
+			"llama.context_length": uint32(8192),
+		}, nil)),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	t.Run("embed", func(t *testing.T) {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: []string{"Hello world", "Hi"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []float32{3.0 / 13, 4.0 / 13, 0, 12.0 / 13}
+		if diff := cmp.Diff(resp.Embeddings, [][]float32{want, want}, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if resp.PromptEvalCount != 3 {
+			t.Errorf("expected 3 prompt tokens, got %d", resp.PromptEvalCount)
+		}
+	})
+
+	t.Run("dimensions", func(t *testing.T) {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: "Hello", Dimensions: 2})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Embeddings, [][]float32{{0.6, 0.8}}, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("dimensions too large", func(t *testing.T) {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: "Hello", Dimensions: 8})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"dimensions exceeds the model's embedding length of 4"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("base64", func(t *testing.T) {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: "Hello", Dimensions: 2, EncodingFormat: "base64"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Embeddings != nil || len(resp.EmbeddingsBase64) != 1 {
+			t.Fatalf("expected 1 base64 embedding, got %+v", resp)
+		}
+
+		b, err := base64.StdEncoding.DecodeString(resp.EmbeddingsBase64[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []float32
+		for i := 0; i < len(b); i += 4 {
+			got = append(got, math.Float32frombits(binary.LittleEndian.Uint32(b[i:])))
+		}
+
+		if diff := cmp.Diff(got, []float32{0.6, 0.8}, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid encoding format", func(t *testing.T) {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: "Hello", EncodingFormat: "binary"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid encoding_format \"binary\", must be float or base64"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("chunking", func(t *testing.T) {
+		chunking := api.EmbedChunking{Size: 3, Overlap: 1, Pooling: "none"}
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: []string{"a b c d e f", "Hi"}, Chunking: &chunking})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		// the mock's tokens don't detokenize to the input, offsets are
+		// covered by TestEmbedChunks
+		if diff := cmp.Diff(resp.Chunks, [][]api.EmbedChunk{
+			{
+				{Embedding: []float32{1, 0, 0, 0}},
+				{Embedding: []float32{0.6, 0.8, 0, 0}},
+				{Embedding: []float32{0, 1, 0, 0}},
+			},
+			{
+				{Embedding: []float32{3.0 / 13, 4.0 / 13, 0, 12.0 / 13}},
+			},
+		}, cmpopts.EquateApprox(0, 1e-6), cmpopts.IgnoreFields(api.EmbedChunk{}, "Start", "End")); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if resp.Embeddings != nil {
+			t.Errorf("expected no embeddings, got %v", resp.Embeddings)
+		}
+
+		if resp.PromptEvalCount != 9 {
+			t.Errorf("expected 9 prompt tokens, got %d", resp.PromptEvalCount)
+		}
+	})
+
+	t.Run("chunking pooling", func(t *testing.T) {
+		cases := []struct {
+			pooling string
+			want    []float32
+		}{
+			{"", []float32{float32(1.6 / math.Hypot(1.6, 1.8)), float32(1.8 / math.Hypot(1.6, 1.8)), 0, 0}},
+			{"mean", []float32{float32(1.6 / math.Hypot(1.6, 1.8)), float32(1.8 / math.Hypot(1.6, 1.8)), 0, 0}},
+			{"max", []float32{1 / math.Sqrt2, 1 / math.Sqrt2, 0, 0}},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.pooling, func(t *testing.T) {
+				chunking := api.EmbedChunking{Size: 3, Overlap: 1, Pooling: tt.pooling}
+				w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: "a b c d e f", Chunking: &chunking})
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+
+				var resp api.EmbedResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(resp.Embeddings, [][]float32{tt.want}, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+					t.Errorf("mismatch (-got +want):\n%s", diff)
+				}
+
+				if resp.Chunks != nil {
+					t.Errorf("expected no chunks, got %v", resp.Chunks)
+				}
+			})
+		}
+	})
+
+	t.Run("chunking errors", func(t *testing.T) {
+		cases := []struct {
+			chunking api.EmbedChunking
+			err      string
+		}{
+			{api.EmbedChunking{Pooling: "sum"}, `{"error":"invalid chunking pooling \"sum\", must be none, mean or max"}`},
+			{api.EmbedChunking{Size: -1}, `{"error":"chunking size and overlap must not be negative"}`},
+			{api.EmbedChunking{Size: 3, Overlap: 3}, `{"error":"chunk overlap must be less than the chunk size of 3"}`},
+		}
+
+		for _, tt := range cases {
+			w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "test", Input: "a b c d e f", Chunking: &tt.chunking})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), tt.err); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		}
+	})
+}
+
+// runeRunner tokenizes inputs into their runes. Each input's embedding is its
+// length in bytes.
+type runeRunner struct {
+	llm.LlamaServer
+}
+
+func (runeRunner) NumParallel() int {
+	return 1
+}
+
+func (runeRunner) Tokenize(_ context.Context, s string) (tokens []int, err error) {
+	for _, r := range s {
+		tokens = append(tokens, int(r))
+	}
+
+	return
+}
+
+func (runeRunner) Detokenize(_ context.Context, tokens []int) (string, error) {
+	runes := make([]rune, len(tokens))
+	for i, t := range tokens {
+		runes[i] = rune(t)
+	}
+
+	return string(runes), nil
+}
+
+func (runeRunner) EmbeddingBatch(_ context.Context, inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		embeddings[i] = []float32{float32(len(input))}
+	}
+
+	return embeddings, nil
+}
+
+func TestEmbedChunks(t *testing.T) {
+	var s Server
+	m := &Model{ModelPath: createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"llama.context_length": uint32(8),
+	}, nil)}
+
+	opts := api.DefaultOptions()
+
+	cases := []struct {
+		name          string
+		size, overlap int
+		want          []string
+	}{
+		{"overlap", 4, 1, []string{"héll", "lo w", "wörl", "ld"}},
+		{"no overlap", 4, 0, []string{"héll", "o wö", "rld"}},
+		// room is left for special tokens in the context length of 8
+		{"default size", 0, 0, []string{"héllo ", "wörld"}},
+		{"size over the limit", 20, 0, []string{"héllo ", "wörld"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "héllo wörld"
+			chunks, _, err := s.embedChunks(context.Background(), runeRunner{}, m, &opts, []string{input}, tt.size, tt.overlap, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the offsets are bytes in the input
+			var got []string
+			for _, c := range chunks[0] {
+				got = append(got, input[c.Start:c.End])
+			}
+
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -25,6 +25,9 @@ type mockRunner struct {
 	// CompletionRequest is only valid until the next call to Completion
 	llm.CompletionRequest
 	llm.CompletionResponse
+
+	embeddings map[string][]float32
+	scores     map[string]float32
 }
 
 func (m *mockRunner) Completion(_ context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
@@ -33,6 +36,10 @@ func (m *mockRunner) Completion(_ context.Context, r llm.CompletionRequest, fn f
 	return nil
 }
 
+func (mockRunner) NumParallel() int {
+	return 1
+}
+
 func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error) {
 	for range strings.Fields(s) {
 		tokens = append(tokens, len(tokens))
@@ -143,6 +150,36 @@ func TestGenerateChat(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid priority", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Priority: "urgent",
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid priority \"urgent\": must be one of \"high\", \"normal\" or \"low\""}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:  "test",
+			Format: json.RawMessage(`{"type":"date"}`),
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid JSON schema: unsupported type \"date\""}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
 	t.Run("missing capabilities chat", func(t *testing.T) {
 		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
 			Model: "bert",
@@ -270,6 +307,197 @@ func TestGenerateChat(t *testing.T) {
 		checkChatResponse(t, w.Body, "test", "Hi!")
 	})
 
+	t.Run("messages with logprobs", func(t *testing.T) {
+		mock.CompletionResponse.Logprobs = []api.Logprob{{Token: "Hi!", Logprob: -0.25, TopLogprobs: []api.TokenLogprob{{Token: "Hi!", Logprob: -0.25}}}}
+		defer func() { mock.CompletionResponse.Logprobs = nil }()
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:       "test",
+			Messages:    []api.Message{{Role: "user", Content: "Hello!"}},
+			TopLogprobs: 1,
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if mock.CompletionRequest.TopLogprobs != 1 {
+			t.Errorf("expected 1 top logprob, got %d", mock.CompletionRequest.TopLogprobs)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Logprobs, mock.CompletionResponse.Logprobs); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("tool choice", func(t *testing.T) {
+		var tool api.Tool
+		tool.Type = "function"
+		tool.Function.Name = "get_current_weather"
+
+		// tools are removed so the model doesn't need to support them
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test",
+			Messages:   []api.Message{{Role: "user", Content: "Hello!"}},
+			Tools:      api.Tools{tool},
+			ToolChoice: "none",
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test",
+			Messages:   []api.Message{{Role: "user", Content: "Hello!"}},
+			Tools:      api.Tools{tool},
+			ToolChoice: "get_weather",
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"tool_choice \"get_weather\" is not one of the tools"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test",
+			Messages:   []api.Message{{Role: "user", Content: "Hello!"}},
+			ToolChoice: "required",
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("messages with conversation", func(t *testing.T) {
+		w := createRequest(t, s.CreateConversationHandler, api.CreateConversationRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}, {Role: "assistant", Content: "Hi!"}},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var conv api.Conversation
+		if err := json.NewDecoder(w.Body).Decode(&conv); err != nil {
+			t.Fatal(err)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:          "test",
+			Messages:       []api.Message{{Role: "user", Content: "How are you?"}},
+			ConversationID: conv.ID,
+			Stream:         &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "User: Hello! Assistant: Hi! User: How are you? "); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		// the prompt cache is only saved if a session is set
+		if diff := cmp.Diff(mock.CompletionRequest.Session, ""); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		checkChatResponse(t, w.Body, "test", "Hi!")
+
+		stored, err := readConversation(conv.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(stored.Messages, []api.Message{
+			{Role: "user", Content: "Hello!"},
+			{Role: "assistant", Content: "Hi!"},
+			{Role: "user", Content: "How are you?"},
+			{Role: "assistant", Content: "Hi!"},
+		}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("conversation turn in progress", func(t *testing.T) {
+		w := createRequest(t, s.CreateConversationHandler, api.CreateConversationRequest{Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var conv api.Conversation
+		if err := json.NewDecoder(w.Body).Decode(&conv); err != nil {
+			t.Fatal(err)
+		}
+
+		end, ok := startTurn(conv.ID)
+		if !ok {
+			t.Fatal("expected turn to start")
+		}
+
+		req := api.ChatRequest{
+			Model:          "test",
+			Messages:       []api.Message{{Role: "user", Content: "Hello!"}},
+			ConversationID: conv.ID,
+			Stream:         &stream,
+		}
+
+		w = createRequest(t, s.ChatHandler, req)
+		if w.Code != http.StatusConflict {
+			t.Errorf("expected status 409, got %d", w.Code)
+		}
+
+		// the rejected turn doesn't end the one in progress
+		if _, ok := startTurn(conv.ID); ok {
+			t.Error("expected turn to still be in progress")
+		}
+
+		end()
+		w = createRequest(t, s.ChatHandler, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		// and the turn is over once the response is sent
+		end, ok = startTurn(conv.ID)
+		if !ok {
+			t.Error("expected turn to have ended")
+		} else {
+			end()
+		}
+	})
+
+	t.Run("missing conversation", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:          "test",
+			Messages:       []api.Message{{Role: "user", Content: "Hello!"}},
+			ConversationID: "00000000-0000-0000-0000-000000000000",
+		})
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"conversation \"00000000-0000-0000-0000-000000000000\" not found"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
 	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
 		Model:     "test-system",
 		Modelfile: "FROM test\nSYSTEM You are a helpful assistant.",
@@ -441,6 +669,36 @@ func TestGenerate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid priority", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:    "test",
+			Priority: "urgent",
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid priority \"urgent\": must be one of \"high\", \"normal\" or \"low\""}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Format: json.RawMessage(`{"type":"date"}`),
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid JSON schema: unsupported type \"date\""}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
 	t.Run("missing capabilities generate", func(t *testing.T) {
 		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
 			Model: "bert",
@@ -583,6 +841,118 @@ func TestGenerate(t *testing.T) {
 		checkGenerateResponse(t, w.Body, "test", "Hi!")
 	})
 
+	t.Run("prompt with session", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello!",
+			Session: "document-1",
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Session, "document-1"); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		checkGenerateResponse(t, w.Body, "test", "Hi!")
+	})
+
+	t.Run("prompt with logprobs", func(t *testing.T) {
+		mock.CompletionResponse.Logprobs = []api.Logprob{{Token: "Hi!", Logprob: -0.25}}
+		defer func() { mock.CompletionResponse.Logprobs = nil }()
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:    "test",
+			Prompt:   "Hello!",
+			Logprobs: true,
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !mock.CompletionRequest.Logprobs {
+			t.Error("expected logprobs to be requested")
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Logprobs, mock.CompletionResponse.Logprobs); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("prompt with tokens", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Tokens: []int{9906, 0},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, ""); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Tokens, []int{9906, 0}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		// like raw prompts, token prompts do not return a context
+		if resp.Response != "Hi!" || resp.Context != nil {
+			t.Errorf("unexpected response %+v", resp)
+		}
+	})
+
+	t.Run("tokens with prompt", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			Tokens: []int{9906},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"tokens cannot be used with prompt, suffix, template, system, context, or images"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid top logprobs", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			TopLogprobs: 100,
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"top_logprobs must be between 0 and 20"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
 	w = createRequest(t, s.CreateModelHandler, api.CreateRequest{
 		Model:     "test-system",
 		Modelfile: "FROM test\nSYSTEM You are a helpful assistant.",
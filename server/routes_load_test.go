@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/gpu"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestLoadUnload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_NUM_PARALLEL", "1")
+
+	mock := &mockLlm{estimatedVRAM: 1024, estimatedTotal: 2048}
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn: func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+				return mock, nil
+			},
+			getGpuFn:     getCpuFn,
+			getCpuFn:     getCpuFn,
+			reschedDelay: 250 * time.Millisecond,
+		},
+	}
+	s.sched.loadFn = s.sched.load
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s.sched.Run(ctx)
+
+	w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+		Model:     "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)),
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.LoadHandler, api.LoadRequest{})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"model is required"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("model not found", func(t *testing.T) {
+		w := createRequest(t, s.LoadHandler, api.LoadRequest{Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("unload not loaded", func(t *testing.T) {
+		w := createRequest(t, s.UnloadHandler, api.UnloadRequest{Model: "test"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"model \"test\" is not loaded"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("load", func(t *testing.T) {
+		w := createRequest(t, s.LoadHandler, api.LoadRequest{
+			Model:     "test",
+			KeepAlive: &api.Duration{Duration: time.Hour},
+			Options:   map[string]any{"num_ctx": 1024},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.LoadResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Name != "test:latest" || resp.Size != 2048 || resp.SizeVRAM != 1024 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+
+		if until := time.Until(resp.ExpiresAt); until < 59*time.Minute || until > time.Hour {
+			t.Errorf("expected model to expire in an hour, got %s", until)
+		}
+
+		if resp.LoadDuration <= 0 {
+			t.Errorf("expected a load duration, got %s", resp.LoadDuration)
+		}
+
+		s.sched.loadedMu.Lock()
+		defer s.sched.loadedMu.Unlock()
+		if len(s.sched.loaded) != 1 {
+			t.Fatalf("expected 1 loaded model, got %d", len(s.sched.loaded))
+		}
+
+		for _, runner := range s.sched.loaded {
+			if runner.NumCtx != 1024 {
+				t.Errorf("expected num_ctx 1024, got %d", runner.NumCtx)
+			}
+		}
+	})
+
+	t.Run("already loaded", func(t *testing.T) {
+		w := createRequest(t, s.LoadHandler, api.LoadRequest{
+			Model:     "test",
+			KeepAlive: &api.Duration{Duration: time.Hour},
+			Options:   map[string]any{"num_ctx": 1024},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.LoadResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.LoadDuration != 0 {
+			t.Errorf("expected no load duration, got %s", resp.LoadDuration)
+		}
+	})
+
+	t.Run("unload", func(t *testing.T) {
+		w := createRequest(t, s.UnloadHandler, api.UnloadRequest{Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.UnloadResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp, api.UnloadResponse{Name: "test:latest", Model: "test:latest", Size: 2048, SizeVRAM: 1024}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		s.sched.loadedMu.Lock()
+		defer s.sched.loadedMu.Unlock()
+		if len(s.sched.loaded) != 0 {
+			t.Errorf("expected no loaded models, got %d", len(s.sched.loaded))
+		}
+
+		if !mock.closeCalled {
+			t.Error("expected runner to be closed")
+		}
+	})
+
+	t.Run("pin", func(t *testing.T) {
+		w := createRequest(t, s.LoadHandler, api.LoadRequest{
+			Model:     "test",
+			KeepAlive: &api.Duration{},
+			Pin:       true,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.LoadResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if !resp.Pinned || !resp.ExpiresAt.IsZero() {
+			t.Errorf("expected pinned model that doesn't expire, got %+v", resp)
+		}
+
+		// a zero keep alive would have unloaded the model by now
+		time.Sleep(50 * time.Millisecond)
+
+		w = createRequest(t, s.ProcessHandler, nil)
+		var ps api.ProcessResponse
+		if err := json.NewDecoder(w.Body).Decode(&ps); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ps.Models) != 1 || !ps.Models[0].Pinned {
+			t.Fatalf("expected pinned model, got %+v", ps.Models)
+		}
+
+		w = createRequest(t, s.UnloadHandler, api.UnloadRequest{Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		s.sched.loadedMu.Lock()
+		defer s.sched.loadedMu.Unlock()
+		if len(s.sched.loaded) != 0 || len(s.sched.pinned) != 0 {
+			t.Errorf("expected no loaded or pinned models, got %d loaded and %d pinned", len(s.sched.loaded), len(s.sched.pinned))
+		}
+	})
+}
@@ -30,10 +30,13 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+	priority        llm.Priority
+	queued          time.Time
 }
 
 type Scheduler struct {
 	pendingReqCh  chan *LlmRequest
+	queue         pendingQueue
 	finishedReqCh chan *LlmRequest
 	expiredCh     chan *runnerRef
 	unloadedCh    chan interface{}
@@ -41,6 +44,9 @@ type Scheduler struct {
 	loaded   map[string]*runnerRef
 	loadedMu sync.Mutex
 
+	// pinned holds the model paths of pinned models. It is guarded by loadedMu.
+	pinned map[string]bool
+
 	loadFn       func(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList, numParallel int)
 	newServerFn  func(gpus gpu.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
 	getGpuFn     func() gpu.GpuInfoList
@@ -60,6 +66,8 @@ var defaultParallel = 4
 
 var ErrMaxQueue = errors.New("server busy, please try again.  maximum pending requests exceeded")
 
+var ErrPinned = errors.New("unable to load model, all loaded models are pinned")
+
 func InitScheduler(ctx context.Context) *Scheduler {
 	maxQueue := envconfig.MaxQueue()
 	sched := &Scheduler{
@@ -90,6 +98,12 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		sessionDuration: sessionDuration,
 		successCh:       make(chan *runnerRef),
 		errCh:           make(chan error, 1),
+		priority:        llm.PriorityFromContext(c),
+	}
+
+	if s.queue.Len()+len(s.pendingReqCh) >= cap(s.pendingReqCh) {
+		req.errCh <- ErrMaxQueue
+		return req.successCh, req.errCh
 	}
 
 	select {
@@ -114,195 +128,257 @@ func (s *Scheduler) Run(ctx context.Context) {
 
 func (s *Scheduler) processPending(ctx context.Context) {
 	for {
-		select {
-		case <-ctx.Done():
+		if s.queue.Len() == 0 {
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case pending := <-s.pendingReqCh:
+				s.queue.push(pending)
+			case <-s.unloadedCh:
+				// An unload request when there are no pending request can be ignored
+				slog.Debug("ignoring unload event with no pending requests")
+				continue
+			}
+		} else if ctx.Err() != nil {
 			slog.Debug("shutting down scheduler pending loop")
 			return
-		case pending := <-s.pendingReqCh:
-			// Block other requests until we get this pending request running
-			pending.schedAttempts++
-			if pending.origNumCtx == 0 {
-				pending.origNumCtx = pending.opts.NumCtx
-			}
+		}
 
-			if pending.ctx.Err() != nil {
-				slog.Debug("pending request cancelled or timed out, skipping scheduling")
-				continue
-			}
-			numParallel := int(envconfig.NumParallel())
-			// TODO (jmorganca): multimodal models don't support parallel yet
-			// see https://github.com/ollama/ollama/issues/4165
-			if len(pending.model.ProjectorPaths) > 0 && numParallel != 1 {
-				numParallel = 1
-				slog.Warn("multimodal models don't support parallel requests yet")
+		// Move all waiting requests into the queue so the next request is
+		// picked by priority rather than arrival
+		for drained := false; !drained; {
+			select {
+			case pending := <-s.pendingReqCh:
+				s.queue.push(pending)
+			default:
+				drained = true
 			}
+		}
 
-			for {
-				var runnerToExpire *runnerRef
-				s.loadedMu.Lock()
-				runner := s.loaded[pending.model.ModelPath]
-				loadedCount := len(s.loaded)
-				s.loadedMu.Unlock()
-				if runner != nil {
-					if runner.needsReload(ctx, pending) {
-						runnerToExpire = runner
-					} else {
-						// Runner is usable, return it
-						pending.useLoadedRunner(runner, s.finishedReqCh)
-						break
-					}
-				} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
-					slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
-					runnerToExpire = s.findRunnerToUnload()
+		pending := s.queue.pop()
+		// Block other requests until we get this pending request running
+		pending.schedAttempts++
+		if pending.origNumCtx == 0 {
+			pending.origNumCtx = pending.opts.NumCtx
+		}
+
+		if pending.ctx.Err() != nil {
+			slog.Debug("pending request cancelled or timed out, skipping scheduling")
+			continue
+		}
+		numParallel := int(envconfig.NumParallel())
+		// TODO (jmorganca): multimodal models don't support parallel yet
+		// see https://github.com/ollama/ollama/issues/4165
+		if len(pending.model.ProjectorPaths) > 0 && numParallel != 1 {
+			numParallel = 1
+			slog.Warn("multimodal models don't support parallel requests yet")
+		}
+
+		for {
+			var runnerToExpire *runnerRef
+			s.loadedMu.Lock()
+			runner := s.loaded[pending.model.ModelPath]
+			loadedCount := len(s.loaded)
+			s.loadedMu.Unlock()
+			if runner != nil {
+				if runner.needsReload(ctx, pending) {
+					runnerToExpire = runner
 				} else {
-					// Either no models are loaded or below envconfig.MaxRunners
-					// Get a refreshed GPU list
-					var gpus gpu.GpuInfoList
-					if pending.opts.NumGPU == 0 {
-						gpus = s.getCpuFn()
-					} else {
-						gpus = s.getGpuFn()
-					}
+					// Runner is usable, return it
+					pending.useLoadedRunner(runner, s.finishedReqCh)
+					break
+				}
+			} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
+				slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
+				runnerToExpire = s.findRunnerToUnload()
+			} else {
+				// Either no models are loaded or below envconfig.MaxRunners
+				// Get a refreshed GPU list
+				var gpus gpu.GpuInfoList
+				if pending.opts.NumGPU == 0 {
+					gpus = s.getCpuFn()
+				} else {
+					gpus = s.getGpuFn()
+				}
 
-					if envconfig.MaxRunners() <= 0 {
-						// No user specified MaxRunners, so figure out what automatic setting to use
-						// If all GPUs have reliable free memory reporting, defaultModelsPerGPU * the number of GPUs
-						// if any GPU has unreliable free memory reporting, 1x the number of GPUs
-						allReliable := true
-						for _, gpu := range gpus {
-							if gpu.UnreliableFreeMemory {
-								allReliable = false
-								break
-							}
-						}
-						if allReliable {
-							// HACK
-							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
-							slog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners, "gpu_count", len(gpus))
-						} else {
-							// HACK
-							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
-							slog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
+				if envconfig.MaxRunners() <= 0 {
+					// No user specified MaxRunners, so figure out what automatic setting to use
+					// If all GPUs have reliable free memory reporting, defaultModelsPerGPU * the number of GPUs
+					// if any GPU has unreliable free memory reporting, 1x the number of GPUs
+					allReliable := true
+					for _, gpu := range gpus {
+						if gpu.UnreliableFreeMemory {
+							allReliable = false
+							break
 						}
 					}
-
-					// Load model for fitting
-					ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
-					if err != nil {
-						pending.errCh <- err
-						break
+					if allReliable {
+						// HACK
+						os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
+						slog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners, "gpu_count", len(gpus))
+					} else {
+						// HACK
+						os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
+						slog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
 					}
+				}
 
-					// Evaluate if the model will fit in the available system memory, or if we should unload a model first
-					if len(gpus) == 1 && gpus[0].Library == "cpu" {
-						// simplifying assumption of defaultParallel when in CPU mode
-						if numParallel <= 0 {
-							numParallel = defaultParallel
-						}
+				// Load model for fitting
+				ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
+				if err != nil {
+					pending.errCh <- err
+					break
+				}
 
-						pending.opts.NumCtx = pending.origNumCtx * numParallel
+				// Evaluate if the model will fit in the available system memory, or if we should unload a model first
+				if len(gpus) == 1 && gpus[0].Library == "cpu" {
+					// simplifying assumption of defaultParallel when in CPU mode
+					if numParallel <= 0 {
+						numParallel = defaultParallel
+					}
 
-						if loadedCount == 0 {
-							slog.Debug("cpu mode with first model, loading")
-							s.loadFn(pending, ggml, gpus, numParallel)
-							break
-						}
-						runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
-						if runnerToExpire == nil {
-							slog.Debug("cpu mode with available system memory or first model, loading")
-							s.loadFn(pending, ggml, gpus, numParallel)
-							break
-						}
-						// else we need to expire a runner
-					} else if loadedCount == 0 {
-						// No models loaded. Load the model but prefer the best fit.
-						slog.Debug("loading first model", "model", pending.model.ModelPath)
-						g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
-						if g != nil {
-							gpus = g
-						} else {
-							// Only allow partial loads when this is the first model
-							gpus = pickBestPartialFitByLibrary(pending, ggml, gpus, &numParallel)
-						}
+					pending.opts.NumCtx = pending.origNumCtx * numParallel
+
+					if loadedCount == 0 {
+						slog.Debug("cpu mode with first model, loading")
 						s.loadFn(pending, ggml, gpus, numParallel)
 						break
 					}
-
-					if runnerToExpire == nil {
-						// More than one loaded model, so we have to see if the
-						// new one fits
-						//
-						// We want to avoid loading on any GPUs that have other
-						// models still loading on them to avoid potential races
-						// with VRAM consumption ramping up during load
-						availGpus := s.filterGPUsWithoutLoadingModels(gpus)
-
-						// Update free memory from currently loaded models
-						s.updateFreeSpace(availGpus)
-						fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
-						if fitGpus != nil {
-							slog.Debug("new model fits with existing models, loading")
-							s.loadFn(pending, ggml, fitGpus, numParallel)
-							break
-						}
-
-						// We couldn't find a set of GPUs to fully load the new
-						// model. If no other models are loading (both GPU lists
-						// are the same) then we need to unload another model to
-						// make room
-						if len(availGpus) < len(gpus) {
-							// There are other requests pending, and this one
-							// needs more time, so put it on the back of the
-							// queue so that we might satisfy other pending
-							// requests that aren't blocked
-							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
-								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
-								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
-							}()
-							break
-						}
-						runnerToExpire = s.findRunnerToUnload()
+					var fits bool
+					runnerToExpire, fits = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
+					if fits {
+						slog.Debug("cpu mode with available system memory or first model, loading")
+						s.loadFn(pending, ggml, gpus, numParallel)
+						break
+					} else if runnerToExpire == nil {
+						slog.Warn("unable to find a runner to unload, all loaded runners are pinned", "model", pending.model.ModelPath)
+						pending.errCh <- ErrPinned
+						break
 					}
+					// else we need to expire a runner
+				} else if loadedCount == 0 {
+					// No models loaded. Load the model but prefer the best fit.
+					slog.Debug("loading first model", "model", pending.model.ModelPath)
+					g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
+					if g != nil {
+						gpus = g
+					} else {
+						// Only allow partial loads when this is the first model
+						gpus = pickBestPartialFitByLibrary(pending, ggml, gpus, &numParallel)
+					}
+					s.loadFn(pending, ggml, gpus, numParallel)
+					break
 				}
 
 				if runnerToExpire == nil {
-					// Shouildn't happen
-					slog.Error("runner to expire was nil!")
-					continue
-				}
-				// Trigger an expiration to unload once it's done
-				runnerToExpire.refMu.Lock()
-				slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
-				if runnerToExpire.expireTimer != nil {
-					runnerToExpire.expireTimer.Stop()
-					runnerToExpire.expireTimer = nil
-				}
-				runnerToExpire.sessionDuration = 0
-				if runnerToExpire.refCount <= 0 {
-					s.expiredCh <- runnerToExpire
-				}
-				runnerToExpire.refMu.Unlock()
-				// Wait for the unload to happen
-				// Note: at this point we're queueing up all incoming requests, even if they were for
-				// a different model that's loaded and not scheduled to be removed.
-				slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
-				select {
-				case <-ctx.Done():
-					slog.Debug("shutting down scheduler pending loop")
-					return
-				case <-s.unloadedCh:
-					slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
-					continue
+					// More than one loaded model, so we have to see if the
+					// new one fits
+					//
+					// We want to avoid loading on any GPUs that have other
+					// models still loading on them to avoid potential races
+					// with VRAM consumption ramping up during load
+					availGpus := s.filterGPUsWithoutLoadingModels(gpus)
+
+					// Update free memory from currently loaded models
+					s.updateFreeSpace(availGpus)
+					fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
+					if fitGpus != nil {
+						slog.Debug("new model fits with existing models, loading")
+						s.loadFn(pending, ggml, fitGpus, numParallel)
+						break
+					}
+
+					// We couldn't find a set of GPUs to fully load the new
+					// model. If no other models are loading (both GPU lists
+					// are the same) then we need to unload another model to
+					// make room
+					if len(availGpus) < len(gpus) {
+						// There are other requests pending, and this one
+						// needs more time, so put it on the back of the
+						// queue so that we might satisfy other pending
+						// requests that aren't blocked
+						go func() {
+							// Process in a go routine to avoid deadlocking
+							// the scheduler if our queue is full
+							slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
+							time.Sleep(s.reschedDelay)
+							s.pendingReqCh <- pending
+						}()
+						break
+					}
+					runnerToExpire = s.findRunnerToUnload()
 				}
 			}
-		case <-s.unloadedCh:
-			// An unload request when there are no pending request can be ignored
-			slog.Debug("ignoring unload event with no pending requests")
+
+			if runnerToExpire == nil {
+				// Every loaded runner is pinned so there's no room for this model
+				slog.Warn("unable to find a runner to unload, all loaded runners are pinned", "model", pending.model.ModelPath)
+				pending.errCh <- ErrPinned
+				break
+			}
+			// Trigger an expiration to unload once it's done
+			slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath)
+			s.expire(runnerToExpire)
+			// Wait for the unload to happen
+			// Note: at this point we're queueing up all incoming requests, even if they were for
+			// a different model that's loaded and not scheduled to be removed.
+			slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case <-s.unloadedCh:
+				slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
+				continue
+			}
+		}
+	}
+}
+
+// pendingQueue holds requests waiting to be scheduled. Requests are
+// taken by their aged priority, then in the order they arrived.
+type pendingQueue struct {
+	mu   sync.Mutex
+	reqs []*LlmRequest
+}
+
+func (q *pendingQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.reqs)
+}
+
+func (q *pendingQueue) push(req *LlmRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if req.queued.IsZero() {
+		req.queued = time.Now()
+	}
+	q.reqs = append(q.reqs, req)
+}
+
+// pop removes and returns the next request to schedule, or nil if the
+// queue is empty
+func (q *pendingQueue) pop() *LlmRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.reqs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	next := 0
+	for i, req := range q.reqs[1:] {
+		a, b := req.priority.Aged(now.Sub(req.queued)), q.reqs[next].priority.Aged(now.Sub(q.reqs[next].queued))
+		if a > b || (a == b && req.queued.Before(q.reqs[next].queued)) {
+			next = i + 1
 		}
 	}
+
+	req := q.reqs[next]
+	q.reqs = append(q.reqs[:next], q.reqs[next+1:]...)
+	return req
 }
 
 func (s *Scheduler) processCompleted(ctx context.Context) {
@@ -323,7 +399,9 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			runner.refMu.Lock()
 			runner.refCount--
 			if runner.refCount <= 0 {
-				if runner.sessionDuration <= 0 {
+				if runner.pinned {
+					slog.Debug("pinned runner has gone idle", "modelPath", runner.modelPath)
+				} else if runner.sessionDuration <= 0 {
 					slog.Debug("runner with zero duration has gone idle, expiring to unload", "modelPath", runner.modelPath)
 					if runner.expireTimer != nil {
 						runner.expireTimer.Stop()
@@ -367,11 +445,29 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 				continue
 			}
 
+			if runner.unloaded != nil {
+				select {
+				case <-runner.unloaded:
+					// The runner can be expired more than once, by its
+					// timer and by an unload, but it's only unloaded once
+					slog.Debug("ignoring expired event for unloaded runner", "modelPath", runner.modelPath)
+					runner.refMu.Unlock()
+					continue
+				default:
+				}
+			}
+
 			s.loadedMu.Lock()
 			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
 			finished := runner.waitForVRAMRecovery()
 			runner.unload()
-			delete(s.loaded, runner.modelPath)
+			// a new runner may have been loaded for the model already
+			if s.loaded[runner.modelPath] == runner {
+				delete(s.loaded, runner.modelPath)
+			}
+			if runner.unloaded != nil {
+				close(runner.unloaded)
+			}
 			s.loadedMu.Unlock()
 			slog.Debug("runner released", "modelPath", runner.modelPath)
 			runner.refMu.Unlock()
@@ -383,6 +479,69 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 	}
 }
 
+// expire resets the runner to expire immediately, even if it is pinned. It
+// is unloaded as soon as it has no requests in flight, and new requests load
+// another runner instead of using it.
+func (s *Scheduler) expire(runner *runnerRef) {
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	slog.Debug("expiring runner", "modelPath", runner.modelPath, "refCount", runner.refCount)
+	if runner.expireTimer != nil {
+		runner.expireTimer.Stop()
+		runner.expireTimer = nil
+	}
+	runner.pinned = false
+	runner.unloading = true
+	runner.sessionDuration = 0
+	if runner.refCount <= 0 {
+		s.expiredCh <- runner
+	}
+}
+
+// pin keeps the runner, and any runner later loaded for the same model,
+// loaded until the model is explicitly unloaded
+func (s *Scheduler) pin(runner *runnerRef) {
+	s.loadedMu.Lock()
+	if s.pinned == nil {
+		s.pinned = make(map[string]bool)
+	}
+	s.pinned[runner.modelPath] = true
+	s.loadedMu.Unlock()
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	if runner.unloading {
+		// the next runner loaded for the model is pinned instead
+		return
+	}
+	runner.pinned = true
+	if runner.expireTimer != nil {
+		runner.expireTimer.Stop()
+		runner.expireTimer = nil
+	}
+}
+
+// unload unpins and expires the runner for the model and waits until it is
+// unloaded. It returns nil if the model isn't loaded.
+func (s *Scheduler) unload(ctx context.Context, model *Model) (*runnerRef, error) {
+	s.loadedMu.Lock()
+	delete(s.pinned, model.ModelPath)
+	runner := s.loaded[model.ModelPath]
+	s.loadedMu.Unlock()
+	if runner == nil {
+		return nil, nil
+	}
+
+	s.expire(runner)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-runner.unloaded:
+		return runner, nil
+	}
+}
+
 // Complete the pending request and send the runner back to the requester
 // Wires up a finished event after the request context is completed
 // Updates session duration, and resets expiration timer
@@ -413,6 +572,7 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList,
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
+	start := time.Now()
 	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
@@ -436,11 +596,13 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList,
 		estimatedTotal:  llama.EstimatedTotal(),
 		loading:         true,
 		refCount:        1,
+		unloaded:        make(chan struct{}),
 	}
 	runner.numParallel = numParallel
 	runner.refMu.Lock()
 
 	s.loadedMu.Lock()
+	runner.pinned = s.pinned[req.model.ModelPath]
 	s.loaded[req.model.ModelPath] = runner
 	slog.Info("loaded runners", "count", len(s.loaded))
 	s.loadedMu.Unlock()
@@ -457,6 +619,7 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList,
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
 		runner.loading = false
+		runner.loadDuration = time.Since(start)
 		go func() {
 			<-req.ctx.Done()
 			slog.Debug("context for request finished")
@@ -533,14 +696,16 @@ func (s *Scheduler) filterGPUsWithoutLoadingModels(allGpus gpu.GpuInfoList) gpu.
 type runnerRef struct {
 	refMu sync.Mutex
 	// refCond   sync.Cond // Signaled on transition from 1 -> 0 refCount
-	refCount uint // prevent unloading if > 0
-	// unloading bool      // set to true when we are trying to unload the runner
+	refCount  uint // prevent unloading if > 0
+	unloading bool // set to true when we are trying to unload the runner
 
 	llama          llm.LlamaServer
 	loading        bool            // True only during initial load, then false forever
 	gpus           gpu.GpuInfoList // Recorded at time of provisioning
 	estimatedVRAM  uint64
 	estimatedTotal uint64
+	loadDuration   time.Duration
+	pinned         bool // never unloaded to make room or when the session expires
 
 	sessionDuration time.Duration
 	expireTimer     *time.Timer
@@ -550,6 +715,9 @@ type runnerRef struct {
 	modelPath   string
 	numParallel int
 	*api.Options
+
+	// unloaded is closed once the runner has been unloaded
+	unloaded chan struct{}
 }
 
 // The refMu must already be held when calling unload
@@ -559,6 +727,9 @@ func (runner *runnerRef) unload() {
 		runner.expireTimer = nil
 	}
 	if runner.llama != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), saveSessionsTimeout)
+		runner.saveSessions(ctx)
+		cancel()
 		runner.llama.Close()
 	}
 	runner.model = nil
@@ -567,6 +738,18 @@ func (runner *runnerRef) unload() {
 	runner.gpus = nil
 }
 
+// saveSessionsTimeout bounds how long unloading waits for runners to save
+// their session caches. Caches that aren't saved in time are lost.
+var saveSessionsTimeout = 15 * time.Second
+
+// saveSessions writes the runner's session caches to disk so they can be
+// restored when the model is loaded again
+func (runner *runnerRef) saveSessions(ctx context.Context) {
+	if err := runner.llama.SaveSessions(ctx); err != nil {
+		slog.Warn("failed to save sessions", "model", runner.modelPath, "error", err)
+	}
+}
+
 func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool {
 	slog.Debug("evaluating already loaded", "model", req.model.ModelPath)
 	runner.refMu.Lock()
@@ -577,7 +760,7 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 		timeout = 2 * time.Minute // Initial load can take a long time for big models on slow systems...
 	}
 
-	if runner.Options == nil {
+	if runner.Options == nil || runner.unloading {
 		return true
 	}
 
@@ -756,7 +939,9 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	s.loadedMu.Lock()
 	runnerList := make([]*runnerRef, 0, len(s.loaded))
 	for _, r := range s.loaded {
-		runnerList = append(runnerList, r)
+		if !s.pinned[r.modelPath] {
+			runnerList = append(runnerList, r)
+		}
 	}
 	s.loadedMu.Unlock()
 	if len(runnerList) == 0 {
@@ -786,6 +971,23 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 func (s *Scheduler) unloadAllRunners() {
 	s.loadedMu.Lock()
 	defer s.loadedMu.Unlock()
+
+	// save the sessions of all runners at once so shutting down takes no
+	// longer than a single save
+	ctx, cancel := context.WithTimeout(context.Background(), saveSessionsTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, runner := range s.loaded {
+		if runner.llama != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runner.saveSessions(ctx)
+			}()
+		}
+	}
+	wg.Wait()
+
 	for model, runner := range s.loaded {
 		if runner.llama != nil {
 			slog.Debug("shutting down runner", "model", model)
@@ -795,16 +997,17 @@ func (s *Scheduler) unloadAllRunners() {
 }
 
 // If other runners are loaded, make sure the pending request will fit in system memory
-// If not, pick a runner to unload, else return nil and the request can be loaded
-func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) *runnerRef {
+// If it does, return true and the request can be loaded, else pick a runner to unload,
+// which is nil if every loaded runner is pinned
+func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, ggml *llm.GGML, gpus gpu.GpuInfoList) (*runnerRef, bool) {
 	slog.Debug("evaluating if CPU model load will fit in available system memory")
 	estimate := llm.EstimateGPULayers(gpus, ggml, req.model.ProjectorPaths, req.opts)
 	if estimate.TotalSize <= gpus[0].FreeMemory {
 		slog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
-		return nil
+		return nil, true
 	}
 
 	// TODO - optimization: try to find CPU only runners first, or partial offloads with enough in system memory to make room
 
-	return s.findRunnerToUnload()
+	return s.findRunnerToUnload(), false
 }
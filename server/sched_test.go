@@ -6,6 +6,7 @@ import (
 	"errors"
 	"log/slog"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -407,6 +408,41 @@ func TestGetRunner(t *testing.T) {
 	b.ctxDone()
 }
 
+func TestGetRunnerPriority(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	s := InitScheduler(ctx)
+	s.GetRunner(llm.WithPriority(ctx, llm.PriorityHigh), &Model{}, api.Options{}, nil)
+	require.Len(t, s.pendingReqCh, 1)
+	req := <-s.pendingReqCh
+	require.Equal(t, llm.PriorityHigh, req.priority)
+}
+
+func TestPendingQueue(t *testing.T) {
+	now := time.Now()
+	reqs := []*LlmRequest{
+		{priority: llm.PriorityLow, queued: now.Add(-time.Second)},
+		{priority: llm.PriorityNormal, queued: now},
+		{priority: llm.PriorityHigh, queued: now.Add(time.Millisecond)},
+		{priority: llm.PriorityNormal, queued: now.Add(-time.Millisecond)},
+		// waited long enough to be treated as high priority
+		{priority: llm.PriorityLow, queued: now.Add(-time.Hour)},
+	}
+
+	var q pendingQueue
+	for _, req := range reqs {
+		q.push(req)
+	}
+	require.Equal(t, 5, q.Len())
+
+	for _, want := range []*LlmRequest{reqs[4], reqs[2], reqs[3], reqs[1], reqs[0]} {
+		require.Same(t, want, q.pop())
+	}
+	require.Nil(t, q.pop())
+	require.Equal(t, 0, q.Len())
+}
+
 // TODO - add one scenario that triggers the bogus finished event with positive ref count
 func TestPrematureExpired(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
@@ -569,6 +605,75 @@ func TestFindRunnerToUnload(t *testing.T) {
 	require.Equal(t, r1, resp)
 }
 
+func TestFindRunnerToUnloadPinned(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	r1 := &runnerRef{modelPath: "a", sessionDuration: 1, numParallel: 1, pinned: true}
+	r2 := &runnerRef{modelPath: "b", refCount: 1, sessionDuration: 2, numParallel: 1}
+
+	s := InitScheduler(ctx)
+	s.loadedMu.Lock()
+	s.loaded["a"] = r1
+	s.loaded["b"] = r2
+	s.pinned = map[string]bool{"a": true}
+	s.loadedMu.Unlock()
+
+	require.Equal(t, r2, s.findRunnerToUnload())
+
+	s.pin(r2)
+	require.Nil(t, s.findRunnerToUnload())
+}
+
+func TestRequestsCPUPinned(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	var free atomic.Uint64
+	free.Store(26 * format.GigaByte)
+	s.getGpuFn = func() gpu.GpuInfoList {
+		g := getCpuFn()
+		g[0].FreeMemory = free.Load()
+		return g
+	}
+	s.getCpuFn = s.getGpuFn
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	b := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, resp.llama, a.srv)
+		s.pin(resp)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// the second model doesn't fit in system memory and the first can't be unloaded
+	free.Store(0)
+
+	s.newServerFn = b.newServer
+	s.pendingReqCh <- b.req
+	select {
+	case <-b.req.successCh:
+		t.Fatal("expected the model not to load")
+	case err := <-b.req.errCh:
+		require.ErrorIs(t, err, ErrPinned)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.loadedMu.Lock()
+	require.Len(t, s.loaded, 1)
+	s.loadedMu.Unlock()
+}
+
 func TestNeedsReload(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer done()
@@ -614,6 +719,52 @@ func TestNeedsReload(t *testing.T) {
 	req.opts.NumGPU = -1
 	resp = runner.needsReload(ctx, req)
 	require.False(t, resp)
+	runner.unloading = true
+	resp = runner.needsReload(ctx, req)
+	require.True(t, resp)
+}
+
+func TestDuplicateExpired(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+
+	s := InitScheduler(ctx)
+	r1 := &runnerRef{llama: &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}, modelPath: "a", unloaded: make(chan struct{})}
+	s.loaded["a"] = r1
+	s.Run(ctx)
+
+	s.expire(r1)
+	select {
+	case <-r1.unloaded:
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// a late expired event for the unloaded runner leaves the runner since
+	// loaded for the same model alone
+	llm2 := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
+	r2 := &runnerRef{llama: llm2, modelPath: "a", unloaded: make(chan struct{})}
+	s.loadedMu.Lock()
+	s.loaded["a"] = r2
+	s.loadedMu.Unlock()
+	s.expiredCh <- r1
+
+	// events are handled in order, so r1's is done once b is unloaded
+	r3 := &runnerRef{llama: &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}, modelPath: "b", unloaded: make(chan struct{})}
+	s.loadedMu.Lock()
+	s.loaded["b"] = r3
+	s.loadedMu.Unlock()
+	s.expiredCh <- r3
+	select {
+	case <-r3.unloaded:
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	require.Same(t, r2, s.loaded["a"])
+	require.False(t, llm2.closeCalled)
 }
 
 func TestUnloadAllRunners(t *testing.T) {
@@ -634,6 +785,45 @@ func TestUnloadAllRunners(t *testing.T) {
 	s.loadedMu.Unlock()
 	s.unloadAllRunners()
 
+	require.True(t, llm1.closeCalled)
+	require.True(t, llm2.closeCalled)
+	require.True(t, llm1.sessionsSaved)
+}
+
+func TestUnloadAllRunnersSavesInParallel(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	start := make(chan struct{})
+	release := make(chan struct{})
+	llm1 := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}, saveSessionsStart: start, saveSessionsDone: release}
+	llm2 := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}, saveSessionsStart: start, saveSessionsDone: release}
+	s := InitScheduler(ctx)
+	s.loaded["a"] = &runnerRef{llama: llm1, numParallel: 1}
+	s.loaded["b"] = &runnerRef{llama: llm2, numParallel: 1}
+
+	unloaded := make(chan struct{})
+	go func() {
+		s.unloadAllRunners()
+		close(unloaded)
+	}()
+
+	// both saves are in flight before either of them finishes
+	for range 2 {
+		select {
+		case <-start:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for sessions to be saved in parallel")
+		}
+	}
+	close(release)
+
+	select {
+	case <-unloaded:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for runners to unload")
+	}
+
 	require.True(t, llm1.closeCalled)
 	require.True(t, llm2.closeCalled)
 }
@@ -643,6 +833,7 @@ func TestUnload(t *testing.T) {
 	r1 := &runnerRef{llama: llm1, numParallel: 1}
 	r2 := &runnerRef{model: &Model{AdapterPaths: []string{"A"}}, numParallel: 1}
 	r1.unload()
+	require.True(t, llm1.sessionsSaved)
 	require.True(t, llm1.closeCalled)
 	r2.unload()
 	require.Nil(t, r2.model)
@@ -716,6 +907,9 @@ type mockLlm struct {
 	detonekizeRespErr  error
 	closeResp          error
 	closeCalled        bool
+	sessionsSaved      bool
+	saveSessionsStart  chan struct{} // signalled when SaveSessions starts if set
+	saveSessionsDone   chan struct{} // SaveSessions waits for it to be closed if set
 	estimatedVRAM      uint64
 	estimatedTotal     uint64
 	estimatedVRAMByGPU map[string]uint64
@@ -731,6 +925,23 @@ func (s *mockLlm) Embedding(ctx context.Context, input string) ([]float32, error
 	return s.embeddingResp, s.embeddingRespErr
 }
 
+func (s *mockLlm) EmbeddingBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	if s.embeddingRespErr != nil {
+		return nil, s.embeddingRespErr
+	}
+
+	embeddings := make([][]float32, len(inputs))
+	for i := range embeddings {
+		embeddings[i] = s.embeddingResp
+	}
+
+	return embeddings, nil
+}
+
+func (s *mockLlm) Rerank(ctx context.Context, query string, documents []string) ([]float32, error) {
+	return make([]float32, len(documents)), s.embeddingRespErr
+}
+
 func (s *mockLlm) Tokenize(ctx context.Context, content string) ([]int, error) {
 	return s.tokenizeResp, s.tokenizeRespErr
 }
@@ -739,6 +950,25 @@ func (s *mockLlm) Detokenize(ctx context.Context, tokens []int) (string, error)
 	return s.detokenizeResp, s.detonekizeRespErr
 }
 
+func (s *mockLlm) SaveSessions(ctx context.Context) error {
+	s.sessionsSaved = true
+	if s.saveSessionsStart != nil {
+		s.saveSessionsStart <- struct{}{}
+	}
+	if s.saveSessionsDone != nil {
+		select {
+		case <-s.saveSessionsDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *mockLlm) NumParallel() int {
+	return 1
+}
+
 func (s *mockLlm) Close() error {
 	s.closeCalled = true
 	return s.closeResp
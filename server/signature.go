@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/auth"
+	"github.com/ollama/ollama/envconfig"
+)
+
+const (
+	signatureMediaType = "application/vnd.ollama.image.signature"
+
+	// maxSignatureSize limits the size of pulled signatures
+	maxSignatureSize = 64 << 10
+
+	signaturePolicyEnforce = "enforce"
+	signaturePolicyWarn    = "warn"
+)
+
+var (
+	errManifestUnsigned  = errors.New("manifest is not signed")
+	errManifestUntrusted = errors.New("manifest is not signed by a trusted key")
+)
+
+// manifestSignature is a detached signature of a manifest. It's pushed as
+// the config of a manifest tagged with [signatureTag], so registries store
+// it like any other model.
+type manifestSignature struct {
+	// Digest is the digest of the signed manifest
+	Digest string `json:"digest"`
+
+	// Signature is the signature of the digest made by [auth.Sign]
+	Signature string `json:"signature"`
+}
+
+// signatureTag returns the tag of the signature of the manifest with digest
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// pushSignature signs the manifest with digest and pushes the signature
+func pushSignature(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	signature, err := auth.Sign(ctx, []byte(digest))
+	if err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(manifestSignature{Digest: digest, Signature: signature})
+	if err != nil {
+		return err
+	}
+
+	layer, err := NewLayer(bytes.NewReader(bts), signatureMediaType)
+	if err != nil {
+		return err
+	}
+
+	if err := uploadBlob(ctx, mp, layer, regOpts, fn); err != nil {
+		return err
+	}
+
+	manifestJSON, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     manifestMediaType,
+		Config:        layer,
+		Layers:        []Layer{},
+	})
+	if err != nil {
+		return err
+	}
+
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", signatureTag(digest))
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", manifestMediaType)
+	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// only the registry needs to keep the signature
+	if err := layer.Remove(); err != nil {
+		slog.Warn("couldn't remove signature", "digest", layer.Digest, "error", err)
+	}
+
+	return nil
+}
+
+// verifyManifest verifies the signature of the manifest of mp with digest
+// against the keys trusted for its namespace. It returns the public key of
+// the signer.
+func verifyManifest(ctx context.Context, mp ModelPath, sources []pullSource, digest string) (string, error) {
+	keys, err := readTrustedKeys(envconfig.TrustedKeys())
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := pullSignature(ctx, mp, sources, digest)
+	if err != nil {
+		return "", err
+	}
+
+	// signatures of other manifests could otherwise be copied to this one
+	if signature.Digest != digest {
+		return "", fmt.Errorf("%w: signature is of %s", errManifestUntrusted, signature.Digest)
+	}
+
+	publicKey, err := auth.Verify([]byte(signature.Digest), signature.Signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errManifestUntrusted, err)
+	}
+
+	trusted := slices.Concat(keys[mp.Registry+"/"+mp.Namespace], keys[mp.Namespace])
+	if !slices.Contains(trusted, publicKey) {
+		return "", fmt.Errorf("%w: %s isn't trusted for %s", errManifestUntrusted, publicKey, mp.Namespace)
+	}
+
+	return publicKey, nil
+}
+
+// pullSignature pulls the signature of the manifest with digest from the
+// first of sources that has it
+func pullSignature(ctx context.Context, mp ModelPath, sources []pullSource, digest string) (*manifestSignature, error) {
+	smp := mp
+	smp.Tag = signatureTag(digest)
+	smp.Digest = ""
+
+	var unsigned bool
+	var err error
+	for _, source := range sources {
+		var signature *manifestSignature
+		signature, err = source.pullSignature(ctx, smp)
+		if err == nil || ctx.Err() != nil {
+			return signature, err
+		} else if errors.Is(err, os.ErrNotExist) {
+			unsigned = true
+			continue
+		}
+
+		slog.Warn("couldn't pull signature", "model", mp.GetShortTagname(), "error", err)
+	}
+
+	if unsigned {
+		return nil, errManifestUnsigned
+	}
+
+	return nil, err
+}
+
+func (s pullSource) pullSignature(ctx context.Context, mp ModelPath) (*manifestSignature, error) {
+	m, _, err := s.pullManifest(ctx, mp)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Config.MediaType != signatureMediaType || m.Config.Size > maxSignatureSize {
+		return nil, fmt.Errorf("invalid signature manifest %s", mp.Tag)
+	}
+
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, s.url(mp, "blobs", m.Config.Digest), nil, nil, s.regOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(bts)); digest != m.Config.Digest {
+		return nil, fmt.Errorf("%w: want %s, got %s", errDigestMismatch, m.Config.Digest, digest)
+	}
+
+	var signature manifestSignature
+	if err := json.Unmarshal(bts, &signature); err != nil {
+		return nil, err
+	}
+
+	return &signature, nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestManifestSignatures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRY", "1")
+	t.Setenv("OLLAMA_REGISTRY_PUSH", "1")
+	// pushes replace the models they're pushed from
+	t.Setenv("OLLAMA_REGISTRY_OVERWRITE", "1")
+
+	publicKey := newTestKey(t, home)
+	writePushKeys(t, map[string][]string{"library": {publicKey}})
+
+	var s Server
+	for _, name := range []string{"signed", "unsigned"} {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Model:     name,
+			Modelfile: fmt.Sprintf("FROM %s\nSYSTEM %s", createBinFile(t, llm.KV{"general.architecture": "llama"}, nil), name),
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+	}
+
+	// the server's registry stands in for a remote one
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+	t.Setenv("OLLAMA_REGISTRY_URL", srv.URL)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"signed", "unsigned"} {
+		if err := CopyModel(model.ParseName(name), model.ParseName(u.Host+"/library/"+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	regOpts := func() *registryOptions { return &registryOptions{Insecure: true} }
+	discard := func(api.ProgressResponse) {}
+
+	if err := PushModel(context.TODO(), "http://"+u.Host+"/library/signed", regOpts(), true, discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PushModel(context.TODO(), "http://"+u.Host+"/library/unsigned", regOpts(), false, discard); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTrustedKeys := func(t *testing.T, keys map[string][]string) {
+		t.Helper()
+		bts, err := json.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := filepath.Join(t.TempDir(), "trusted_keys.json")
+		if err := os.WriteFile(p, bts, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("OLLAMA_TRUSTED_KEYS", p)
+	}
+
+	pull := func(t *testing.T, name string) ([]string, error) {
+		t.Helper()
+		var statuses []string
+		err := PullModel(context.TODO(), "http://"+u.Host+"/library/"+name, regOpts(), func(resp api.ProgressResponse) {
+			if resp.Digest == "" {
+				statuses = append(statuses, resp.Status)
+			}
+		})
+		return statuses, err
+	}
+
+	pulled := func(name string) bool {
+		_, err := ParseNamedManifest(model.ParseName(u.Host + "/library/" + name))
+		return err == nil
+	}
+
+	otherKey := newTestKey(t, t.TempDir())
+
+	cases := []struct {
+		name   string
+		model  string
+		policy string
+		keys   map[string][]string
+		err    error
+		warn   bool
+	}{
+		{name: "trusted", model: "signed", policy: "enforce", keys: map[string][]string{"library": {publicKey + " ci@example.com"}}},
+		{name: "trusted host", model: "signed", policy: "enforce", keys: map[string][]string{u.Host + "/library": {publicKey}}},
+		{name: "untrusted", model: "signed", policy: "enforce", keys: map[string][]string{"library": {otherKey}}, err: errManifestUntrusted},
+		{name: "other namespace", model: "signed", policy: "enforce", keys: map[string][]string{"models": {publicKey}}, err: errManifestUntrusted},
+		{name: "unsigned", model: "unsigned", policy: "enforce", keys: map[string][]string{"library": {publicKey}}, err: errManifestUnsigned},
+		{name: "warn untrusted", model: "signed", policy: "warn", keys: map[string][]string{"library": {otherKey}}, warn: true},
+		{name: "warn unsigned", model: "unsigned", policy: "warn", keys: map[string][]string{"library": {publicKey}}, warn: true},
+		{name: "disabled", model: "unsigned"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_VERIFY_SIGNATURES", tt.policy)
+			writeTrustedKeys(t, tt.keys)
+
+			if pulled(tt.model) {
+				if w := createRequest(t, s.DeleteModelHandler, api.DeleteRequest{Name: u.Host + "/library/" + tt.model}); w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d", w.Code)
+				}
+			}
+
+			statuses, err := pull(t, tt.model)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			// rejected manifests aren't written
+			if got := pulled(tt.model); got != (tt.err == nil) {
+				t.Errorf("expected pulled %v, got %v", tt.err == nil, got)
+			}
+
+			warned := slices.ContainsFunc(statuses, func(s string) bool { return strings.HasPrefix(s, "warning: ") })
+			if warned != tt.warn {
+				t.Errorf("expected warning %v, got statuses %v", tt.warn, statuses)
+			}
+
+			if verified := slices.Contains(statuses, "verifying signature"); verified != (tt.policy != "") {
+				t.Errorf("expected verifying %v, got statuses %v", tt.policy != "", statuses)
+			}
+		})
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		t.Setenv("OLLAMA_VERIFY_SIGNATURES", "always")
+		if _, err := pull(t, "signed"); err == nil || !strings.Contains(err.Error(), "invalid signature policy") {
+			t.Fatalf("expected invalid policy error, got %v", err)
+		}
+	})
+}
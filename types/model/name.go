@@ -91,6 +91,10 @@ type Name struct {
 	Namespace string
 	Model     string
 	Tag       string
+
+	// Digest pins the name to the manifest with this digest, in the form
+	// "sha256:<hex>". It's empty if the name isn't pinned.
+	Digest string
 }
 
 // ParseName parses and assembles a Name from a name string. The
@@ -123,8 +127,8 @@ type Name struct {
 //	      pattern: { alphanum | "_" } { alphanum | "-" | "_" | "." }*
 //	      length:  [1, 80]
 //	  digest:
-//	      pattern: { alphanum | "_" } { alphanum | "-" | ":" }*
-//	      length:  [1, 80]
+//	      pattern: "sha256:" { hex }*
+//	      length:  71
 //
 // Most users should use [ParseName] instead, unless need to support
 // different defaults than DefaultName.
@@ -142,6 +146,10 @@ func ParseNameBare(s string) Name {
 	var n Name
 	var promised bool
 
+	// "@" is an illegal character in every other part, so the digest is
+	// split first
+	s, n.Digest, _ = cutPromised(s, "@")
+
 	// "/" is an illegal tag character, so we can use it to split the host
 	if strings.LastIndex(s, ":") > strings.LastIndex(s, "/") {
 		s, n.Tag, _ = cutPromised(s, ":")
@@ -216,6 +224,10 @@ func (n Name) String() string {
 		b.WriteByte(':')
 		b.WriteString(n.Tag)
 	}
+	if n.Digest != "" {
+		b.WriteByte('@')
+		b.WriteString(n.Digest)
+	}
 	return b.String()
 }
 
@@ -237,6 +249,11 @@ func (n Name) DisplayShortest() string {
 	sb.WriteString(n.Model)
 	sb.WriteString(":")
 	sb.WriteString(n.Tag)
+
+	if n.Digest != "" {
+		sb.WriteByte('@')
+		sb.WriteString(n.Digest)
+	}
 	return sb.String()
 }
 
@@ -248,11 +265,25 @@ func IsValidNamespace(s string) bool {
 
 // IsValid reports whether all parts of the name are present and valid. The
 // digest is a special case, and is checked for validity only if present.
-//
-// Note: The digest check has been removed as is planned to be added back in
-// at a later time.
 func (n Name) IsValid() bool {
-	return n.IsFullyQualified()
+	return n.IsFullyQualified() && (n.Digest == "" || IsValidDigest(n.Digest))
+}
+
+// IsValidDigest reports whether s is a valid manifest digest in the form
+// "sha256:<hex>".
+func IsValidDigest(s string) bool {
+	hex, ok := strings.CutPrefix(s, "sha256:")
+	if !ok || len(hex) != 64 {
+		return false
+	}
+
+	for i := range hex {
+		if (hex[i] < '0' || hex[i] > '9') && (hex[i] < 'a' || hex[i] > 'f') {
+			return false
+		}
+	}
+
+	return true
 }
 
 // IsFullyQualified returns true if all parts of the name are present and
@@ -4,10 +4,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 )
 
 const (
+	digest  = "sha256:1000000000000000000000000000000000000000000000000000000000000000"
 	part80  = "88888888888888888888888888888888888888888888888888888888888888888888888888888888"
 	part350 = "33333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333"
 )
@@ -122,6 +124,25 @@ func TestParseNameParts(t *testing.T) {
 			},
 			wantFilepath: filepath.Join(part350, part80, part80, part80),
 		},
+		{
+			in: "host:port/namespace/model:tag@" + digest,
+			want: Name{
+				Host:      "host:port",
+				Namespace: "namespace",
+				Model:     "model",
+				Tag:       "tag",
+				Digest:    digest,
+			},
+			wantFilepath: filepath.Join("host:port", "namespace", "model", "tag"),
+		},
+		{
+			in: "model@" + digest,
+			want: Name{
+				Model:  "model",
+				Digest: digest,
+			},
+			wantFilepath: filepath.Join("registry.ollama.ai", "library", "model", "latest"),
+		},
 	}
 
 	for _, tt := range cases {
@@ -187,6 +208,15 @@ var testCases = map[string]bool{ // name -> valid
 
 	// colon in non-host part before tag
 	"host/name:space/model:tag": false,
+
+	// digests
+	"h/n/m:t@" + digest:                  true,
+	"h/n/m:t@sha256:abc":                 false,
+	"h/n/m:t@" + digest[7:]:              false,
+	"h/n/m:t@sha256-" + digest[7:]:       false,
+	"h/n/m:t@sha256:" + part80[:64]:      true,
+	"h/n/m:t@@" + digest:                 false,
+	"h/n/m:t@" + strings.ToUpper(digest): false,
 }
 
 func TestNameparseNameDefault(t *testing.T) {
@@ -290,11 +320,12 @@ func TestParseNameFromFilepath(t *testing.T) {
 
 func TestDisplayShortest(t *testing.T) {
 	cases := map[string]string{
-		"registry.ollama.ai/library/model:latest": "model:latest",
-		"registry.ollama.ai/library/model:tag":    "model:tag",
-		"registry.ollama.ai/namespace/model:tag":  "namespace/model:tag",
-		"host/namespace/model:tag":                "host/namespace/model:tag",
-		"host/library/model:tag":                  "host/library/model:tag",
+		"registry.ollama.ai/library/model:latest":        "model:latest",
+		"registry.ollama.ai/library/model:tag":           "model:tag",
+		"registry.ollama.ai/namespace/model:tag":         "namespace/model:tag",
+		"host/namespace/model:tag":                       "host/namespace/model:tag",
+		"host/library/model:tag":                         "host/library/model:tag",
+		"registry.ollama.ai/library/model:tag@" + digest: "model:tag@" + digest,
 	}
 
 	for in, want := range cases {